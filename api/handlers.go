@@ -4,6 +4,8 @@ import (
 	v1 "ip2country-service/api/v1"
 	"ip2country-service/config"
 	"ip2country-service/internal/database"
+	"ip2country-service/internal/health"
+	"ip2country-service/pkg/utils"
 	"net/http"
 
 	"github.com/gorilla/mux"
@@ -15,14 +17,68 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Service is up and running"))
 }
 
-// RegisterHandlers registers all the API routes and their corresponding handlers
+// NewHealthCheckHandler returns a health check that answers 503 once status
+// starts draining, so load balancers stop sending new requests during shutdown
+func NewHealthCheckHandler(status *health.Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if status != nil && status.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Service is shutting down"))
+			return
+		}
+		HealthCheckHandler(w, r)
+	}
+}
+
+// LivenessHandler reports that the process is up and serving HTTP. It checks
+// no dependencies, so an outage elsewhere never gets healthy pods restarted.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	utils.RespondWithJSON(w, http.StatusOK, health.Report{Status: health.StatusOK})
+}
+
+// NewReadinessHandler runs the dependency checks registered with status and
+// answers 503 if any of them fails, listing each check's result and latency
+func NewReadinessHandler(status *health.Status) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := status.Ready(r.Context())
+		code := http.StatusOK
+		if report.Status != health.StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		utils.RespondWithJSON(w, code, report)
+	}
+}
+
+// RegisterProbes registers the /livez and /readyz probes and the
+// /api/v1/health check. They belong on the root router, outside API key
+// authentication and rate limiting, so orchestrators and load balancers can
+// always reach them and see the health check drain during shutdown.
+func RegisterProbes(router *mux.Router, status *health.Status) {
+	router.HandleFunc("/livez", LivenessHandler).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", NewReadinessHandler(status)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v1/health", NewHealthCheckHandler(status)).Methods(http.MethodGet)
+}
+
+// RegisterHandlers registers all the API routes and their corresponding
+// handlers. The health check is registered by RegisterProbes.
 func RegisterHandlers(router *mux.Router, db database.IPDatabase, cfg *config.Config) {
 	// Create the handler for IP lookups
 	ipHandler := v1.NewIPHandler(db, cfg)
 
 	// Register API route for getting IP location
 	router.HandleFunc("/find-country", ipHandler.GetLocation).Methods(http.MethodGet)
+	router.HandleFunc("/find-country/batch", ipHandler.GetLocationsBatch).Methods(http.MethodPost)
+
+	// Register the description of the dataset answering lookups
+	datasetHandler := v1.NewDatasetHandler(db)
+	router.HandleFunc("/dataset", datasetHandler.GetDataset).Methods(http.MethodGet)
+
+	// Register the reverse lookup, listing the ranges of a country
+	countryHandler := v1.NewCountryHandler(db)
+	router.HandleFunc("/countries/{code}/ranges", countryHandler.GetCountryRanges).Methods(http.MethodGet)
 
-	// Register health check endpoint
-	router.HandleFunc("/health", HealthCheckHandler).Methods(http.MethodGet)
+	// Register admin endpoints
+	adminHandler := v1.NewAdminHandler(db, cfg, ipHandler.Cache())
+	router.HandleFunc("/admin/reload", adminHandler.ReloadDataset).Methods(http.MethodPost)
+	router.HandleFunc("/admin/cache/purge", adminHandler.PurgeCache).Methods(http.MethodPost)
 }
@@ -0,0 +1,94 @@
+package v1
+
+import (
+	"crypto/subtle"
+	"ip2country-service/config"
+	"ip2country-service/internal/cache"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type AdminHandler struct {
+	db     database.IPDatabase
+	config *config.Config
+	cache  *cache.LRU
+}
+
+// NewAdminHandler builds the admin endpoints; lookupCache may be nil if there is no cache to purge
+func NewAdminHandler(db database.IPDatabase, cfg *config.Config, lookupCache *cache.LRU) *AdminHandler {
+	return &AdminHandler{db: db, config: cfg, cache: lookupCache}
+}
+
+// ReloadDataset re-reads the dataset file and swaps it in. If the new file
+// cannot be loaded the previous dataset keeps serving and 500 is returned.
+func (h *AdminHandler) ReloadDataset(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+
+	reloader, ok := database.As[database.Reloader](h.db)
+	if !ok {
+		utils.RespondWithError(w, http.StatusNotImplemented, "dataset reload is not supported by this database type")
+		return
+	}
+
+	log.Println("Dataset reload requested via admin endpoint")
+	if err := reloader.Reload(); err != nil {
+		log.Printf("Dataset reload failed: %v", err)
+		utils.RespondWithError(w, http.StatusInternalServerError, "dataset reload failed, previous dataset is still serving: "+err.Error())
+		return
+	}
+
+	utils.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// PurgeCache empties the lookup cache, and the shared Redis cache if there
+// is one, e.g. after correcting a dataset without reloading it, and reports
+// how many entries were dropped
+func (h *AdminHandler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r) {
+		return
+	}
+	shared, hasShared := database.As[*cache.RedisTier](h.db)
+	if h.cache == nil && !hasShared {
+		utils.RespondWithError(w, http.StatusNotImplemented, "no lookup cache is configured")
+		return
+	}
+
+	response := map[string]interface{}{"status": "purged"}
+	if h.cache != nil {
+		purged := h.cache.Purge()
+		log.Printf("Lookup cache purged via admin endpoint, %d entries dropped", purged)
+		response["entries"] = purged
+	}
+	if hasShared {
+		purged, err := shared.Purge(r.Context())
+		if err != nil {
+			log.Printf("Error purging the shared lookup cache: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "error purging the shared lookup cache: "+err.Error())
+			return
+		}
+		log.Printf("Shared lookup cache purged via admin endpoint, %d entries dropped", purged)
+		response["shared_entries"] = purged
+	}
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// authorize checks the bearer token against ADMIN_TOKEN and answers the
+// request itself if it is refused. Without ADMIN_TOKEN every request is
+// refused with 403, so a stock deployment does not expose reloads and purges.
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if h.config.AdminToken == "" {
+		utils.RespondWithError(w, http.StatusForbidden, utils.ErrAdminDisabled.Error())
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) != 1 {
+		utils.RespondWithError(w, http.StatusUnauthorized, utils.ErrUnauthorized.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/rate_limiter"
+	"ip2country-service/monitoring"
+	"ip2country-service/pkg/utils"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxBatchIPBytes bounds the request body per IP: the longest IPv6 text form
+// plus JSON quoting, separators and whitespace
+const maxBatchIPBytes = 64
+
+// BatchResult is the outcome of one IP in a batch request; exactly one of
+// Location and Error is set
+type BatchResult struct {
+	IP       string                 `json:"ip"`
+	Location map[string]interface{} `json:"location,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Status   int                    `json:"status"`
+}
+
+// GetLocationsBatch looks up many IPs in one request. The body is either a
+// JSON array of strings or newline-delimited text; results are returned in
+// input order with a per-IP status so one bad IP doesn't fail the batch.
+func (h *IPHandler) GetLocationsBatch(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	fields := r.URL.Query().Get("fields")
+
+	ips, err := h.parseBatch(w, r)
+	if err != nil {
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, string(database.KindInvalidInput)).Inc()
+		log.Printf("Invalid batch request: %v", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, utils.ErrBatchTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		utils.RespondWithError(w, status, err.Error())
+		return
+	}
+
+	// Reject bad field selections once rather than once per IP
+	allowedFields := h.allowedFields(r.Context())
+	if err := validateFields(fields, allowedFields); err != nil {
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, string(database.KindInvalidInput)).Inc()
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// A batch costing more tokens than the client's bucket holds would be
+	// refused forever, so say how large a batch can be instead of asking
+	// the client to retry
+	if capacity, ok := rate_limiter.Capacity(r.Context()); ok && float64(len(ips)) > capacity {
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, string(database.KindInvalidInput)).Inc()
+		log.Printf("Batch of %d IPs exceeds rate limit capacity %v", len(ips), capacity)
+		utils.RespondWithError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("%v: your rate limit allows at most %d IPs per batch", utils.ErrBatchTooLarge, int(capacity)))
+		return
+	}
+
+	// The rate limiter already took one token for the request; charge the rest per IP
+	allowed, err := rate_limiter.Charge(r.Context(), float64(len(ips)-1))
+	if err != nil {
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, statusInternalError).Inc()
+		log.Printf("Rate limiter error for batch of %d IPs: %v", len(ips), err)
+		utils.RespondWithError(w, http.StatusInternalServerError, utils.ErrInternalServer.Error())
+		return
+	}
+	if !allowed {
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, statusRateLimited).Inc()
+		monitoring.RateLimitExceeded.WithLabelValues(r.URL.Path).Inc()
+		utils.RespondWithError(w, http.StatusTooManyRequests, utils.ErrRateLimitExceeded.Error())
+		return
+	}
+
+	log.Printf("Received batch request for %d IPs with fields: %s", len(ips), fields)
+	monitoring.BatchSize.Observe(float64(len(ips)))
+
+	results := make([]BatchResult, len(ips))
+	for i, ip := range ips {
+		results[i] = h.lookupBatchItem(r.Context(), r.URL.Path, ip, fields, allowedFields)
+	}
+
+	monitoring.RequestDuration.WithLabelValues(r.URL.Path).Observe(time.Since(startTime).Seconds())
+	monitoring.RequestsTotal.WithLabelValues(r.URL.Path, statusSuccess).Inc()
+	h.setDatasetVersion(w)
+	utils.RespondWithJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+func (h *IPHandler) lookupBatchItem(ctx context.Context, path, ip, fields string, allowed []string) BatchResult {
+	if !utils.ValidateIP(ip) {
+		return BatchResult{IP: ip, Error: utils.ErrInvalidIP.Error(), Status: http.StatusBadRequest}
+	}
+
+	loc, err := h.lookup(ctx, path, ip)
+	if err != nil {
+		status, _, message := lookupErrorResponse(err)
+		return BatchResult{IP: ip, Error: message, Status: status}
+	}
+
+	response, err := h.buildResponse(loc, fields, allowed)
+	if err != nil {
+		log.Printf("Error building response for IP %s: %v", ip, err)
+		return BatchResult{IP: ip, Error: utils.ErrInternalServer.Error(), Status: http.StatusInternalServerError}
+	}
+	return BatchResult{IP: ip, Location: response, Status: http.StatusOK}
+}
+
+// parseBatch reads the IPs from a JSON array or newline-delimited body
+func (h *IPHandler) parseBatch(w http.ResponseWriter, r *http.Request) ([]string, error) {
+	maxIPs := h.config.BatchMaxIPs
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxIPs*maxBatchIPBytes+1024)))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, fmt.Errorf("%w: maximum is %d", utils.ErrBatchTooLarge, maxIPs)
+		}
+		return nil, fmt.Errorf("%w: %v", utils.ErrInvalidBatch, err)
+	}
+
+	var ips []string
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &ips); err != nil {
+			return nil, fmt.Errorf("%w: %v", utils.ErrInvalidBatch, err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				ips = append(ips, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%w: %v", utils.ErrInvalidBatch, err)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%w: no IPs provided", utils.ErrInvalidBatch)
+	}
+	if len(ips) > maxIPs {
+		return nil, fmt.Errorf("%w: got %d, maximum is %d", utils.ErrBatchTooLarge, len(ips), maxIPs)
+	}
+	for i := range ips {
+		ips[i] = strings.TrimSpace(ips[i])
+	}
+	return ips, nil
+}
@@ -0,0 +1,165 @@
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"ip2country-service/internal/database"
+	"ip2country-service/monitoring"
+	"ip2country-service/pkg/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// countryRangesPath labels the country ranges metrics with the route rather
+// than the request path, which would add a series per country
+const countryRangesPath = "/countries/{code}/ranges"
+
+// Pagination of the country ranges endpoint
+const (
+	defaultRangesLimit = 100
+	maxRangesLimit     = 1000
+)
+
+// Output formats of the country ranges endpoint
+const (
+	rangeFormatRange = "range" // first and last address of each range
+	rangeFormatCIDR  = "cidr"  // the CIDR networks covering each range
+)
+
+type CountryHandler struct {
+	db database.IPDatabase
+}
+
+func NewCountryHandler(db database.IPDatabase) *CountryHandler {
+	return &CountryHandler{db: db}
+}
+
+// CountryRanges is one page of the ranges attributed to a country
+type CountryRanges struct {
+	Country string `json:"country"`
+	Region  string `json:"region,omitempty"`
+	City    string `json:"city,omitempty"`
+	Total   int    `json:"total"`
+	Offset  int    `json:"offset"`
+	Limit   int    `json:"limit"`
+	// NextOffset is the offset of the next page, if there is one
+	NextOffset *int           `json:"next_offset,omitempty"`
+	Ranges     []CountryRange `json:"ranges"`
+}
+
+// CountryRange is a range given by its bounds, or by CIDR networks when
+// format=cidr is requested
+type CountryRange struct {
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+	Networks []string `json:"networks,omitempty"`
+	Region   string   `json:"region,omitempty"`
+	City     string   `json:"city,omitempty"`
+	Source   string   `json:"source,omitempty"`
+}
+
+// GetCountryRanges lists the ranges the dataset attributes to a country,
+// optionally narrowed to a region or city, in address order. Ranges a
+// lookup would answer from an override are listed as the override answers.
+func (h *CountryHandler) GetCountryRanges(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	query, format, err := parseRangeQuery(r)
+	if err != nil {
+		log.Printf("Invalid country ranges request %s: %v", r.URL, err)
+		monitoring.RequestsTotal.WithLabelValues(countryRangesPath, string(database.KindInvalidInput)).Inc()
+		utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := database.ListRanges(r.Context(), h.db, query)
+	if err != nil {
+		log.Printf("Error listing ranges for country %s: %v", query.Country, err)
+		status, label, message := listErrorResponse(err)
+		monitoring.RequestsTotal.WithLabelValues(countryRangesPath, label).Inc()
+		utils.RespondWithError(w, status, message)
+		return
+	}
+
+	response := CountryRanges{
+		Country: query.Country,
+		Region:  query.Region,
+		City:    query.City,
+		Total:   page.Total,
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Ranges:  make([]CountryRange, 0, len(page.Ranges)),
+	}
+	if next := query.Offset + len(page.Ranges); len(page.Ranges) > 0 && next < page.Total {
+		response.NextOffset = &next
+	}
+	for _, located := range page.Ranges {
+		out := CountryRange{Region: located.Location.Region, City: located.Location.City, Source: located.Location.Source}
+		if format == rangeFormatCIDR {
+			out.Networks = located.CIDRs()
+		} else {
+			out.From, out.To = located.From.IP().String(), located.To.IP().String()
+		}
+		response.Ranges = append(response.Ranges, out)
+	}
+
+	monitoring.RequestDuration.WithLabelValues(countryRangesPath).Observe(time.Since(startTime).Seconds())
+	monitoring.RequestsTotal.WithLabelValues(countryRangesPath, statusSuccess).Inc()
+	utils.RespondWithJSON(w, http.StatusOK, response)
+}
+
+// parseRangeQuery reads the country code from the path and the filters,
+// pagination and output format from the query string
+func parseRangeQuery(r *http.Request) (database.RangeQuery, string, error) {
+	params := r.URL.Query()
+	query := database.RangeQuery{
+		Country: strings.ToUpper(mux.Vars(r)["code"]),
+		Region:  strings.TrimSpace(params.Get("region")),
+		City:    strings.TrimSpace(params.Get("city")),
+		Limit:   defaultRangesLimit,
+	}
+	// Datasets may use codes ISO 3166 leaves to users, such as XK, so any
+	// two letters are accepted
+	if len(query.Country) != 2 || strings.Trim(query.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return query, "", fmt.Errorf("%w: %q", utils.ErrInvalidCountry, mux.Vars(r)["code"])
+	}
+
+	if s := params.Get("offset"); s != "" {
+		offset, err := strconv.Atoi(s)
+		if err != nil || offset < 0 {
+			return query, "", fmt.Errorf("%w: offset must be a non-negative integer", utils.ErrInvalidPagination)
+		}
+		query.Offset = offset
+	}
+	if s := params.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxRangesLimit {
+			return query, "", fmt.Errorf("%w: limit must be between 1 and %d", utils.ErrInvalidPagination, maxRangesLimit)
+		}
+		query.Limit = limit
+	}
+
+	format := params.Get("format")
+	switch format {
+	case "":
+		format = rangeFormatRange
+	case rangeFormatRange, rangeFormatCIDR:
+	default:
+		return query, "", fmt.Errorf("unsupported format %q, expected %s or %s", format, rangeFormatRange, rangeFormatCIDR)
+	}
+	return query, format, nil
+}
+
+// listErrorResponse maps an error listing ranges to the HTTP status, the
+// http_requests_total status label and the message returned to clients
+func listErrorResponse(err error) (int, string, string) {
+	if errors.Is(err, utils.ErrUnsupportedQuery) {
+		return http.StatusNotImplemented, statusUnsupported, utils.ErrUnsupportedQuery.Error()
+	}
+	return lookupErrorResponse(err)
+}
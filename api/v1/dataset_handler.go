@@ -0,0 +1,59 @@
+package v1
+
+import (
+	"context"
+	"ip2country-service/internal/database"
+	"ip2country-service/monitoring"
+	"ip2country-service/pkg/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// datasetDescribeTimeout bounds describing the dataset for the info metric,
+// which may query the database
+const datasetDescribeTimeout = 5 * time.Second
+
+type DatasetHandler struct {
+	db database.IPDatabase
+}
+
+// NewDatasetHandler builds the dataset endpoint and publishes the dataset's
+// metadata as the dataset_info metric, again after every reload
+func NewDatasetHandler(db database.IPDatabase) *DatasetHandler {
+	h := &DatasetHandler{db: db}
+	h.publishInfo()
+	if reloader, ok := database.As[database.Reloader](db); ok {
+		reloader.OnReload(h.publishInfo)
+	}
+	return h
+}
+
+// GetDataset describes the dataset answering lookups, so an answer can be
+// traced back to the data it came from
+func (h *DatasetHandler) GetDataset(w http.ResponseWriter, r *http.Request) {
+	meta, ok := database.Describe(r.Context(), h.db)
+	if !ok {
+		utils.RespondWithError(w, http.StatusNotImplemented, "dataset metadata is not available for this database type")
+		return
+	}
+	utils.RespondWithJSON(w, http.StatusOK, meta)
+}
+
+// publishInfo replaces the dataset_info series with one for the current dataset
+func (h *DatasetHandler) publishInfo() {
+	ctx, cancel := context.WithTimeout(context.Background(), datasetDescribeTimeout)
+	defer cancel()
+	meta, ok := database.Describe(ctx, h.db)
+	if !ok {
+		return
+	}
+
+	var buildDate string
+	if meta.BuildDate != nil {
+		buildDate = meta.BuildDate.Format(time.RFC3339)
+	}
+	monitoring.DatasetInfo.Reset()
+	monitoring.DatasetInfo.WithLabelValues(meta.Type, meta.Version, meta.Checksum, meta.Vendor, meta.VendorVersion, buildDate).Set(1)
+	log.Printf("Serving %s dataset version %q from %s", meta.Type, meta.Version, meta.Path)
+}
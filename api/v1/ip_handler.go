@@ -1,32 +1,75 @@
 package v1
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"ip2country-service/config"
+	"ip2country-service/internal/auth"
+	"ip2country-service/internal/cache"
+	"ip2country-service/internal/client_ip"
 	"ip2country-service/internal/database"
 	"ip2country-service/internal/models"
 	"ip2country-service/monitoring"
 	"ip2country-service/pkg/utils"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+	"unsafe"
 
-	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
+)
+
+// DatasetVersionHeader carries the version of the dataset that answered a
+// lookup, as reported by GET /api/v1/dataset
+const DatasetVersionHeader = "X-Dataset-Version"
+
+// Status labels recorded in http_requests_total besides the database.ErrorKind values
+const (
+	statusSuccess       = "success"
+	statusInternalError = "internal_error"
+	statusRateLimited   = "rate_limited"
+	statusUnsupported   = "unsupported"
 )
 
 type IPHandler struct {
 	db     database.IPDatabase
 	config *config.Config
-	cache  *cache.Cache
+	cache  *cache.LRU
+	// lookups coalesces concurrent database queries for the same address
+	lookups singleflight.Group
+	// waiters tracks who is waiting on each coalesced query, so it is
+	// cancelled once the last of them goes away
+	mu      sync.Mutex
+	waiters map[string]*sharedQuery
+}
+
+// sharedQuery is the context of a coalesced database query and the number
+// of callers waiting for its answer
+type sharedQuery struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
 }
 
 func NewIPHandler(db database.IPDatabase, cfg *config.Config) *IPHandler {
-	// Create a cache with a default expiration time of 5 minutes and purge unused items every 10 minutes
-	c := cache.New(5*time.Minute, 10*time.Minute)
-	return &IPHandler{db: db, config: cfg, cache: c}
+	// Bound the cache by entries and memory so scans across the address space cannot exhaust the heap
+	c := cache.NewLRU(cfg.CacheMaxEntries, cfg.CacheMaxBytes, cfg.CacheTTL)
+
+	// Cached answers belong to the dataset they were read from, so drop them when it is swapped
+	if reloader, ok := database.As[database.Reloader](db); ok {
+		reloader.OnReload(func() { c.Purge() })
+	}
+	return &IPHandler{db: db, config: cfg, cache: c, waiters: make(map[string]*sharedQuery)}
+}
+
+// Cache returns the handler's lookup cache, for the admin purge endpoint
+func (h *IPHandler) Cache() *cache.LRU {
+	return h.cache
 }
 
 func (h *IPHandler) GetLocation(w http.ResponseWriter, r *http.Request) {
@@ -35,70 +78,44 @@ func (h *IPHandler) GetLocation(w http.ResponseWriter, r *http.Request) {
 	ip := r.URL.Query().Get("ip")
 	fields := r.URL.Query().Get("fields")
 
+	// Without an ip parameter, or with ip=me, geolocate the caller
+	if ip == "" || ip == "me" {
+		if clientIP, err := client_ip.FromRequest(r); err == nil {
+			ip = clientIP
+		}
+	}
+
 	log.Printf("Received request for IP: %s with fields: %s", ip, fields)
 
 	// Validate the IP
 	if !utils.ValidateIP(ip) {
 		log.Printf("Invalid IP address: %s", ip)
-		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, "error").Inc() // Increment request count
-		monitoring.RateLimitExceeded.WithLabelValues(r.URL.Path).Inc()      // Increment rate limit exceeded count
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, string(database.KindInvalidInput)).Inc() // Increment request count
 		utils.RespondWithError(w, http.StatusBadRequest, utils.ErrInvalidIP.Error())
 		return
 	}
 
-	var loc *models.Location
-	var err error
-	var cacheHit bool
-
-	// Measure IP lookup time, including cache check
-	ipLookupStart := time.Now()
-
-	// Check cache first
-	if cachedLoc, found := h.cache.Get(ip); found {
-		log.Printf("IP found in cache: %s", ip)
-		loc = cachedLoc.(*models.Location)
-		cacheHit = true
-	} else {
-		// Query the database for the IP location
-		log.Printf("Querying database for IP: %s", ip)
-		loc, err = h.db.Find(ip)
-		if err != nil {
-			monitoring.RequestsTotal.WithLabelValues(r.URL.Path, "error").Inc()
-			if errors.Is(err, utils.ErrIpNotFound) {
-				log.Printf("IP not found in the database: %s", ip)
-				utils.RespondWithError(w, http.StatusNotFound, err.Error())
-			} else {
-				log.Printf("Error querying database for IP %s: %v", ip, err)
-				utils.RespondWithError(w, http.StatusInternalServerError, utils.ErrDatabaseQuery.Error())
-			}
-			return
-		}
-		// Cache the result
-		h.cache.Set(ip, loc, cache.DefaultExpiration)
-	}
-
-	// Record IP lookup duration
-	ipLookupDuration := time.Since(ipLookupStart)
-	monitoring.IPLookupDuration.WithLabelValues().Observe(ipLookupDuration.Seconds())
-
-	// Record cache hit/miss
-	if cacheHit {
-		monitoring.CacheHits.WithLabelValues(r.URL.Path).Inc()
-	} else {
-		monitoring.CacheMisses.WithLabelValues(r.URL.Path).Inc()
+	loc, err := h.lookup(r.Context(), r.URL.Path, ip)
+	h.setDatasetVersion(w)
+	if err != nil {
+		status, label, message := lookupErrorResponse(err)
+		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, label).Inc()
+		utils.RespondWithError(w, status, message)
+		return
 	}
 
 	log.Printf("IP found: %+v", loc)
 
 	// Build the response
 	log.Printf("Building response for IP: %s", ip)
-	response, err := h.buildResponse(loc, fields)
+	response, err := h.buildResponse(loc, fields, h.allowedFields(r.Context()))
 	if err != nil {
-		monitoring.RequestsTotal.WithLabelValues(r.URL.Path, "error").Inc()
 		log.Printf("Error building response for IP %s: %v", ip, err)
 		if errors.Is(err, utils.ErrInvalidFields) {
+			monitoring.RequestsTotal.WithLabelValues(r.URL.Path, string(database.KindInvalidInput)).Inc()
 			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
 		} else {
+			monitoring.RequestsTotal.WithLabelValues(r.URL.Path, statusInternalError).Inc()
 			utils.RespondWithError(w, http.StatusInternalServerError, utils.ErrInternalServer.Error())
 		}
 		return
@@ -111,13 +128,203 @@ func (h *IPHandler) GetLocation(w http.ResponseWriter, r *http.Request) {
 	monitoring.RequestDuration.WithLabelValues(r.URL.Path).Observe(duration)
 
 	// Increment the request count
-	monitoring.RequestsTotal.WithLabelValues(r.URL.Path, "success").Inc()
+	monitoring.RequestsTotal.WithLabelValues(r.URL.Path, statusSuccess).Inc()
 
 	// Return the JSON response
 	utils.RespondWithJSON(w, http.StatusOK, response)
 }
 
-func (h *IPHandler) buildResponse(loc *models.Location, fields string) (map[string]interface{}, error) {
+// setDatasetVersion adds the version of the dataset being served to the
+// response, if the database can identify it
+func (h *IPHandler) setDatasetVersion(w http.ResponseWriter) {
+	if versioned, ok := database.As[database.Versioned](h.db); ok && versioned.Version() != "" {
+		w.Header().Set(DatasetVersionHeader, versioned.Version())
+	}
+}
+
+// notFound is cached for addresses the dataset does not cover and holds the
+// error the database returned for them
+type notFound struct {
+	err error
+}
+
+// lookup resolves ip through the cache and then the database, recording
+// lookup duration and cache hit/miss metrics for successful lookups. The
+// cache holds whole dataset ranges, so one database answer serves every
+// address in the matched range, and it remembers not-found answers for
+// CacheNegativeTTL. Concurrent misses for the same address wait for a single
+// database query, which is cancelled once none of them is left waiting.
+// ctx is cancelled when the client goes away.
+func (h *IPHandler) lookup(ctx context.Context, path, ip string) (*models.Location, error) {
+	// Measure IP lookup time, including cache check
+	ipLookupStart := time.Now()
+
+	ipNum, err := database.IPToNumber(net.ParseIP(ip))
+	if err != nil {
+		return nil, err
+	}
+
+	// Check cache first
+	if cached, found := h.cache.Get(ipNum); found {
+		if miss, ok := cached.(notFound); ok {
+			log.Printf("IP cached as not found: %s", ip)
+			monitoring.NegativeCacheHits.WithLabelValues(path).Inc()
+			return nil, miss.err
+		}
+		log.Printf("IP found in cache: %s", ip)
+		monitoring.IPLookupDuration.WithLabelValues().Observe(time.Since(ipLookupStart).Seconds())
+		monitoring.CacheHits.WithLabelValues(path).Inc()
+		return cached.(*models.Location), nil
+	}
+
+	// Share one database query between concurrent misses for the same address.
+	// Only the caller whose function runs sets queried; the others were coalesced.
+	key := ipNum.String()
+	shared := h.join(ctx, key)
+	defer h.leave(key, shared)
+	var queried bool
+	results := h.lookups.DoChan(key, func() (interface{}, error) {
+		queried = true
+		return h.query(shared.ctx, ipNum, ip)
+	})
+
+	select {
+	case result := <-results:
+		if !queried {
+			monitoring.CoalescedLookups.WithLabelValues(path).Inc()
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		monitoring.IPLookupDuration.WithLabelValues().Observe(time.Since(ipLookupStart).Seconds())
+		monitoring.CacheMisses.WithLabelValues(path).Inc()
+		return result.Val.(*models.Location), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseTimeout, ctx.Err())
+	}
+}
+
+// join registers the caller as waiting on the query for key, starting the
+// context of a new query if nobody else is waiting. The query's context
+// keeps ctx's values but not its cancellation, so the first caller going
+// away does not fail the others.
+func (h *IPHandler) join(ctx context.Context, key string) *sharedQuery {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	shared, ok := h.waiters[key]
+	if !ok {
+		queryCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		shared = &sharedQuery{ctx: queryCtx, cancel: cancel}
+		h.waiters[key] = shared
+	}
+	shared.waiters++
+	return shared
+}
+
+// leave unregisters a caller from the query for key. The last one to leave
+// cancels the query, and makes later callers start a new one rather than
+// join the cancelled one.
+func (h *IPHandler) leave(key string, shared *sharedQuery) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	shared.waiters--
+	if shared.waiters > 0 {
+		return
+	}
+	shared.cancel()
+	delete(h.waiters, key)
+	h.lookups.Forget(key)
+}
+
+// query looks ip up in the database on behalf of every coalesced caller and
+// caches the outcome. ctx is cancelled when no caller waits for the answer
+// any more, and the query is also bounded by LookupTimeout if set.
+func (h *IPHandler) query(ctx context.Context, ipNum database.IPNumber, ip string) (*models.Location, error) {
+	if h.config.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.config.LookupTimeout)
+		defer cancel()
+	}
+
+	// A reload purges the cache; an answer from the dataset it replaced
+	// must not be cached once the purge has run
+	gen := h.cache.Generation()
+
+	log.Printf("Querying database for IP: %s", ip)
+	loc, matched, err := database.FindRange(ctx, h.db, ip)
+	if err != nil {
+		if database.KindOf(err) == database.KindNotFound {
+			log.Printf("IP not found in the database: %s", ip)
+			if h.config.CacheNegativeTTL > 0 {
+				h.cache.SetIfGeneration(gen, database.Range{From: ipNum, To: ipNum}, notFound{err: err}, 0, h.config.CacheNegativeTTL)
+			}
+		} else {
+			log.Printf("Error querying database for IP %s: %v", ip, err)
+		}
+		return nil, err
+	}
+
+	// Cache the result for the whole matched range
+	if !h.cache.SetIfGeneration(gen, matched, loc, locationSize(loc), h.config.CacheTTL) {
+		log.Printf("Not caching IP %s: the cache was purged during the lookup", ip)
+	}
+	return loc, nil
+}
+
+// locationSize approximates the memory held by a cached location: the
+// struct itself, the string contents and the coordinates it points to
+func locationSize(loc *models.Location) int64 {
+	size := int64(unsafe.Sizeof(*loc))
+	size += int64(len(loc.Country) + len(loc.Region) + len(loc.City) + len(loc.PostalCode) + len(loc.TimeZone) + len(loc.Organization) + len(loc.Source))
+	if loc.Latitude != nil {
+		size += 8
+	}
+	if loc.Longitude != nil {
+		size += 8
+	}
+	return size
+}
+
+// lookupErrorResponse maps a lookup error to the HTTP status, the
+// http_requests_total status label and the message returned to clients
+func lookupErrorResponse(err error) (int, string, string) {
+	kind := database.KindOf(err)
+	switch kind {
+	case database.KindInvalidInput:
+		return http.StatusBadRequest, string(kind), utils.ErrInvalidIP.Error()
+	case database.KindNotFound:
+		return http.StatusNotFound, string(kind), utils.ErrIpNotFound.Error()
+	case database.KindTimeout:
+		return http.StatusGatewayTimeout, string(kind), utils.ErrDatabaseTimeout.Error()
+	default:
+		return http.StatusInternalServerError, string(kind), utils.ErrDatabaseQuery.Error()
+	}
+}
+
+// allowedFields returns the fields the caller's plan may select, falling
+// back to the service-wide AllowedFields
+func (h *IPHandler) allowedFields(ctx context.Context) []string {
+	if principal := auth.FromContext(ctx); principal != nil && principal.AllowedFields != nil {
+		return principal.AllowedFields
+	}
+	return h.config.AllowedFields
+}
+
+// validateFields checks that every requested field is in allowed
+func validateFields(fields string, allowed []string) error {
+	if fields == "" {
+		return nil
+	}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if !utils.Contains(allowed, field) {
+			return fmt.Errorf("%w: %s", utils.ErrInvalidFields, field)
+		}
+	}
+	return nil
+}
+
+func (h *IPHandler) buildResponse(loc *models.Location, fields string, allowed []string) (map[string]interface{}, error) {
 	var response map[string]interface{}
 	data, err := json.Marshal(loc)
 	if err != nil {
@@ -133,13 +340,17 @@ func (h *IPHandler) buildResponse(loc *models.Location, fields string) (map[stri
 		filteredResponse := make(map[string]interface{})
 		for _, field := range requestedFields {
 			field = strings.TrimSpace(field)
-			if utils.Contains(h.config.AllowedFields, field) {
+			if utils.Contains(allowed, field) {
 				filteredResponse[field] = response[field]
 				monitoring.AllowedFieldsUsage.WithLabelValues(field).Inc()
 			} else {
 				return nil, fmt.Errorf("%w: %s", utils.ErrInvalidFields, field)
 			}
 		}
+		// Keep the override marker, so a corrected answer is recognisable
+		if source, ok := response["source"]; ok {
+			filteredResponse["source"] = source
+		}
 		response = filteredResponse
 	}
 
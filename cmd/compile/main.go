@@ -0,0 +1,57 @@
+// Command compile turns a JSON, NDJSON or CSV dataset into a snapshot, the
+// binary format served with IP_DATABASE_TYPE=snapshot. The server maps a
+// snapshot and searches it in place, so it starts without parsing the
+// dataset and processes serving the same file share its memory.
+//
+// The dataset is validated as the server would validate it, following
+// DATASET_VALIDATION unless -validation says otherwise, and no snapshot is
+// written if it is refused. The input type and CSV layout default to the
+// service settings (IP_DATABASE_TYPE and CSV_*):
+//
+//	go run ./cmd/compile -file ./data/ip_database.csv -type csv -out ./data/ip_database.snap
+package main
+
+import (
+	"flag"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"log"
+	"strings"
+	"time"
+)
+
+func main() {
+	cfg := config.LoadConfig()
+	filePath := flag.String("file", cfg.DatabasePath, "Path to the dataset")
+	fileType := flag.String("type", cfg.DatabaseType, "Dataset format: "+strings.Join(database.Formats(), ", "))
+	outPath := flag.String("out", "", "Path of the snapshot to write")
+	validation := flag.String("validation", cfg.DatasetValidation, "strict or lenient")
+	flag.Parse()
+
+	if *outPath == "" {
+		log.Fatal("-out is required")
+	}
+	mode, err := database.ParseValidationMode(*validation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts, err := database.CSVOptionsFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Invalid CSV settings: %v", err)
+	}
+
+	start := time.Now()
+	locations, err := database.ReadLocations(*fileType, *filePath, opts)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", *filePath, err)
+	}
+	local := database.DatabaseLocal{Locations: locations, Validation: mode}
+	if err := local.Validate(); err != nil {
+		log.Fatalf("Refusing to compile %s: %v", *filePath, err)
+	}
+
+	if err := database.WriteSnapshot(*outPath, local.Locations); err != nil {
+		log.Fatalf("Failed to write %s: %v", *outPath, err)
+	}
+	log.Printf("Compiled %d ranges from %s into %s in %s", len(local.Locations), *filePath, *outPath, time.Since(start))
+}
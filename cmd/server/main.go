@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"ip2country-service/api"
 	"ip2country-service/config"
+	"ip2country-service/internal/auth"
+	"ip2country-service/internal/cache"
+	"ip2country-service/internal/client_ip"
 	"ip2country-service/internal/database"
+	"ip2country-service/internal/health"
 	"ip2country-service/internal/rate_limiter"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -18,6 +30,10 @@ func main() {
 	cfg := config.LoadConfig()
 	log.Println("Configuration loaded successfully.")
 
+	// Cancelled on SIGINT or SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// Initialize the database (MongoDB, JSON, or other)
 	log.Println("Initializing the database...")
 	db, err := database.NewIPDatabase(cfg)
@@ -26,6 +42,40 @@ func main() {
 	}
 	log.Println("Database initialized successfully.")
 
+	// Reload file-based datasets when they change on disk
+	if reloadable, ok := db.(*database.ReloadableDatabase); ok && cfg.ReloadInterval > 0 {
+		log.Printf("Watching %s for changes every %s", cfg.DatabasePath, cfg.ReloadInterval)
+		go reloadable.Watch(ctx, cfg.ReloadInterval)
+	}
+
+	// Answer the ranges listed in the overrides file from it instead of the dataset
+	if cfg.OverridesPath != "" {
+		overrides, err := database.NewOverrideDatabase(db, cfg.OverridesPath)
+		if err != nil {
+			log.Fatalf("Failed to load overrides: %v", err)
+		}
+		if cfg.ReloadInterval > 0 {
+			log.Printf("Watching %s for changes every %s", cfg.OverridesPath, cfg.ReloadInterval)
+			go overrides.Watch(ctx, cfg.ReloadInterval)
+		}
+		db = overrides
+	}
+
+	// Reload the dataset and overrides on SIGHUP
+	if reloader, ok := database.As[database.Reloader](db); ok {
+		go reloadOnSignal(reloader)
+	}
+
+	// Share cached lookups between replicas through Redis
+	if cfg.RedisCache {
+		log.Printf("Using the shared lookup cache at %s", cfg.RedisAddr)
+		db = cache.NewRedisTier(db, redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		}), cfg)
+	}
+
 	// Initialize the router
 	log.Println("Initializing the router...")
 	router := mux.NewRouter()
@@ -34,34 +84,133 @@ func main() {
 	// Log all requests for debugging
 	apiRouter.Use(loggingMiddleware)
 
-	// Middleware (rate limiting)
+	// Middleware (client IP resolution, honouring forwarding headers from trusted proxies only)
+	resolver, err := client_ip.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to parse TRUSTED_PROXIES: %v", err)
+	}
+	apiRouter.Use(resolver.Middleware)
+
+	// Middleware (rate limiting). Failed authentication attempts are charged
+	// per client IP before the key is checked, and authenticated requests
+	// to the caller's plan after.
 	log.Println("Initializing rate limiter...")
 	rl, err := rate_limiter.NewRateLimiter(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize rate limiter: %v", err)
 	}
+	apiRouter.Use(rl.LimitFailedAuth)
+
+	// Middleware (API key authentication, attaching the caller's plan for the rate limiter and handlers)
+	keyStore, err := auth.NewKeyStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load API keys: %v", err)
+	}
+	apiRouter.Use(auth.NewAuthenticator(keyStore, auth.AnonymousPrincipal(cfg)).Middleware)
 	apiRouter.Use(rl.Limit)
 	log.Println("Rate limiter initialized successfully.")
 
 	// Register API handlers
 	log.Println("Registering API handlers...")
+	status := newHealthStatus(cfg, db, rl)
 	api.RegisterHandlers(apiRouter, db, cfg)
 	log.Println("API handlers registered successfully.")
 
-	// Add Prometheus metrics endpoint
+	// Add liveness and readiness probes and the Prometheus metrics endpoint
+	api.RegisterProbes(router, status)
 	router.Handle("/metrics", promhttp.Handler())
 
 	// Start the server
-	log.Printf("Server is running on port %s...", cfg.Port)
-	if err := http.ListenAndServe(":"+cfg.Port, router); err != nil {
+	server := newServer(cfg, router)
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Server is running on port %s...", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Failed to start server: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // A second signal kills the process immediately
+
+	shutdown(server, status, cfg)
+	closeAll(db, rl, keyStore)
+	log.Println("Server stopped.")
+}
+
+// newHealthStatus registers the readiness checks for the dependencies that can check themselves
+func newHealthStatus(cfg *config.Config, db database.IPDatabase, rl rate_limiter.RateLimiter) *health.Status {
+	status := health.NewStatus(cfg.HealthCheckTimeout)
+	if dep, ok := db.(health.Dependency); ok {
+		status.Register("database", dep.CheckHealth)
+	}
+	if dep, ok := rl.(health.Dependency); ok {
+		status.Register("rate_limiter", dep.CheckHealth)
+	}
+	if reloadable, ok := database.As[*database.ReloadableDatabase](db); ok && cfg.DatasetMaxAge > 0 {
+		status.Register("dataset_age", health.DatasetAge(cfg.DatasetMaxAge, reloadable.ModTime))
+	}
+	return status
+}
+
+// newServer builds the HTTP server with the configured timeouts, so slow
+// clients cannot hold connections open indefinitely
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// shutdown reports the instance as unavailable for the drain period, giving
+// load balancers time to stop routing to it, then stops accepting
+// connections and waits up to ShutdownTimeout for in-flight requests
+func shutdown(server *http.Server, status *health.Status, cfg *config.Config) {
+	log.Printf("Shutting down, draining for %s...", cfg.ShutdownDrainPeriod)
+	status.StartDraining()
+	time.Sleep(cfg.ShutdownDrainPeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Server did not shut down cleanly: %v", err)
+	}
+}
+
+// closeAll releases the connections and files held by each resource that has any
+func closeAll(resources ...interface{}) {
+	for _, resource := range resources {
+		if closer, ok := resource.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Error closing %T: %v", resource, err)
+			}
+		}
+	}
+}
+
+// reloadOnSignal reloads the dataset every time the process receives SIGHUP
+func reloadOnSignal(db database.Reloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Println("Received SIGHUP, reloading dataset...")
+		if err := db.Reload(); err != nil {
+			log.Printf("Dataset reload failed: %v", err)
+		}
 	}
 }
 
 // loggingMiddleware logs incoming requests
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Received request: %s %s", r.Method, r.RequestURI)
+		log.Printf("Received request: %s %s", r.Method, auth.RedactedURI(r))
 		next.ServeHTTP(w, r)
 	})
 }
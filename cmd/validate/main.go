@@ -0,0 +1,64 @@
+// Command validate checks a JSON or CSV dataset for the problems the service
+// looks for at load time: overlapping, duplicate and inverted ranges,
+// unknown country codes and coordinates off the globe. It prints a report,
+// including how much of the address space the dataset covers and the gaps
+// between its ranges, and exits with status 1 if any problem was found.
+// Gaps alone do not fail the check.
+//
+// The dataset type, path and CSV layout default to the service settings
+// (IP_DATABASE_TYPE, IP_DATABASE_PATH and CSV_*), so a dataset can be
+// checked exactly as the service would read it:
+//
+//	go run ./cmd/validate -file ./data/ip_database.csv -type csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+	cfg := config.LoadConfig()
+	filePath := flag.String("file", cfg.DatabasePath, "Path to the dataset file")
+	fileType := flag.String("type", cfg.DatabaseType, "Dataset format: "+strings.Join(database.Formats(), ", "))
+	flag.Parse()
+
+	opts, err := database.CSVOptionsFromConfig(cfg)
+	if err != nil {
+		log.Fatalf("Invalid CSV settings: %v", err)
+	}
+	locations, err := database.ReadLocations(*fileType, *filePath, opts)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", *filePath, err)
+	}
+
+	report := database.ValidateLocations(locations)
+	fmt.Printf("%s: %s\n", *filePath, report)
+	for _, example := range report.Examples {
+		fmt.Printf("  %s\n", example)
+	}
+	if more := report.Issues() - len(report.Examples); more > 0 {
+		fmt.Printf("  ... and %d more\n", more)
+	}
+	for _, gap := range report.GapExamples {
+		fmt.Printf("  gap %s\n", gap)
+	}
+	if more := report.Gaps - len(report.GapExamples); more > 0 {
+		fmt.Printf("  ... and %d more gaps\n", more)
+	}
+
+	// Strict validation also rejects empty datasets and coordinates off the
+	// globe, which the report does not cover
+	local := database.DatabaseLocal{Locations: locations, Validation: database.ValidationStrict}
+	if err := local.Validate(); err != nil {
+		if report.Issues() == 0 {
+			fmt.Printf("  %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
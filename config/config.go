@@ -3,14 +3,19 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// DefaultAllowedFields are the fields that may be selected for partial
+// retrieval unless ALLOWED_FIELDS says otherwise
+var DefaultAllowedFields = []string{"country", "city", "latitude", "longitude", "accuracy_radius", "postal_code", "timezone", "asn", "organization"}
+
 type Config struct {
 	Port            string
 	RateLimit       float64
-	DatabaseType    string // "json" or "mongodb"
-	DatabasePath    string // For JSON files
+	DatabaseType    string // "json", "csv", "mmdb", "snapshot" or "mongodb"
+	DatabasePath    string // For JSON, CSV, MMDB and snapshot files
 	MongoDBURI      string // For MongoDB connection
 	MongoDBName     string
 	RateLimiterType string // "local" or "redis"
@@ -20,12 +25,63 @@ type Config struct {
 	AllowedFields   []string // Fields allowed for partial retrieval
 	RateCapacity    float64
 	RateJitter      time.Duration
+	// ReloadInterval is how often file-based datasets are checked for changes; 0 disables watching
+	ReloadInterval time.Duration
+	AdminToken     string // Bearer token required by admin endpoints; they are disabled without one
+	BatchMaxIPs    int    // Maximum number of IPs accepted by the batch lookup endpoint; the rate limit capacity may lower it
+	// LookupTimeout bounds each database lookup; with 0 a lookup runs until
+	// it answers or every client waiting for it has gone away
+	LookupTimeout time.Duration
+	// TrustedProxies lists the CIDRs whose forwarding headers are believed when resolving client IPs
+	TrustedProxies []string
+	APIKeysFile    string // JSON file of API keys and their quotas; empty disables file-based keys
+	APIKeysRedis   bool   // Also look API keys up in Redis at REDIS_ADDR
+	// AnonymousAccess serves requests without an API key under the anonymous plan below
+	AnonymousAccess        bool
+	AnonymousRateLimit     float64
+	AnonymousRateCapacity  float64
+	AnonymousAllowedFields []string
+	// HTTP server limits guarding against slow or oversized requests
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// ShutdownDrainPeriod is how long the health check reports unavailable before the server stops accepting connections
+	ShutdownDrainPeriod time.Duration
+	// ShutdownTimeout bounds how long in-flight requests may take to finish once the server stops
+	ShutdownTimeout time.Duration
+	// HealthCheckTimeout bounds each dependency check run by the readiness probe
+	HealthCheckTimeout time.Duration
+	// DatasetMaxAge fails readiness once the served dataset file is older than this; 0 disables the check
+	DatasetMaxAge time.Duration
+	// Lookup cache bounds; 0 lifts the corresponding limit
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+	CacheTTL        time.Duration
+	// CacheNegativeTTL is how long not-found answers are cached; 0 disables negative caching
+	CacheNegativeTTL time.Duration
+	// CSV dataset layout, see database.CSVOptionsFromConfig
+	CSVDelimiter string   // Single character, or "tab"
+	CSVHeader    string   // "auto", "true" or "false"
+	CSVLayout    string   // Preset column mapping, e.g. "ip2location" or "dbip"
+	CSVColumns   []string // field=column entries, by header name or 0-based index
+	// OverridesPath is a CSV or JSON file of ranges answered instead of the dataset; empty disables overrides
+	OverridesPath string
+	// DatasetValidation is "strict" to refuse datasets with overlapping, duplicate or unknown-country ranges, or "lenient" to log them
+	DatasetValidation string
+	// LookupIndex is "binary" to binary-search CSV and JSON datasets, or "trie" to answer from the most specific of nested ranges
+	LookupIndex string
+	// RedisCache adds a lookup cache at REDIS_ADDR shared by every replica, consulted after the local one
+	RedisCache bool
+	// RedisCacheTimeout bounds each call to the shared cache before falling back to the database
+	RedisCacheTimeout time.Duration
 }
 
 // LoadConfig loads the configuration from environment variables or defaults
 func LoadConfig() *Config {
 
-	return &Config{
+	cfg := &Config{
 		Port:            getEnv("PORT", "8080"),
 		RateLimit:       getEnvAsFloat("RATE_LIMIT", 1),
 		DatabaseType:    getEnv("IP_DATABASE_TYPE", "json"),
@@ -36,10 +92,47 @@ func LoadConfig() *Config {
 		RedisAddr:       getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisPassword:   getEnv("REDIS_PASSWORD", ""),
 		RedisDB:         getEnvAsInt("REDIS_DB", 0),
-		AllowedFields:   []string{"country", "city"}, // Default allowed fields for partial retrieval
+		AllowedFields:   getEnvAsSlice("ALLOWED_FIELDS", DefaultAllowedFields),
 		RateCapacity:    getEnvAsFloat("RATE_CAPACITY", 5),
 		RateJitter:      time.Duration(getEnvAsInt("RATE_JITTER", 100)) * time.Millisecond,
+		ReloadInterval:  time.Duration(getEnvAsInt("DATABASE_RELOAD_INTERVAL", 30)) * time.Second,
+		AdminToken:      getEnv("ADMIN_TOKEN", ""),
+		BatchMaxIPs:     getEnvAsInt("BATCH_MAX_IPS", 1000),
+		LookupTimeout:   time.Duration(getEnvAsInt("LOOKUP_TIMEOUT", 1000)) * time.Millisecond,
+		TrustedProxies:  getEnvAsSlice("TRUSTED_PROXIES", nil),
+		APIKeysFile:     getEnv("API_KEYS_FILE", ""),
+		APIKeysRedis:    getEnvAsBool("API_KEYS_REDIS", false),
+		AnonymousAccess: getEnvAsBool("ANONYMOUS_ACCESS", true),
+
+		ReadTimeout:         time.Duration(getEnvAsInt("HTTP_READ_TIMEOUT", 10)) * time.Second,
+		ReadHeaderTimeout:   time.Duration(getEnvAsInt("HTTP_READ_HEADER_TIMEOUT", 5)) * time.Second,
+		WriteTimeout:        time.Duration(getEnvAsInt("HTTP_WRITE_TIMEOUT", 30)) * time.Second,
+		IdleTimeout:         time.Duration(getEnvAsInt("HTTP_IDLE_TIMEOUT", 120)) * time.Second,
+		MaxHeaderBytes:      getEnvAsInt("HTTP_MAX_HEADER_BYTES", 1<<20),
+		ShutdownDrainPeriod: time.Duration(getEnvAsInt("SHUTDOWN_DRAIN_PERIOD", 5)) * time.Second,
+		ShutdownTimeout:     time.Duration(getEnvAsInt("SHUTDOWN_TIMEOUT", 20)) * time.Second,
+		HealthCheckTimeout:  time.Duration(getEnvAsInt("HEALTH_CHECK_TIMEOUT", 2000)) * time.Millisecond,
+		DatasetMaxAge:       time.Duration(getEnvAsInt("DATASET_MAX_AGE", 0)) * time.Hour,
+		CacheMaxEntries:     getEnvAsInt("CACHE_MAX_ENTRIES", 100000),
+		CacheMaxBytes:       int64(getEnvAsInt("CACHE_MAX_BYTES", 64<<20)),
+		CacheTTL:            time.Duration(getEnvAsInt("CACHE_TTL", 300)) * time.Second,
+		CacheNegativeTTL:    time.Duration(getEnvAsInt("CACHE_NEGATIVE_TTL", 60)) * time.Second,
+		CSVDelimiter:        getEnv("CSV_DELIMITER", ","),
+		CSVHeader:           getEnv("CSV_HEADER", "auto"),
+		CSVLayout:           getEnv("CSV_LAYOUT", ""),
+		CSVColumns:          getEnvAsSlice("CSV_COLUMNS", nil),
+		OverridesPath:       getEnv("OVERRIDES_PATH", ""),
+		DatasetValidation:   getEnv("DATASET_VALIDATION", "lenient"),
+		LookupIndex:         getEnv("LOOKUP_INDEX", "binary"),
+		RedisCache:          getEnvAsBool("REDIS_CACHE", false),
+		RedisCacheTimeout:   time.Duration(getEnvAsInt("REDIS_CACHE_TIMEOUT", 50)) * time.Millisecond,
 	}
+
+	// The anonymous plan defaults to the service-wide limits and fields
+	cfg.AnonymousRateLimit = getEnvAsFloat("ANONYMOUS_RATE_LIMIT", cfg.RateLimit)
+	cfg.AnonymousRateCapacity = getEnvAsFloat("ANONYMOUS_RATE_CAPACITY", cfg.RateCapacity)
+	cfg.AnonymousAllowedFields = getEnvAsSlice("ANONYMOUS_ALLOWED_FIELDS", cfg.AllowedFields)
+	return cfg
 }
 
 // getEnv retrieves the value of the environment variable named by the key.
@@ -61,6 +154,32 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsBool retrieves the value of the environment variable as a boolean.
+// It returns the value or the defaultValue if the variable is not present or invalid.
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsSlice retrieves the value of the environment variable as a comma-separated list.
+// It returns the trimmed, non-empty items or the defaultValue if the variable is not present.
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	var values []string
+	for _, item := range strings.Split(valueStr, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
 // getEnvAsFloat retrieves the value of the environment variable as a float64.
 // It returns the value or the defaultValue if the variable is not present or invalid.
 func getEnvAsFloat(key string, defaultValue float64) float64 {
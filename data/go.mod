@@ -1,12 +1,15 @@
 module ip2country-migration
 
-go 1.23
+go 1.23.1
 
-require go.mongodb.org/mongo-driver v1.17.1
+require (
+	go.mongodb.org/mongo-driver v1.17.1
+	ip2country-service v0.0.0
+)
 
 require (
 	github.com/golang/snappy v0.0.4 // indirect
-	github.com/klauspost/compress v1.13.6 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
 	github.com/montanaflynn/stats v0.7.1 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
@@ -16,3 +19,5 @@ require (
 	golang.org/x/sync v0.8.0 // indirect
 	golang.org/x/text v0.17.0 // indirect
 )
+
+replace ip2country-service => ../
@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"ip2country-service/pkg/ipnum"
 	"log"
 	"os"
 	"strconv"
@@ -18,11 +19,55 @@ import (
 )
 
 type IPLocation struct {
-	IPFrom  uint32 `json:"ip_from" bson:"ip_from"`
-	IPTo    uint32 `json:"ip_to" bson:"ip_to"`
-	Country string `json:"country" bson:"country"`
-	Region  string `json:"region" bson:"region"`
-	City    string `json:"city" bson:"city"`
+	IPFrom  ipnum.Number `json:"ip_from" bson:"ip_from"`
+	IPTo    ipnum.Number `json:"ip_to" bson:"ip_to"`
+	Country string       `json:"country" bson:"country"`
+	Region  string       `json:"region" bson:"region"`
+	City    string       `json:"city" bson:"city"`
+	// Optional details, only stored when the data file provides them
+	Latitude       *float64 `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty" bson:"longitude,omitempty"`
+	AccuracyRadius uint16   `json:"accuracy_radius,omitempty" bson:"accuracy_radius,omitempty"`
+	PostalCode     string   `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+	TimeZone       string   `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	ASN            uint32   `json:"asn,omitempty" bson:"asn,omitempty"`
+	Organization   string   `json:"organization,omitempty" bson:"organization,omitempty"`
+}
+
+// Optional CSV columns, following ip_from, ip_to, country, region and city
+// in the same order as the service's CSV loader
+const (
+	colLatitude = 5 + iota
+	colLongitude
+	colAccuracyRadius
+	colPostalCode
+	colTimeZone
+	colASN
+	colOrganization
+)
+
+// UnmarshalJSON reads an entry whose range is given by ip_from and ip_to, as
+// integers or address strings, or by a network such as "10.1.0.0/16". The
+// range is parsed by pkg/ipnum, as the service parses it.
+func (l *IPLocation) UnmarshalJSON(data []byte) error {
+	type fields IPLocation // without this method
+	var entry struct {
+		fields
+		IPFrom  json.RawMessage `json:"ip_from"`
+		IPTo    json.RawMessage `json:"ip_to"`
+		Network string          `json:"network"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+
+	from, to, err := ipnum.ParseJSONBounds(entry.Network, entry.IPFrom, entry.IPTo)
+	if err != nil {
+		return err
+	}
+	*l = IPLocation(entry.fields)
+	l.IPFrom, l.IPTo = from, to
+	return nil
 }
 
 func main() {
@@ -129,28 +174,28 @@ func parseCSV(filePath string) ([]interface{}, error) {
 			return nil, fmt.Errorf("error reading CSV: %v", err)
 		}
 
-		if len(record) < 5 {
+		if len(record) < 3 {
 			continue // Skip incomplete records
 		}
 
-		ipFrom, err := strconv.ParseUint(record[0], 10, 32)
-		if err != nil {
-			log.Printf("Invalid IPFrom value: %v", record[0])
-			continue
-		}
-
-		ipTo, err := strconv.ParseUint(record[1], 10, 32)
+		// ip_from and ip_to may be integers or addresses, or ip_from may
+		// hold a CIDR network or first-last range with ip_to left empty
+		ipFrom, ipTo, _, err := ipnum.ParseBounds(record[0], record[1])
 		if err != nil {
-			log.Printf("Invalid IPTo value: %v", record[1])
+			log.Printf("Invalid range %v-%v: %v", record[0], record[1], err)
 			continue
 		}
 
 		location := IPLocation{
-			IPFrom:  uint32(ipFrom),
-			IPTo:    uint32(ipTo),
+			IPFrom:  ipFrom,
+			IPTo:    ipTo,
 			Country: record[2],
-			Region:  record[3],
-			City:    record[4],
+			Region:  column(record, 3),
+			City:    column(record, 4),
+		}
+		if err := parseOptionalColumns(&location, record); err != nil {
+			log.Printf("Skipping record with %v: %v", err, record)
+			continue
 		}
 
 		documents = append(documents, location)
@@ -159,6 +204,52 @@ func parseCSV(filePath string) ([]interface{}, error) {
 	return documents, nil
 }
 
+// column returns the trimmed value of column i, or "" if the row is shorter
+func column(record []string, i int) string {
+	if i < len(record) {
+		return strings.TrimSpace(record[i])
+	}
+	return ""
+}
+
+// parseOptionalColumns fills in the optional details present in record
+func parseOptionalColumns(location *IPLocation, record []string) error {
+	column := func(i int) string { return column(record, i) }
+
+	if value := column(colLatitude); value != "" {
+		latitude, err := strconv.ParseFloat(value, 64)
+		if err != nil || latitude < -90 || latitude > 90 {
+			return fmt.Errorf("invalid latitude %q", value)
+		}
+		location.Latitude = &latitude
+	}
+	if value := column(colLongitude); value != "" {
+		longitude, err := strconv.ParseFloat(value, 64)
+		if err != nil || longitude < -180 || longitude > 180 {
+			return fmt.Errorf("invalid longitude %q", value)
+		}
+		location.Longitude = &longitude
+	}
+	if value := column(colAccuracyRadius); value != "" {
+		radius, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid accuracy_radius %q", value)
+		}
+		location.AccuracyRadius = uint16(radius)
+	}
+	location.PostalCode = column(colPostalCode)
+	location.TimeZone = column(colTimeZone)
+	if value := strings.TrimPrefix(strings.ToUpper(column(colASN)), "AS"); value != "" {
+		asn, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid asn %q", column(colASN))
+		}
+		location.ASN = uint32(asn)
+	}
+	location.Organization = column(colOrganization)
+	return nil
+}
+
 func parseJSON(filePath string) ([]interface{}, error) {
 	jsonFile, err := os.Open(filePath)
 	if err != nil {
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"ip2country-service/pkg/ipnum"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// rangeInputs are ip_from and ip_to values in every form datasets may use
+var rangeInputs = []struct{ from, to string }{
+	{"167772160", "167772415"},
+	{"10.0.0.0", "10.0.0.255"},
+	{"10.0.0.0/24", ""},
+	{"10.0.0.0-10.0.0.255", ""},
+	{"::ffff:10.0.0.0/120", ""},
+	{"281470849548288", "281470849548543"}, // ::ffff:10.0.0.0 - ::ffff:10.0.0.255 as integers
+	{"2001:db8::", "2001:db8::ffff"},
+	{"2001:db8::/32", ""},
+	{"42540766411282592856903984951653826560", "42540766411282592856903984951653892095"},
+}
+
+// TestParse_MatchesService reads the same ranges through the CSV and JSON
+// readers of the migration and checks they get the numbers the service
+// parses them to
+func TestParse_MatchesService(t *testing.T) {
+	dir := t.TempDir()
+	csvContent := ""
+	var entries []map[string]string
+	for _, in := range rangeInputs {
+		csvContent += fmt.Sprintf("%s,%s,US,,\n", in.from, in.to)
+		entries = append(entries, map[string]string{"ip_from": in.from, "ip_to": in.to, "country": "US"})
+	}
+	csvPath := filepath.Join(dir, "ip_database.csv")
+	if err := os.WriteFile(csvPath, []byte(csvContent), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	jsonContent, _ := json.Marshal(entries)
+	jsonPath := filepath.Join(dir, "ip_database.json")
+	if err := os.WriteFile(jsonPath, jsonContent, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fromCSV, err := parseCSV(csvPath)
+	if err != nil {
+		t.Fatalf("parseCSV() error = %v", err)
+	}
+	fromJSON, err := parseJSON(jsonPath)
+	if err != nil {
+		t.Fatalf("parseJSON() error = %v", err)
+	}
+	if len(fromCSV) != len(rangeInputs) || len(fromJSON) != len(rangeInputs) {
+		t.Fatalf("parsed %d CSV and %d JSON ranges, want %d", len(fromCSV), len(fromJSON), len(rangeInputs))
+	}
+
+	for i, in := range rangeInputs {
+		from, to, _, err := ipnum.ParseBounds(in.from, in.to)
+		if err != nil {
+			t.Fatalf("ParseBounds(%q, %q) error = %v", in.from, in.to, err)
+		}
+		for name, parsed := range map[string]IPLocation{"csv": fromCSV[i].(IPLocation), "json": fromJSON[i].(IPLocation)} {
+			if parsed.IPFrom != from || parsed.IPTo != to {
+				t.Errorf("%s %q-%q = %s - %s, want %s - %s", name, in.from, in.to, parsed.IPFrom, parsed.IPTo, from, to)
+			}
+		}
+	}
+}
+
+func TestParse_IPv4Space(t *testing.T) {
+	// ::1 would be stored as 0.0.0.1, so it is skipped with the other invalid rows
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	if err := os.WriteFile(path, []byte("::1,::1,US,,\n10.0.0.0/24,,GB,,\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	documents, err := parseCSV(path)
+	if err != nil {
+		t.Fatalf("parseCSV() error = %v", err)
+	}
+	if len(documents) != 1 || documents[0].(IPLocation).Country != "GB" {
+		t.Errorf("parseCSV() = %+v, want only the GB range", documents)
+	}
+
+	var loc IPLocation
+	if err := json.Unmarshal([]byte(`{"network": "::/112", "country": "US"}`), &loc); err == nil {
+		t.Errorf("Unmarshal() = %+v, want an error for a network in ::/96", loc)
+	}
+	// Networks extending beyond ::/96 keep their bounds
+	if err := json.Unmarshal([]byte(`{"network": "::/64", "country": "US"}`), &loc); err != nil ||
+		loc.IPFrom != (ipnum.Number{}) || loc.IPTo != (ipnum.Number{Lo: 1<<64 - 1}) {
+		t.Errorf("Unmarshal() = %+v, %v; want the bounds of ::/64", loc, err)
+	}
+}
+
+func TestIPLocation_BSON(t *testing.T) {
+	// IPv4 bounds are stored as integers and IPv6 bounds as binary, as the service reads them
+	for _, tt := range []struct {
+		network string
+		want    bsontype.Type
+	}{{"10.0.0.0/24", bsontype.Int64}, {"2001:db8::/32", bsontype.Binary}} {
+		from, to, err := ipnum.ParseRange(tt.network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := bson.Marshal(IPLocation{IPFrom: from, IPTo: to, Country: "US"})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if got := bson.Raw(doc).Lookup("ip_from").Type; got != tt.want {
+			t.Errorf("%s: ip_from stored as %s, want %s", tt.network, got, tt.want)
+		}
+		var back IPLocation
+		if err := bson.Unmarshal(doc, &back); err != nil || back.IPFrom != from || back.IPTo != to {
+			t.Errorf("%s: round trip = %s - %s, %v; want %s - %s", tt.network, back.IPFrom, back.IPTo, err, from, to)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"ip2country-service/config"
+	"ip2country-service/monitoring"
+	"ip2country-service/pkg/utils"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// APIKeyHeader and APIKeyParam are where clients present their API key. The
+// header is preferred since query strings end up in access logs.
+const (
+	APIKeyHeader = "X-API-Key"
+	APIKeyParam  = "api_key"
+)
+
+// Principal is the caller a request is attributed to and the plan it is
+// served under. Zero limits and a nil AllowedFields fall back to the
+// service-wide RATE_LIMIT, RATE_CAPACITY and ALLOWED_FIELDS.
+type Principal struct {
+	Name          string   `json:"name"`
+	RateLimit     float64  `json:"rate_limit,omitempty"`
+	RateCapacity  float64  `json:"rate_capacity,omitempty"`
+	AllowedFields []string `json:"allowed_fields,omitempty"`
+	Disabled      bool     `json:"disabled,omitempty"`
+	// Anonymous marks the plan for requests without a key; they are rate
+	// limited per client IP rather than sharing one bucket
+	Anonymous bool `json:"-"`
+}
+
+// contextKey is the key under which Middleware stores the request's Principal
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the Principal stored by Middleware, or nil if the
+// request did not pass through it
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// AnonymousPrincipal builds the plan for unauthenticated requests from cfg,
+// or returns nil when anonymous access is disabled
+func AnonymousPrincipal(cfg *config.Config) *Principal {
+	if !cfg.AnonymousAccess {
+		return nil
+	}
+	return &Principal{
+		Name:          "anonymous",
+		RateLimit:     cfg.AnonymousRateLimit,
+		RateCapacity:  cfg.AnonymousRateCapacity,
+		AllowedFields: cfg.AnonymousAllowedFields,
+		Anonymous:     true,
+	}
+}
+
+// Authenticator resolves the API key of every request to a Principal
+type Authenticator struct {
+	store     KeyStore
+	anonymous *Principal // nil rejects requests without a key
+}
+
+// NewAuthenticator builds an Authenticator checking keys against store and
+// serving requests without a key under the anonymous plan, if not nil
+func NewAuthenticator(store KeyStore, anonymous *Principal) *Authenticator {
+	return &Authenticator{store: store, anonymous: anonymous}
+}
+
+// Middleware rejects requests with a missing or unknown key with 401 and
+// requests with a disabled key with 403. Accepted requests carry their
+// Principal in the context for the rate limiter and handlers.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := APIKeyFromRequest(r)
+		if key == "" {
+			if a.anonymous == nil {
+				a.reject(w, r, http.StatusUnauthorized, "missing_key", utils.ErrMissingAPIKey)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), a.anonymous)))
+			return
+		}
+
+		principal, err := a.store.Lookup(r.Context(), key)
+		switch {
+		case errors.Is(err, utils.ErrInvalidAPIKey):
+			a.reject(w, r, http.StatusUnauthorized, "invalid_key", utils.ErrInvalidAPIKey)
+			return
+		case err != nil:
+			log.Printf("API key lookup failed: %v", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, utils.ErrInternalServer.Error())
+			return
+		case principal.Disabled:
+			a.reject(w, r, http.StatusForbidden, "disabled_key", utils.ErrAPIKeyDisabled)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+func (a *Authenticator) reject(w http.ResponseWriter, r *http.Request, status int, reason string, err error) {
+	monitoring.AuthFailures.WithLabelValues(r.URL.Path, reason).Inc()
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+	}
+	utils.RespondWithError(w, status, err.Error())
+}
+
+// APIKeyFromRequest returns the key from the X-API-Key header or, failing
+// that, the api_key query parameter
+func APIKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(APIKeyParam)
+}
+
+// RedactedURI returns the request URI with any api_key parameter masked, for logging
+func RedactedURI(r *http.Request) string {
+	query := r.URL.Query()
+	if !query.Has(APIKeyParam) {
+		return r.RequestURI
+	}
+	query.Set(APIKeyParam, "REDACTED")
+	u := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	return u.RequestURI()
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"ip2country-service/config"
+	"ip2country-service/pkg/utils"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// KeyStore resolves API keys to the Principal they belong to. Lookup
+// returns an error wrapping utils.ErrInvalidAPIKey for unknown keys.
+type KeyStore interface {
+	Lookup(ctx context.Context, key string) (*Principal, error)
+}
+
+// NewKeyStore builds the key store described by cfg: the API_KEYS_FILE file,
+// followed by Redis when API_KEYS_REDIS is set. With neither configured every
+// key is unknown and only anonymous access is possible.
+func NewKeyStore(cfg *config.Config) (KeyStore, error) {
+	var stores chainStore
+	if cfg.APIKeysFile != "" {
+		store, err := NewFileKeyStore(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	if cfg.APIKeysRedis {
+		stores = append(stores, NewRedisKeyStore(redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})))
+	}
+	return stores, nil
+}
+
+// chainStore consults each store in turn until one knows the key
+type chainStore []KeyStore
+
+func (c chainStore) Lookup(ctx context.Context, key string) (*Principal, error) {
+	for _, store := range c {
+		principal, err := store.Lookup(ctx, key)
+		if !errors.Is(err, utils.ErrInvalidAPIKey) {
+			return principal, err
+		}
+	}
+	return nil, utils.ErrInvalidAPIKey
+}
+
+// Close closes every store that holds connections
+func (c chainStore) Close() error {
+	var errs []error
+	for _, store := range c {
+		if closer, ok := store.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileKeyStore holds the keys of a JSON file in memory, e.g.
+//
+//	{"keys": [{"key": "s3cr3t", "name": "acme", "rate_limit": 50, "rate_capacity": 100, "allowed_fields": ["country"]}]}
+type FileKeyStore struct {
+	keys map[string]*Principal
+}
+
+// keyFile is the on-disk layout read by NewFileKeyStore
+type keyFile struct {
+	Keys []struct {
+		Key string `json:"key"`
+		Principal
+	} `json:"keys"`
+}
+
+func NewFileKeyStore(filePath string) (*FileKeyStore, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading API key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing API key file: %w", err)
+	}
+
+	store := &FileKeyStore{keys: make(map[string]*Principal, len(file.Keys))}
+	for i, entry := range file.Keys {
+		if entry.Key == "" || entry.Name == "" {
+			return nil, fmt.Errorf("API key file entry %d needs both a key and a name", i)
+		}
+		if _, exists := store.keys[entry.Key]; exists {
+			return nil, fmt.Errorf("API key file entry %d (%s) repeats an earlier key", i, entry.Name)
+		}
+		principal := entry.Principal
+		store.keys[entry.Key] = &principal
+	}
+
+	log.Printf("Loaded %d API keys from %s", len(store.keys), filePath)
+	return store, nil
+}
+
+func (s *FileKeyStore) Lookup(_ context.Context, key string) (*Principal, error) {
+	if principal, ok := s.keys[key]; ok {
+		return principal, nil
+	}
+	return nil, utils.ErrInvalidAPIKey
+}
+
+// RedisKeyStore reads keys from Redis hashes named api_key:<key> with the
+// fields name, rate_limit, rate_capacity, allowed_fields (comma-separated)
+// and disabled ("1" or "true"), so keys can be issued and revoked without
+// restarting the service.
+type RedisKeyStore struct {
+	client *redis.Client
+}
+
+func NewRedisKeyStore(client *redis.Client) *RedisKeyStore {
+	return &RedisKeyStore{client: client}
+}
+
+// Close releases the store's Redis connections
+func (s *RedisKeyStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisKeyStore) Lookup(ctx context.Context, key string) (*Principal, error) {
+	fields, err := s.client.HGetAll(ctx, "api_key:"+key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error reading API key from Redis: %w", err)
+	}
+	if len(fields) == 0 || fields["name"] == "" {
+		return nil, utils.ErrInvalidAPIKey
+	}
+
+	principal := &Principal{Name: fields["name"]}
+	if principal.RateLimit, err = parseOptionalFloat(fields["rate_limit"]); err != nil {
+		return nil, fmt.Errorf("invalid rate_limit for API key %s: %w", principal.Name, err)
+	}
+	if principal.RateCapacity, err = parseOptionalFloat(fields["rate_capacity"]); err != nil {
+		return nil, fmt.Errorf("invalid rate_capacity for API key %s: %w", principal.Name, err)
+	}
+	if allowed := fields["allowed_fields"]; allowed != "" {
+		for _, field := range strings.Split(allowed, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				principal.AllowedFields = append(principal.AllowedFields, field)
+			}
+		}
+	}
+	principal.Disabled, _ = strconv.ParseBool(fields["disabled"])
+	return principal, nil
+}
+
+func parseOptionalFloat(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
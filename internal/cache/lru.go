@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"container/list"
+	"ip2country-service/internal/database"
+	"ip2country-service/monitoring"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// entryOverhead approximates the memory an entry costs besides its value:
+// the range bounds, the list element, its slot in the sorted index and the
+// entry struct itself
+const entryOverhead = 160
+
+// Eviction reasons recorded in cache_evictions_total
+const (
+	reasonCapacity = "capacity"
+	reasonExpired  = "expired"
+	reasonPurge    = "purge"
+	reasonReplaced = "replaced"
+)
+
+// LRU is a concurrency-safe least-recently-used cache of address ranges: a
+// value stored for a range answers lookups for every address inside it. It
+// is bounded both by entry count and by the approximate memory its entries
+// use. Entries also expire after a TTL, the cache's default unless set per
+// entry; expired entries are dropped when next read or when they reach the
+// cold end of the list.
+type LRU struct {
+	maxEntries int           // 0 means no count limit
+	maxBytes   int64         // 0 means no memory limit
+	ttl        time.Duration // 0 means entries never expire
+
+	mu     sync.Mutex
+	order  *list.List      // front is most recently used
+	ranges []*list.Element // sorted by range start; cached ranges never overlap
+	bytes  int64
+	gen    uint64 // counts purges, see Generation
+}
+
+type entry struct {
+	r       database.Range
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+func NewLRU(maxEntries int, maxBytes int64, ttl time.Duration) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		order:      list.New(),
+	}
+}
+
+// Get returns the value cached for the range containing ip and marks it as recently used
+func (c *LRU) Get(ip database.IPNumber) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The only candidate is the last range starting at or before ip
+	i := sort.Search(len(c.ranges), func(i int) bool {
+		return ip.Less(entryOf(c.ranges[i]).r.From)
+	}) - 1
+	if i < 0 {
+		return nil, false
+	}
+	elem := c.ranges[i]
+	e := entryOf(elem)
+	if !e.r.Contains(ip) {
+		return nil, false
+	}
+	if c.expired(e, time.Now()) {
+		c.remove(elem, reasonExpired)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return e.value, true
+}
+
+// Set stores value for every address in r, replacing any cached ranges that
+// overlap it. size is the approximate memory used by the value; bookkeeping
+// is accounted for separately. Least recently used entries are evicted until
+// the cache is back within its bounds.
+func (c *LRU) Set(r database.Range, value interface{}, size int64) {
+	c.SetWithTTL(r, value, size, c.ttl)
+}
+
+// SetWithTTL is Set with an entry-specific TTL in place of the cache's default
+func (c *LRU) SetWithTTL(r database.Range, value interface{}, size int64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(r, value, size, ttl)
+}
+
+// Generation returns a token that changes whenever the cache is purged.
+// Take it before reading a value from the dataset and pass it to
+// SetIfGeneration, so a value read before a purge is not cached after it.
+func (c *LRU) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// SetIfGeneration is SetWithTTL, except that nothing is stored if the cache
+// was purged since gen was taken. It reports whether the value was stored.
+func (c *LRU) SetIfGeneration(gen uint64, r database.Range, value interface{}, size int64, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return false
+	}
+	c.set(r, value, size, ttl)
+	return true
+}
+
+// set stores value for r; c.mu must be held
+func (c *LRU) set(r database.Range, value interface{}, size int64, ttl time.Duration) {
+	size += entryOverhead
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return // would evict everything else and still not fit
+	}
+
+	// Drop overlapping ranges so every address maps to at most one entry
+	i := sort.Search(len(c.ranges), func(i int) bool {
+		return entryOf(c.ranges[i]).r.To.Compare(r.From) >= 0
+	})
+	for i < len(c.ranges) && entryOf(c.ranges[i]).r.Overlaps(r) {
+		c.remove(c.ranges[i], reasonReplaced)
+	}
+
+	e := &entry{r: r, value: value, size: size}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.ranges = slices.Insert(c.ranges, i, c.order.PushFront(e))
+	c.bytes += size
+
+	c.evict()
+	c.updateGauges()
+}
+
+// Purge removes every entry and returns how many there were
+func (c *LRU) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.ranges)
+	c.order.Init()
+	c.ranges = nil
+	c.bytes = 0
+	c.gen++
+	monitoring.CacheEvictions.WithLabelValues(reasonPurge).Add(float64(n))
+	c.updateGauges()
+	return n
+}
+
+// Len returns the number of entries, including expired ones not yet dropped
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.ranges)
+}
+
+// Bytes returns the approximate memory used by the entries
+func (c *LRU) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// evict drops entries from the cold end until the cache is within its
+// bounds, counting expired entries separately from capacity evictions
+func (c *LRU) evict() {
+	now := time.Now()
+	for elem := c.order.Back(); elem != nil && c.overLimit(); elem = c.order.Back() {
+		reason := reasonCapacity
+		if c.expired(entryOf(elem), now) {
+			reason = reasonExpired
+		}
+		c.remove(elem, reason)
+	}
+}
+
+func (c *LRU) overLimit() bool {
+	return (c.maxEntries > 0 && len(c.ranges) > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+func (c *LRU) expired(e *entry, now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+func (c *LRU) remove(elem *list.Element, reason string) {
+	e := c.order.Remove(elem).(*entry)
+	i := sort.Search(len(c.ranges), func(i int) bool {
+		return entryOf(c.ranges[i]).r.From.Compare(e.r.From) >= 0
+	})
+	c.ranges = slices.Delete(c.ranges, i, i+1)
+	c.bytes -= e.size
+	monitoring.CacheEvictions.WithLabelValues(reason).Inc()
+	c.updateGauges()
+}
+
+func (c *LRU) updateGauges() {
+	monitoring.CacheEntries.Set(float64(len(c.ranges)))
+	monitoring.CacheBytes.Set(float64(c.bytes))
+}
+
+func entryOf(elem *list.Element) *entry {
+	return elem.Value.(*entry)
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"ip2country-service/monitoring"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisKeyPrefix namespaces every key the tier writes; the dataset version
+// and the address follow it
+const redisKeyPrefix = "ip2country:lookup:"
+
+// unversioned is the key version used for databases that cannot identify
+// their dataset; their entries only go away by expiring or being purged
+const unversioned = "0"
+
+// redisBackoff is how long Redis is bypassed after an error, so an outage
+// costs one timeout per period rather than one per lookup
+const redisBackoff = 5 * time.Second
+
+// Results recorded in redis_cache_requests_total
+const (
+	resultHit     = "hit"
+	resultMiss    = "miss"
+	resultError   = "error"
+	resultSkipped = "skipped"
+)
+
+// sharedEntry is the JSON stored in Redis for one address
+type sharedEntry struct {
+	Location *models.Location `json:"location,omitempty"`
+	Range    database.Range   `json:"range"`
+	NotFound bool             `json:"not_found,omitempty"`
+}
+
+// RedisTier is a lookup cache in Redis shared by every replica, placed in
+// front of the database it wraps: lookups it has an answer for never reach
+// the database. Keys include the dataset version when the database reports
+// one, so a changed dataset invalidates every entry at once, on every
+// replica. Redis is only an optimisation: when it is slow or unreachable the
+// tier logs, counts the error and answers from the database.
+type RedisTier struct {
+	db          database.IPDatabase
+	client      *redis.Client
+	ttl         time.Duration
+	negativeTTL time.Duration // 0 disables caching not-found answers
+	timeout     time.Duration // bound on each Redis call; 0 leaves only the caller's deadline
+
+	retryAt atomic.Int64 // unix nanoseconds before which Redis is bypassed
+}
+
+// NewRedisTier wraps db with a Redis cache using client and the CACHE_TTL,
+// CACHE_NEGATIVE_TTL and REDIS_CACHE_TIMEOUT settings of cfg
+func NewRedisTier(db database.IPDatabase, client *redis.Client, cfg *config.Config) *RedisTier {
+	return &RedisTier{
+		db:          db,
+		client:      client,
+		ttl:         cfg.CacheTTL,
+		negativeTTL: cfg.CacheNegativeTTL,
+		timeout:     cfg.RedisCacheTimeout,
+	}
+}
+
+func (t *RedisTier) Find(ctx context.Context, ip string) (*models.Location, error) {
+	loc, _, err := t.FindRange(ctx, ip)
+	return loc, err
+}
+
+// FindRange answers from Redis when it holds ip, and otherwise from the
+// wrapped database, storing the answer for the other replicas
+func (t *RedisTier) FindRange(ctx context.Context, ip string) (*models.Location, database.Range, error) {
+	ipNum, err := database.IPToNumber(net.ParseIP(ip))
+	if err != nil {
+		// Let the database classify the bad input
+		return database.FindRange(ctx, t.db, ip)
+	}
+	key := t.key(ipNum)
+
+	if cached, ok := t.get(ctx, key); ok {
+		if cached.NotFound {
+			return nil, database.Range{}, &database.LookupError{Kind: database.KindNotFound, Op: "RedisTier.Find", IP: ip}
+		}
+		return cached.Location, cached.Range, nil
+	}
+
+	loc, matched, err := database.FindRange(ctx, t.db, ip)
+	switch {
+	case err == nil:
+		t.set(ctx, key, sharedEntry{Location: loc, Range: matched}, t.ttl)
+	case database.KindOf(err) == database.KindNotFound && t.negativeTTL > 0:
+		t.set(ctx, key, sharedEntry{Range: database.Range{From: ipNum, To: ipNum}, NotFound: true}, t.negativeTTL)
+	}
+	return loc, matched, err
+}
+
+// Purge deletes every entry the tier has written, for all dataset versions,
+// and returns how many there were
+func (t *RedisTier) Purge(ctx context.Context) (int, error) {
+	var purged int
+	iter := t.client.Scan(ctx, 0, redisKeyPrefix+"*", 1000).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 1000 {
+			n, err := t.client.Del(ctx, batch...).Result()
+			if err != nil {
+				return purged, err
+			}
+			purged += int(n)
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return purged, err
+	}
+	if len(batch) > 0 {
+		n, err := t.client.Del(ctx, batch...).Result()
+		if err != nil {
+			return purged, err
+		}
+		purged += int(n)
+	}
+	return purged, nil
+}
+
+// Unwrap returns the database behind the tier
+func (t *RedisTier) Unwrap() database.IPDatabase {
+	return t.db
+}
+
+// CheckHealth checks the wrapped database only: lookups keep working
+// without Redis, so its availability does not affect readiness
+func (t *RedisTier) CheckHealth(ctx context.Context) error {
+	if checker, ok := t.db.(interface{ CheckHealth(context.Context) error }); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Close releases the tier's Redis connections and the wrapped database
+func (t *RedisTier) Close() error {
+	errs := []error{t.client.Close()}
+	if closer, ok := t.db.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// key names the entry for ipNum in the current dataset version
+func (t *RedisTier) key(ipNum database.IPNumber) string {
+	version := unversioned
+	if v, ok := database.As[database.Versioned](t.db); ok && v.Version() != "" {
+		version = v.Version()
+	}
+	return redisKeyPrefix + version + ":" + ipNum.String()
+}
+
+func (t *RedisTier) get(ctx context.Context, key string) (sharedEntry, bool) {
+	var cached sharedEntry
+	if !t.available() {
+		monitoring.RedisCacheRequests.WithLabelValues(resultSkipped).Inc()
+		return cached, false
+	}
+
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	data, err := t.client.Get(ctx, key).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		monitoring.RedisCacheRequests.WithLabelValues(resultMiss).Inc()
+		return cached, false
+	case err != nil:
+		t.failed("reading", err)
+		return cached, false
+	}
+
+	if err := json.Unmarshal(data, &cached); err != nil {
+		// Written by an incompatible version; treat it as a miss and overwrite it
+		log.Printf("[RedisTier.get] Ignoring unreadable entry %s: %v", key, err)
+		monitoring.RedisCacheRequests.WithLabelValues(resultMiss).Inc()
+		return cached, false
+	}
+	monitoring.RedisCacheRequests.WithLabelValues(resultHit).Inc()
+	return cached, true
+}
+
+func (t *RedisTier) set(ctx context.Context, key string, value sharedEntry, ttl time.Duration) {
+	if !t.available() {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[RedisTier.set] Error encoding entry %s: %v", key, err)
+		return
+	}
+
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+	if err := t.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		t.failed("writing", err)
+	}
+}
+
+func (t *RedisTier) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.timeout > 0 {
+		return context.WithTimeout(ctx, t.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (t *RedisTier) available() bool {
+	return time.Now().UnixNano() >= t.retryAt.Load()
+}
+
+// failed records a Redis error and bypasses Redis for redisBackoff, unless
+// the error only reflects the caller giving up
+func (t *RedisTier) failed(action string, err error) {
+	monitoring.RedisCacheRequests.WithLabelValues(resultError).Inc()
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	log.Printf("[RedisTier] Error %s Redis cache, bypassing it for %s: %v", action, redisBackoff, err)
+	t.retryAt.Store(time.Now().Add(redisBackoff).UnixNano())
+}
@@ -0,0 +1,171 @@
+package client_ip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// contextKey is the key under which Middleware stores the resolved client IP
+type contextKey struct{}
+
+// Resolver determines the address of the client that made a request. The
+// X-Forwarded-For, X-Real-IP and RFC 7239 Forwarded headers are only honoured
+// when the request arrives from one of the trusted proxy networks, since
+// anyone else can set them to arbitrary values.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// NewResolver builds a Resolver trusting the given CIDRs. Bare addresses are
+// accepted and treated as single-host networks.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %s", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy network: %s", entry)
+		}
+		r.trusted = append(r.trusted, network)
+	}
+	return r, nil
+}
+
+// Resolve returns the client IP for req. When the peer is a trusted proxy
+// the forwarding headers are walked from the nearest hop outwards, skipping
+// further trusted proxies, and the first untrusted address is the client.
+func (r *Resolver) Resolve(req *http.Request) (string, error) {
+	peer, err := remoteIP(req)
+	if err != nil {
+		return "", err
+	}
+	if !r.isTrusted(peer) {
+		return peer.String(), nil
+	}
+
+	var hops []string
+	switch {
+	case req.Header.Get("Forwarded") != "":
+		hops = parseForwarded(req.Header.Values("Forwarded"))
+	case req.Header.Get("X-Forwarded-For") != "":
+		hops = splitList(req.Header.Values("X-Forwarded-For"))
+	case req.Header.Get("X-Real-IP") != "":
+		hops = []string{strings.TrimSpace(req.Header.Get("X-Real-IP"))}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			// Obfuscated or malformed hop: the last trusted proxy is the best we know
+			break
+		}
+		client = ip
+		if !r.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String(), nil
+}
+
+// Middleware resolves the client IP once per request and stores it in the
+// request context for FromRequest
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ip, err := r.Resolve(req); err == nil {
+			req = req.WithContext(context.WithValue(req.Context(), contextKey{}, ip))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// FromRequest returns the client IP stored by Middleware, falling back to the
+// peer address when the request did not pass through it
+func FromRequest(req *http.Request) (string, error) {
+	if ip, ok := req.Context().Value(contextKey{}).(string); ok {
+		return ip, nil
+	}
+	peer, err := remoteIP(req)
+	if err != nil {
+		return "", err
+	}
+	return peer.String(), nil
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(req *http.Request) (net.IP, error) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client address %q: %w", req.RemoteAddr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client address %q", req.RemoteAddr)
+	}
+	return ip, nil
+}
+
+// splitList flattens comma-separated header values into individual entries
+func splitList(values []string) []string {
+	var entries []string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+	return entries
+}
+
+// parseForwarded extracts the for= parameter of every element of RFC 7239
+// Forwarded headers, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, element := range splitList(values) {
+		node := ""
+		for _, pair := range strings.Split(element, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(key, "for") {
+				node = strings.Trim(value, `"`)
+			}
+		}
+		hops = append(hops, node)
+	}
+	return hops
+}
+
+// parseHop parses a forwarded address that may carry a port or IPv6 brackets
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
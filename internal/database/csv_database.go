@@ -1,112 +1,235 @@
 package database
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/csv"
+	"errors"
 	"fmt"
-	"ip2country-service/internal/models"
+	"io"
 	"ip2country-service/pkg/utils"
 	"log"
-	"net"
-	"os"
-	"sort"
+	"slices"
 	"strconv"
+	"strings"
 )
 
+// gzipMagic starts every gzip stream; such files are decompressed whatever their name
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// utf8BOM is stripped from the start of files saved by spreadsheet tools
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+func init() {
+	RegisterFormat("csv", func(r io.Reader, opts LoadOptions) ([]IPLocation, error) {
+		locations, _, err := readCSV(r, opts.CSV)
+		return locations, err
+	})
+}
+
+// CSVDatabase serves a CSV file of ranges. Lookups, validation and the rest
+// come from the embedded DatabaseLocal.
 type CSVDatabase struct {
 	DatabaseLocal
+	Report *CSVLoadReport // What was loaded and skipped
 }
 
+// NewCSVDatabase loads a comma-separated file in the default column order,
+// with or without a header row
 func NewCSVDatabase(filePath string) (*CSVDatabase, error) {
-	file, err := os.Open(filePath)
+	return NewCSVDatabaseWithOptions(filePath, CSVOptions{})
+}
+
+// NewCSVDatabaseWithOptions loads a CSV file laid out as opts describes.
+// Rows that cannot be loaded are skipped and counted in the load report.
+func NewCSVDatabaseWithOptions(filePath string, opts CSVOptions) (*CSVDatabase, error) {
+	var report *CSVLoadReport
+	local, err := loadLocal("csv", filePath, "", func(r io.Reader) ([]IPLocation, error) {
+		var locations []IPLocation
+		var err error
+		locations, report, err = readCSV(r, opts)
+		return locations, err
+	})
 	if err != nil {
-		log.Printf("Error opening CSV file: %v", err)
-		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+		return nil, err
 	}
-	defer file.Close()
+	return &CSVDatabase{DatabaseLocal: *local, Report: report}, nil
+}
 
-	reader := csv.NewReader(file)
+// readCSV reads the rows of a CSV file laid out as opts describes
+func readCSV(r io.Reader, opts CSVOptions) ([]IPLocation, *CSVLoadReport, error) {
+	reader := csv.NewReader(r)
 	reader.FieldsPerRecord = -1 // Allow variable number of fields
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Printf("Error reading CSV file: %v", err)
-		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	reader.ReuseRecord = true
+	if opts.Comma != 0 {
+		reader.Comma = opts.Comma
 	}
 
-	var locations []IPLocation
-	for _, record := range records {
-		if len(record) < 5 {
-			log.Printf("Skipping incomplete record: %v", record)
-			continue
+	locations, report, err := readCSVLocations(reader, opts)
+	if err != nil {
+		if errors.Is(err, utils.ErrInvalidDataset) {
+			return nil, report, err // the layout does not fit the file
 		}
+		return nil, report, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	log.Printf("Loaded CSV: %s", report)
+	return locations, report, nil
+}
 
-		ipFrom, err := strconv.ParseUint(record[0], 10, 32)
+// decompressedReader returns the contents of r, gunzipped if they are
+// gzip-compressed and without a leading byte order mark
+func decompressedReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(buffered)
 		if err != nil {
-			log.Printf("Error parsing ip_from: %v", err)
-			continue
+			return nil, err
 		}
+		buffered = bufio.NewReader(gz)
+	}
+	if bom, _ := buffered.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+	}
+	return buffered, nil
+}
 
-		ipTo, err := strconv.ParseUint(record[1], 10, 32)
-		if err != nil {
-			log.Printf("Error parsing ip_to: %v", err)
-			continue
-		}
+// readCSVLocations reads every row of reader, working out from the first
+// row whether there is a header and which column holds each field
+func readCSVLocations(reader *csv.Reader, opts CSVOptions) ([]IPLocation, *CSVLoadReport, error) {
+	report := newCSVLoadReport()
 
-		location := IPLocation{
-			IPFrom:  uint32(ipFrom),
-			IPTo:    uint32(ipTo),
-			Country: record[2],
-			Region:  record[3],
-			City:    record[4],
+	first, err := reader.Read()
+	if err == io.EOF {
+		return nil, report, nil
+	}
+	if err != nil {
+		return nil, report, err
+	}
+
+	hasHeader := opts.Header == HeaderPresent
+	if opts.Header == HeaderAuto {
+		index := opts.ipFromIndex()
+		hasHeader = opts.namedColumns() || index >= len(first)
+		if !hasHeader {
+			_, err := ParseRange(first[index])
+			hasHeader = err != nil
 		}
+	}
 
-		locations = append(locations, location)
+	var header []string
+	if hasHeader {
+		header = slices.Clone(first)
+	}
+	cols, err := opts.resolveColumns(header)
+	if err != nil {
+		return nil, report, fmt.Errorf("%w: %v", utils.ErrInvalidDataset, err)
 	}
 
-	// Sort the locations by IPFrom for efficient searching
-	sort.Slice(locations, func(i, j int) bool {
-		return locations[i].IPFrom < locations[j].IPFrom
-	})
+	var locations []IPLocation
+	record := first
+	if hasHeader {
+		record, err = readCSVRow(reader, report)
+	}
+	for ; err == nil; record, err = readCSVRow(reader, report) {
+		report.Rows++
+		line, _ := reader.FieldPos(0)
+		location, reason := parseCSVRow(record, cols)
+		if reason != "" {
+			report.skip(reason, line)
+			continue
+		}
+		locations = append(locations, location)
+	}
+	if err != io.EOF {
+		return nil, report, err
+	}
 
-	log.Printf("Loaded %d locations from CSV", len(locations))
-	return &CSVDatabase{DatabaseLocal{Locations: locations}}, nil
+	report.Loaded = len(locations)
+	return locations, report, nil
 }
 
-func ipToUint32CSV(ipStr string) (uint32, error) {
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		return 0, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
-	}
-	ip = ip.To4()
-	if ip == nil {
-		return 0, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
+// readCSVRow reads the next row, counting and skipping rows that are not
+// valid CSV, such as ones with unbalanced quotes
+func readCSVRow(reader *csv.Reader, report *CSVLoadReport) ([]string, error) {
+	for {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		if !errors.As(err, &parseErr) {
+			return record, err
+		}
+		report.Rows++
+		report.skip("malformed row", parseErr.StartLine)
 	}
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]), nil
 }
 
-func (db *CSVDatabase) Find(ipStr string) (*models.Location, error) {
-	const funcName = "CSVDatabase.Find"
-	ipNum, err := ipToUint32CSV(ipStr)
-	if err != nil {
-		log.Printf("[%s] Error converting IP '%s' to uint32: %v", funcName, ipStr, err)
-		return nil, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
+// parseCSVRow turns a row into a range, or returns why it cannot be loaded
+func parseCSVRow(record []string, cols csvColumns) (IPLocation, string) {
+	column := func(field int) string {
+		if i := cols[field]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	if cols[colCountry] >= len(record) {
+		return IPLocation{}, "incomplete row"
 	}
 
-	// Binary search to find the IP range
-	index := sort.Search(len(db.Locations), func(i int) bool {
-		return db.Locations[i].IPTo >= ipNum
-	})
+	var r Range
+	if network := column(colNetwork); network != "" {
+		var err error
+		if r, err = ParseRange(network); err != nil {
+			return IPLocation{}, "invalid network"
+		}
+	} else {
+		if cols[colIPFrom] < 0 || cols[colIPFrom] >= len(record) {
+			return IPLocation{}, "incomplete row"
+		}
+		var invalid string
+		var err error
+		if r, invalid, err = parseBounds(column(colIPFrom), column(colIPTo)); err != nil {
+			return IPLocation{}, "invalid " + invalid
+		}
+	}
 
-	if index < len(db.Locations) && db.Locations[index].IPFrom <= ipNum {
-		loc := db.Locations[index]
-		log.Printf("[%s] IP '%s' found in range %d - %d", funcName, ipStr, loc.IPFrom, loc.IPTo)
-		return &models.Location{
-			Country: loc.Country,
-			Region:  loc.Region,
-			City:    loc.City,
-		}, nil
+	location := IPLocation{
+		IPFrom:       r.From,
+		IPTo:         r.To,
+		Country:      column(colCountry),
+		Region:       column(colRegion),
+		City:         column(colCity),
+		PostalCode:   column(colPostalCode),
+		TimeZone:     column(colTimeZone),
+		Organization: column(colOrganization),
 	}
 
-	log.Printf("[%s] IP '%s' not found in any range", funcName, ipStr)
-	return nil, fmt.Errorf("%w: %s", utils.ErrDatabaseQuery, ipStr)
+	if value := column(colLatitude); value != "" {
+		latitude, err := strconv.ParseFloat(value, 64)
+		if err != nil || latitude < -90 || latitude > 90 {
+			return IPLocation{}, "invalid latitude"
+		}
+		location.Latitude = &latitude
+	}
+	if value := column(colLongitude); value != "" {
+		longitude, err := strconv.ParseFloat(value, 64)
+		if err != nil || longitude < -180 || longitude > 180 {
+			return IPLocation{}, "invalid longitude"
+		}
+		location.Longitude = &longitude
+	}
+	if value := column(colAccuracyRadius); value != "" {
+		radius, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return IPLocation{}, "invalid accuracy_radius"
+		}
+		location.AccuracyRadius = uint16(radius)
+	}
+	if value := strings.TrimPrefix(strings.ToUpper(column(colASN)), "AS"); value != "" {
+		asn, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return IPLocation{}, "invalid asn"
+		}
+		location.ASN = uint32(asn)
+	}
+	return location, ""
 }
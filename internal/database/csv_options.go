@@ -0,0 +1,293 @@
+package database
+
+import (
+	"fmt"
+	"ip2country-service/config"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Fields a CSV column can be mapped to. Without a mapping or a header, the
+// columns are expected in this order, up to organization. Only ip_from and
+// country are required: ip_from may hold a CIDR or a first-last range when
+// ip_to is left empty, and a network column holding such a range can stand
+// in for both.
+const (
+	colIPFrom = iota
+	colIPTo
+	colCountry
+	colRegion
+	colCity
+	colLatitude
+	colLongitude
+	colAccuracyRadius
+	colPostalCode
+	colTimeZone
+	colASN
+	colOrganization
+	colNetwork
+	csvFieldCount
+)
+
+// csvFieldNames are the names fields are configured and reported by
+var csvFieldNames = [csvFieldCount]string{
+	"ip_from", "ip_to", "country", "region", "city", "latitude", "longitude",
+	"accuracy_radius", "postal_code", "timezone", "asn", "organization", "network",
+}
+
+// csvHeaderAliases are the header names recognised for each field when no
+// mapping is configured, after normalizeHeader
+var csvHeaderAliases = [csvFieldCount][]string{
+	{"ip_from", "ip_start", "start_ip", "range_start", "network_start"},
+	{"ip_to", "ip_end", "end_ip", "range_end", "network_end"},
+	{"country", "country_code", "country_iso_code", "cc"},
+	{"region", "region_name", "state", "stateprov", "subdivision"},
+	{"city", "city_name"},
+	{"latitude", "lat"},
+	{"longitude", "lon", "lng"},
+	{"accuracy_radius"},
+	{"postal_code", "zip_code", "zip", "postcode"},
+	{"timezone", "time_zone"},
+	{"asn", "autonomous_system_number"},
+	{"organization", "org", "as_organization", "autonomous_system_organization"},
+	{"network", "cidr", "ip_range", "range"},
+}
+
+// CSVLayouts maps well-known third-party layouts to their column mappings.
+// Both are headerless files.
+var CSVLayouts = map[string]map[string]string{
+	// IP2Location DB1 to DB11: ip_from, ip_to, country_code, country_name, region, city, latitude, longitude, zip_code, time_zone
+	"ip2location": {"ip_from": "0", "ip_to": "1", "country": "2", "region": "4", "city": "5", "latitude": "6", "longitude": "7", "postal_code": "8", "timezone": "9"},
+	// DB-IP IP to City: ip_start, ip_end, continent, country, stateprov, city, latitude, longitude
+	"dbip": {"ip_from": "0", "ip_to": "1", "country": "3", "region": "4", "city": "5", "latitude": "6", "longitude": "7"},
+}
+
+// HeaderMode says whether a CSV file starts with a header row
+type HeaderMode int
+
+const (
+	// HeaderAuto treats the first row as a header if its ip_from cell is not an address or range
+	HeaderAuto HeaderMode = iota
+	HeaderPresent
+	HeaderAbsent
+)
+
+// CSVOptions describes the layout of a CSV dataset. The zero value reads
+// comma-separated files in the default column order, with or without a header.
+type CSVOptions struct {
+	Comma  rune // Field delimiter; 0 means ','
+	Header HeaderMode
+	// Columns maps field names to a header name or a 0-based column index.
+	// Fields left out are not loaded. When empty, columns are found by their
+	// header names, or taken in the default order if there is no header.
+	Columns map[string]string
+}
+
+// CSVOptionsFromConfig builds the CSV options from the CSV_* settings
+func CSVOptionsFromConfig(cfg *config.Config) (CSVOptions, error) {
+	var opts CSVOptions
+
+	switch strings.ToLower(cfg.CSVDelimiter) {
+	case "", ",":
+	case "tab", `\t`:
+		opts.Comma = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(cfg.CSVDelimiter)
+		if size != len(cfg.CSVDelimiter) || r == '"' || r == '\r' || r == '\n' {
+			return opts, fmt.Errorf("invalid CSV_DELIMITER %q: must be a single character other than a quote or newline", cfg.CSVDelimiter)
+		}
+		opts.Comma = r
+	}
+
+	switch strings.ToLower(cfg.CSVHeader) {
+	case "", "auto":
+		opts.Header = HeaderAuto
+	case "true":
+		opts.Header = HeaderPresent
+	case "false":
+		opts.Header = HeaderAbsent
+	default:
+		return opts, fmt.Errorf("invalid CSV_HEADER %q: must be auto, true or false", cfg.CSVHeader)
+	}
+
+	if cfg.CSVLayout != "" || len(cfg.CSVColumns) > 0 {
+		opts.Columns = make(map[string]string)
+	}
+	if cfg.CSVLayout != "" {
+		layout, ok := CSVLayouts[strings.ToLower(cfg.CSVLayout)]
+		if !ok {
+			return opts, fmt.Errorf("unknown CSV_LAYOUT %q", cfg.CSVLayout)
+		}
+		for field, column := range layout {
+			opts.Columns[field] = column
+		}
+	}
+	for _, entry := range cfg.CSVColumns {
+		field, column, found := strings.Cut(entry, "=")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !found || column == "" {
+			return opts, fmt.Errorf("invalid CSV_COLUMNS entry %q: want field=column", entry)
+		}
+		if column == "-" {
+			delete(opts.Columns, field) // drop a field the layout maps
+			continue
+		}
+		opts.Columns[field] = column
+	}
+	return opts, nil
+}
+
+// csvColumns holds the column index of each field, or -1 if it is not loaded
+type csvColumns [csvFieldCount]int
+
+// resolveColumns finds the column of every field, using header when the file has one
+func (opts CSVOptions) resolveColumns(header []string) (csvColumns, error) {
+	var cols csvColumns
+	for i := range cols {
+		cols[i] = -1
+	}
+
+	var positions map[string]int
+	if header != nil {
+		positions = make(map[string]int, len(header))
+		for i, name := range header {
+			if _, seen := positions[normalizeHeader(name)]; !seen {
+				positions[normalizeHeader(name)] = i
+			}
+		}
+	}
+
+	switch {
+	case len(opts.Columns) > 0:
+		for field, column := range opts.Columns {
+			i := csvFieldIndex(field)
+			if i < 0 {
+				return cols, fmt.Errorf("unknown CSV field %q, expected one of %s", field, strings.Join(csvFieldNames[:], ", "))
+			}
+			if index, err := strconv.Atoi(column); err == nil {
+				if index < 0 {
+					return cols, fmt.Errorf("invalid column index %d for %s", index, field)
+				}
+				cols[i] = index
+				continue
+			}
+			if header == nil {
+				return cols, fmt.Errorf("column %q for %s is named, but the file has no header", column, field)
+			}
+			index, ok := positions[normalizeHeader(column)]
+			if !ok {
+				return cols, fmt.Errorf("header has no column %q for %s", column, field)
+			}
+			cols[i] = index
+		}
+	case header != nil:
+		for i, aliases := range csvHeaderAliases {
+			for _, alias := range aliases {
+				if index, ok := positions[alias]; ok {
+					cols[i] = index
+					break
+				}
+			}
+		}
+	default:
+		for i := colIPFrom; i <= colOrganization; i++ {
+			cols[i] = i
+		}
+	}
+
+	if cols[colIPFrom] < 0 && cols[colNetwork] < 0 {
+		return cols, fmt.Errorf("no column for %s or %s", csvFieldNames[colIPFrom], csvFieldNames[colNetwork])
+	}
+	if cols[colCountry] < 0 {
+		return cols, fmt.Errorf("no column for %s", csvFieldNames[colCountry])
+	}
+	return cols, nil
+}
+
+// ipFromIndex is the column HeaderAuto checks for an address before the header is known
+func (opts CSVOptions) ipFromIndex() int {
+	for _, field := range []string{"ip_from", "network"} {
+		if index, err := strconv.Atoi(opts.Columns[field]); err == nil && index >= 0 {
+			return index
+		}
+	}
+	return 0
+}
+
+// namedColumns reports whether any mapped column is a header name rather than an index
+func (opts CSVOptions) namedColumns() bool {
+	for _, column := range opts.Columns {
+		if _, err := strconv.Atoi(column); err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func csvFieldIndex(name string) int {
+	for i, field := range csvFieldNames {
+		if field == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeHeader lower-cases a header cell and joins its words with underscores
+func normalizeHeader(name string) string {
+	name = strings.ToLower(strings.Trim(strings.TrimSpace(name), `"`))
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_' || r == '.'
+	}), "_")
+}
+
+// CSVLoadReport summarises a CSV load: how many rows were read and, for the
+// rows that could not be loaded, why
+type CSVLoadReport struct {
+	Rows    int            // Data rows read, excluding the header
+	Loaded  int            // Rows turned into ranges
+	Skipped map[string]int // Number of skipped rows by reason
+	// FirstLine is the line of the first row skipped for each reason
+	FirstLine map[string]int
+}
+
+func newCSVLoadReport() *CSVLoadReport {
+	return &CSVLoadReport{Skipped: make(map[string]int), FirstLine: make(map[string]int)}
+}
+
+func (r *CSVLoadReport) skip(reason string, line int) {
+	if r.Skipped[reason] == 0 {
+		r.FirstLine[reason] = line
+	}
+	r.Skipped[reason]++
+}
+
+// SkippedRows returns the total number of rows skipped
+func (r *CSVLoadReport) SkippedRows() int {
+	var n int
+	for _, count := range r.Skipped {
+		n += count
+	}
+	return n
+}
+
+func (r *CSVLoadReport) String() string {
+	summary := fmt.Sprintf("loaded %d of %d rows", r.Loaded, r.Rows)
+	if len(r.Skipped) == 0 {
+		return summary
+	}
+
+	reasons := make([]string, 0, len(r.Skipped))
+	for reason := range r.Skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	details := make([]string, len(reasons))
+	for i, reason := range reasons {
+		details[i] = fmt.Sprintf("%d %s (first at line %d)", r.Skipped[reason], reason, r.FirstLine[reason])
+	}
+	return fmt.Sprintf("%s, skipped %s", summary, strings.Join(details, ", "))
+}
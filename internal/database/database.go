@@ -1,36 +1,173 @@
 package database
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"ip2country-service/config"
 	"ip2country-service/internal/models"
+	"ip2country-service/pkg/ipnum"
 )
 
 type IPLocation struct {
-	IPFrom  uint32 `json:"ip_from"`
-	IPTo    uint32 `json:"ip_to"`
-	Country string `json:"country"`
-	Region  string `json:"region"`
-	City    string `json:"city"`
+	IPFrom  IPNumber `json:"ip_from" bson:"ip_from"`
+	IPTo    IPNumber `json:"ip_to" bson:"ip_to"`
+	Country string   `json:"country" bson:"country"`
+	Region  string   `json:"region" bson:"region"`
+	City    string   `json:"city" bson:"city"`
+	// Optional details, left empty when the dataset does not provide them
+	Latitude       *float64 `json:"latitude,omitempty" bson:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty" bson:"longitude,omitempty"`
+	AccuracyRadius uint16   `json:"accuracy_radius,omitempty" bson:"accuracy_radius,omitempty"`
+	PostalCode     string   `json:"postal_code,omitempty" bson:"postal_code,omitempty"`
+	TimeZone       string   `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	ASN            uint32   `json:"asn,omitempty" bson:"asn,omitempty"`
+	Organization   string   `json:"organization,omitempty" bson:"organization,omitempty"`
 }
 
-type DatabaseLocal struct {
-	Locations []IPLocation
+// UnmarshalJSON reads a dataset entry whose range is given by ip_from and
+// ip_to, as integers or address strings, or by a network such as
+// "10.1.0.0/16" or "10.1.0.0-10.1.255.255". ip_from may also hold a network
+// when ip_to is absent.
+func (l *IPLocation) UnmarshalJSON(data []byte) error {
+	type fields IPLocation // without this method
+	var entry struct {
+		fields
+		IPFrom  json.RawMessage `json:"ip_from"`
+		IPTo    json.RawMessage `json:"ip_to"`
+		Network string          `json:"network"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+
+	from, to, err := ipnum.ParseJSONBounds(entry.Network, entry.IPFrom, entry.IPTo)
+	if err != nil {
+		return err
+	}
+	*l = IPLocation(entry.fields)
+	l.IPFrom, l.IPTo = from, to
+	return nil
 }
 
+// toLocation returns the lookup answer for an address in the range
+func (l *IPLocation) toLocation() *models.Location {
+	return &models.Location{
+		Country:        l.Country,
+		Region:         l.Region,
+		City:           l.City,
+		Latitude:       l.Latitude,
+		Longitude:      l.Longitude,
+		AccuracyRadius: l.AccuracyRadius,
+		PostalCode:     l.PostalCode,
+		TimeZone:       l.TimeZone,
+		ASN:            l.ASN,
+		Organization:   l.Organization,
+	}
+}
+
+// validateCoordinates checks that the range's coordinates, if any, lie on the globe
+func (l *IPLocation) validateCoordinates() error {
+	if l.Latitude != nil && (*l.Latitude < -90 || *l.Latitude > 90) {
+		return fmt.Errorf("latitude %v is out of range", *l.Latitude)
+	}
+	if l.Longitude != nil && (*l.Longitude < -180 || *l.Longitude > 180) {
+		return fmt.Errorf("longitude %v is out of range", *l.Longitude)
+	}
+	return nil
+}
+
+// IPDatabase resolves an IP address to its location. Implementations must
+// stop work and return a KindTimeout error once ctx is done.
 type IPDatabase interface {
-	Find(ip string) (*models.Location, error)
+	Find(ctx context.Context, ip string) (*models.Location, error)
 }
 
 func NewIPDatabase(cfg *config.Config) (IPDatabase, error) {
+	mode, err := ParseValidationMode(cfg.DatasetValidation)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LookupIndex != "" && cfg.LookupIndex != "binary" && cfg.LookupIndex != "trie" {
+		return nil, fmt.Errorf("invalid LOOKUP_INDEX %q: must be binary or trie", cfg.LookupIndex)
+	}
+	trie := cfg.LookupIndex == "trie"
+
 	switch cfg.DatabaseType {
-	case "csv":
-		return NewCSVDatabase(cfg.DatabasePath)
-	case "json":
-		return NewJSONDatabase(cfg.DatabasePath)
 	case "mongodb":
 		return NewMongoDatabase(cfg.MongoDBURI, cfg.MongoDBName)
-	default:
+	case "mmdb":
+		return NewReloadableDatabase(cfg.DatabasePath, LoadMMDB)
+	case "snapshot":
+		return NewReloadableDatabase(cfg.DatabasePath, LoadSnapshot)
+	}
+
+	// Any other type names a format served from memory
+	if _, ok := lookupFormat(cfg.DatabaseType); !ok {
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.DatabaseType)
 	}
+	csvOpts, err := CSVOptionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := LoadOptions{CSV: csvOpts, Validation: mode}
+	return NewReloadableDatabase(cfg.DatabasePath, localLoader(cfg.DatabaseType, opts, trie))
+}
+
+// File loaders used by NewIPDatabase; they return a nil interface on error
+// rather than a typed nil pointer.
+
+// localLoader loads files in format, answering from a TrieDatabase over
+// their ranges when trie is set
+func localLoader(format string, opts LoadOptions, trie bool) FileLoader {
+	return func(file *DatasetFile) (IPDatabase, error) {
+		db, err := readLocalFile(format, file, opts)
+		if err != nil {
+			return nil, err
+		}
+		if trie {
+			return NewTrieDatabase(*db), nil
+		}
+		return db, nil
+	}
+}
+
+// LoadSnapshot is the FileLoader for snapshots, serving the mapped file
+func LoadSnapshot(file *DatasetFile) (IPDatabase, error) {
+	if err := checkSnapshotSize(file.Path, file.Size()); err != nil {
+		return nil, err
+	}
+	data, unmap, err := file.Map()
+	if err != nil {
+		return nil, err
+	}
+	db, err := newSnapshotDatabase(file.Path, data, unmap)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// LoadMMDB is the FileLoader for MaxMind DB files, serving the mapped file
+func LoadMMDB(file *DatasetFile) (IPDatabase, error) {
+	data, unmap, err := file.Map()
+	if err != nil {
+		return nil, err
+	}
+	db, err := newMMDBDatabase(file.Path, data, unmap)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// ReadLocations parses a dataset file in one of the registered formats
+// into its ranges, sorted by IPFrom, for tools that work on the data rather
+// than serve it
+func ReadLocations(format, path string, opts CSVOptions) ([]IPLocation, error) {
+	db, err := NewLocalDatabase(format, path, LoadOptions{CSV: opts})
+	if err != nil {
+		return nil, err
+	}
+	return db.Locations, nil
 }
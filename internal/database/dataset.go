@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// sidecarSuffix names the optional file describing a dataset, next to it:
+// the sidecar of ip_database.csv is ip_database.csv.meta.json
+const sidecarSuffix = ".meta.json"
+
+// DatasetMetadata says which dataset a database serves, so an answer can be
+// traced back to the data it came from. Fields a backend cannot know are
+// left empty.
+type DatasetMetadata struct {
+	Type string `json:"type"` // the IP_DATABASE_TYPE serving it
+	// Path is the dataset file, or the database and collection for MongoDB
+	Path string `json:"path,omitempty"`
+	// Checksum is the SHA-256 of the file; Version is a prefix of it, or
+	// combines the versions of a dataset and its overrides
+	Checksum string `json:"checksum,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Records  int    `json:"records,omitempty"`
+	// Vendor, VendorVersion and BuildDate come from the sidecar file or the
+	// MMDB metadata; snapshots record when they were compiled
+	Vendor        string     `json:"vendor,omitempty"`
+	VendorVersion string     `json:"vendor_version,omitempty"`
+	BuildDate     *time.Time `json:"build_date,omitempty"`
+	LoadedAt      *time.Time `json:"loaded_at,omitempty"`
+	// Overrides describes the overrides file answering before the dataset
+	Overrides *DatasetMetadata `json:"overrides,omitempty"`
+}
+
+// Described is implemented by databases that can describe the dataset they serve
+type Described interface {
+	Dataset(ctx context.Context) DatasetMetadata
+}
+
+// Describe returns the metadata of the dataset behind db, looking through
+// decorators that do not describe it themselves
+func Describe(ctx context.Context, db IPDatabase) (DatasetMetadata, bool) {
+	described, ok := As[Described](db)
+	if !ok {
+		return DatasetMetadata{}, false
+	}
+	return described.Dataset(ctx), true
+}
+
+// sidecar is the optional file describing a dataset file
+type sidecar struct {
+	Vendor    string `json:"vendor"`
+	Version   string `json:"version"`
+	BuildDate string `json:"build_date"` // RFC 3339 or YYYY-MM-DD
+}
+
+// readSidecar reads the sidecar of the dataset at path. It returns nil
+// without error if there is none.
+func readSidecar(path string) (*sidecar, error) {
+	data, err := os.ReadFile(path + sidecarSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s sidecar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s%s: %v", path, sidecarSuffix, err)
+	}
+	if s.BuildDate != "" {
+		if _, err := s.buildDate(); err != nil {
+			return nil, fmt.Errorf("%s%s: invalid build_date %q", path, sidecarSuffix, s.BuildDate)
+		}
+	}
+	return &s, nil
+}
+
+func (s *sidecar) buildDate() (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s.BuildDate); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s.BuildDate)
+}
+
+// apply fills in the metadata the sidecar provides, which takes precedence
+// over what the dataset says about itself
+func (s *sidecar) apply(meta *DatasetMetadata) {
+	if s == nil {
+		return
+	}
+	if s.Vendor != "" {
+		meta.Vendor = s.Vendor
+	}
+	if s.Version != "" {
+		meta.VendorVersion = s.Version
+	}
+	if date, err := s.buildDate(); err == nil && s.BuildDate != "" {
+		meta.BuildDate = &date
+	}
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"ip2country-service/pkg/utils"
+	"math"
+	"os"
+)
+
+// DatasetFile is a dataset file opened once for a load. Whatever a loader
+// reads or maps through it is hashed on the way, so the checksum reported
+// for a snapshot is that of exactly the bytes it was built from, even if
+// the file is replaced while it loads.
+type DatasetFile struct {
+	Path   string
+	file   *os.File
+	state  fileState
+	hash   hash.Hash
+	reader io.Reader // file, through hash
+	mapped bool
+}
+
+// openDatasetFile opens the file at path for a FileLoader
+func openDatasetFile(path string) (*DatasetFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s is a directory", utils.ErrDatabaseQuery, path)
+	}
+	h := sha256.New()
+	return &DatasetFile{
+		Path:   path,
+		file:   file,
+		state:  fileState{modTime: info.ModTime(), size: info.Size()},
+		hash:   h,
+		reader: io.TeeReader(file, h),
+	}, nil
+}
+
+// Read reads the file's contents, hashing them
+func (f *DatasetFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Size returns the size of the file as opened
+func (f *DatasetFile) Size() int64 {
+	return f.state.size
+}
+
+// Map maps the whole file into memory, for loaders that serve it in place,
+// and hashes the mapping. It must be called before anything is read.
+func (f *DatasetFile) Map() ([]byte, func([]byte) error, error) {
+	if f.state.size > math.MaxInt {
+		return nil, nil, fmt.Errorf("%w: %s is too large to map", utils.ErrInvalidDataset, f.Path)
+	}
+	data, unmap, err := mapFile(f.file, int(f.state.size))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	f.hash.Write(data)
+	f.mapped = true
+	return data, unmap, nil
+}
+
+// digest returns the hex SHA-256 digest of the file, hashing whatever the
+// loader left unread
+func (f *DatasetFile) digest() (string, error) {
+	if !f.mapped {
+		if _, err := io.Copy(io.Discard, f.reader); err != nil {
+			return "", fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+		}
+	}
+	return hex.EncodeToString(f.hash.Sum(nil)), nil
+}
+
+// Close closes the file. Mappings made by Map stay valid.
+func (f *DatasetFile) Close() error {
+	return f.file.Close()
+}
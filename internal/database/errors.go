@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"ip2country-service/pkg/utils"
+)
+
+// ErrorKind classifies why a lookup failed. Its value doubles as the status
+// label recorded in http_requests_total.
+type ErrorKind string
+
+const (
+	KindInvalidInput ErrorKind = "invalid_input"
+	KindNotFound     ErrorKind = "not_found"
+	KindTimeout      ErrorKind = "timeout"
+	KindBackend      ErrorKind = "backend_error"
+)
+
+// sentinel returns the utils error that errors.Is matches for the kind
+func (k ErrorKind) sentinel() error {
+	switch k {
+	case KindInvalidInput:
+		return utils.ErrInvalidIP
+	case KindNotFound:
+		return utils.ErrIpNotFound
+	case KindTimeout:
+		return utils.ErrDatabaseTimeout
+	default:
+		return utils.ErrDatabaseQuery
+	}
+}
+
+// LookupError is returned by every IPDatabase backend when Find fails.
+// errors.Is matches it against the utils sentinel for its kind, so
+// errors.Is(err, utils.ErrIpNotFound) holds for every backend's misses.
+type LookupError struct {
+	Kind ErrorKind
+	Op   string // backend method that failed, e.g. "CSVDatabase.Find"
+	IP   string
+	Err  error // underlying cause, if any
+}
+
+func (e *LookupError) Error() string {
+	msg := e.Kind.sentinel().Error()
+	if e.IP != "" {
+		msg += ": " + e.IP
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LookupError) Is(target error) bool {
+	return target == e.Kind.sentinel()
+}
+
+func invalidInputError(op, ip string) error {
+	return &LookupError{Kind: KindInvalidInput, Op: op, IP: ip}
+}
+
+func notFoundError(op, ip string) error {
+	return &LookupError{Kind: KindNotFound, Op: op, IP: ip}
+}
+
+func timeoutError(op, ip string, err error) error {
+	return &LookupError{Kind: KindTimeout, Op: op, IP: ip, Err: err}
+}
+
+// backendError wraps a failure of the underlying store, classifying
+// deadline and cancellation errors as timeouts
+func backendError(op, ip string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return timeoutError(op, ip, err)
+	}
+	return &LookupError{Kind: KindBackend, Op: op, IP: ip, Err: err}
+}
+
+// KindOf classifies any error returned by an IPDatabase. Errors from custom
+// implementations that only wrap the utils sentinels are classified too;
+// anything unrecognised is a backend failure.
+func KindOf(err error) ErrorKind {
+	var lookupErr *LookupError
+	switch {
+	case errors.As(err, &lookupErr):
+		return lookupErr.Kind
+	case errors.Is(err, utils.ErrInvalidIP), errors.Is(err, utils.ErrUnsupportedIPFormat):
+		return KindInvalidInput
+	case errors.Is(err, utils.ErrIpNotFound):
+		return KindNotFound
+	case errors.Is(err, utils.ErrDatabaseTimeout), errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return KindTimeout
+	default:
+		return KindBackend
+	}
+}
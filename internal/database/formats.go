@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// FormatLoader parses the contents of a dataset file into its ranges, in
+// any order. Errors should wrap a sentinel from pkg/utils.
+type FormatLoader func(r io.Reader, opts LoadOptions) ([]IPLocation, error)
+
+// LoadOptions carries the settings a FormatLoader may need
+type LoadOptions struct {
+	CSV        CSVOptions // layout of CSV files
+	Validation ValidationMode
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[string]FormatLoader)
+)
+
+// RegisterFormat makes a dataset format available under name, as an
+// IP_DATABASE_TYPE and to NewLocalDatabase. Datasets in the format are
+// served by DatabaseLocal like the built-in ones. It panics if name is
+// already registered.
+func RegisterFormat(name string, load FormatLoader) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if _, ok := formats[name]; ok {
+		panic(fmt.Sprintf("database: format %q registered twice", name))
+	}
+	formats[name] = load
+}
+
+func lookupFormat(name string) (FormatLoader, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	load, ok := formats[name]
+	return load, ok
+}
+
+// Formats returns the names of the registered dataset formats
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
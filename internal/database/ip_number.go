@@ -0,0 +1,39 @@
+package database
+
+import (
+	"fmt"
+	"ip2country-service/pkg/ipnum"
+	"ip2country-service/pkg/utils"
+	"net"
+)
+
+// IPNumber is an unsigned 128-bit integer holding an IPv4 or IPv6 address.
+// It is defined in pkg/ipnum, which the migration tool shares, so both read
+// dataset ranges the same way.
+type IPNumber = ipnum.Number
+
+// IPv4Number returns the IPNumber for an IPv4 address given as a 32-bit integer
+func IPv4Number(v uint32) IPNumber {
+	return ipnum.FromIPv4(v)
+}
+
+// IPToNumber converts a parsed IP address to its IPNumber. IPv6 addresses
+// in ::/96 other than IPv4-mapped ones are refused, as their numbers would
+// be those of IPv4 addresses.
+func IPToNumber(ip net.IP) (IPNumber, error) {
+	return ipnum.FromIP(ip)
+}
+
+// ipStringToNumber parses an IPv4 or IPv6 address string into its IPNumber
+func ipStringToNumber(ipStr string) (IPNumber, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return IPNumber{}, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
+	}
+	return IPToNumber(ip)
+}
+
+// ParseIPNumber parses a base-10 integer of up to 128 bits
+func ParseIPNumber(s string) (IPNumber, error) {
+	return ipnum.ParseNumber(s)
+}
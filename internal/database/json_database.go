@@ -1,80 +1,61 @@
 package database
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"ip2country-service/internal/models"
+	"io"
 	"ip2country-service/pkg/utils"
-	"log"
-	"net"
-	"os"
-	"sort"
 )
 
+func init() {
+	RegisterFormat("json", readJSON)
+	RegisterFormat("ndjson", readNDJSON)
+}
+
+// JSONDatabase serves a JSON array of ranges. Lookups, validation and the
+// rest come from the embedded DatabaseLocal.
 type JSONDatabase struct {
 	DatabaseLocal
 }
 
 func NewJSONDatabase(filePath string) (*JSONDatabase, error) {
-	file, err := os.Open(filePath)
+	local, err := NewLocalDatabase("json", filePath, LoadOptions{})
 	if err != nil {
-		log.Printf("Error opening JSON file: %v", err)
-		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+		return nil, err
 	}
-	defer file.Close()
+	return &JSONDatabase{*local}, nil
+}
 
-	decoder := json.NewDecoder(file)
+// readJSON decodes a JSON array of IPLocation entries
+func readJSON(r io.Reader, _ LoadOptions) ([]IPLocation, error) {
 	var locations []IPLocation
-
-	err = decoder.Decode(&locations)
-	if err != nil {
-		log.Printf("Error decoding JSON: %v", err)
+	if err := json.NewDecoder(r).Decode(&locations); err != nil {
 		return nil, fmt.Errorf("%w: %v", utils.ErrJSONUnmarshal, err)
 	}
-
-	// Sort the locations by IPFrom for efficient searching
-	sort.Slice(locations, func(i, j int) bool {
-		return locations[i].IPFrom < locations[j].IPFrom
-	})
-
-	return &JSONDatabase{DatabaseLocal{Locations: locations}}, nil
-}
-
-func ipToUint32Json(ipStr string) (uint32, error) {
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		return 0, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
-	}
-	ip = ip.To4()
-	if ip == nil {
-		return 0, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
-	}
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]), nil
+	return locations, nil
 }
 
-func (db *JSONDatabase) Find(ipStr string) (*models.Location, error) {
-	const funcName = "JSONDatabase.Find"
-	ipNum, err := ipToUint32Json(ipStr)
-	if err != nil {
-		log.Printf("[%s] Error converting IP '%s' to uint32: %v", funcName, ipStr, err)
-		return nil, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
+// readNDJSON decodes newline-delimited JSON, one IPLocation entry per line.
+// Blank lines are ignored.
+func readNDJSON(r io.Reader, _ LoadOptions) ([]IPLocation, error) {
+	var locations []IPLocation
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		entry := bytes.TrimSpace(scanner.Bytes())
+		if len(entry) == 0 {
+			continue
+		}
+		var location IPLocation
+		if err := json.Unmarshal(entry, &location); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", utils.ErrJSONUnmarshal, line, err)
+		}
+		locations = append(locations, location)
 	}
-
-	// Binary search to find the IP range
-	index := sort.Search(len(db.Locations), func(i int) bool {
-		return db.Locations[i].IPTo >= ipNum
-	})
-
-	if index < len(db.Locations) && db.Locations[index].IPFrom <= ipNum {
-		loc := db.Locations[index]
-		log.Printf("[%s] IP '%s' found in range %d - %d", funcName, ipStr, loc.IPFrom, loc.IPTo)
-		return &models.Location{
-			Country: loc.Country,
-			Region:  loc.Region,
-			City:    loc.City,
-		}, nil
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
 	}
-
-	log.Printf("[%s] IP '%s' not found in any range", funcName, ipStr)
-	return nil, fmt.Errorf("%w: %s", utils.ErrDatabaseQuery, ipStr)
+	return locations, nil
 }
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"ip2country-service/internal/models"
+)
+
+// LegacyIPDatabase is the IPDatabase interface as it was before lookups took
+// a context. Custom implementations written against it keep compiling and
+// can be plugged in with FromLegacy.
+type LegacyIPDatabase interface {
+	Find(ip string) (*models.Location, error)
+}
+
+// legacyDatabase adapts a LegacyIPDatabase to IPDatabase
+type legacyDatabase struct {
+	db LegacyIPDatabase
+}
+
+// FromLegacy wraps db so it satisfies IPDatabase. The wrapped Find cannot be
+// interrupted, so it runs in its own goroutine and the caller gets a timeout
+// error as soon as ctx is done; the abandoned call finishes in the background.
+func FromLegacy(db LegacyIPDatabase) IPDatabase {
+	return &legacyDatabase{db: db}
+}
+
+type legacyResult struct {
+	loc *models.Location
+	err error
+}
+
+func (l *legacyDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	const funcName = "legacyDatabase.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, timeoutError(funcName, ip, err)
+	}
+
+	done := make(chan legacyResult, 1)
+	go func() {
+		loc, err := l.db.Find(ip)
+		done <- legacyResult{loc: loc, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.loc, result.err
+	case <-ctx.Done():
+		return nil, timeoutError(funcName, ip, ctx.Err())
+	}
+}
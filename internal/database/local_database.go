@@ -0,0 +1,260 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"iter"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DatabaseLocal is the in-memory engine behind the file formats: it holds a
+// dataset's ranges sorted by IPFrom and binary-searches them. Each format
+// only parses its files into ranges, see RegisterFormat.
+type DatabaseLocal struct {
+	Locations []IPLocation
+	// Validation decides whether overlapping, duplicate or unknown-country
+	// ranges reject the dataset; the zero value is ValidationLenient
+	Validation ValidationMode
+	meta       LocalMetadata
+	inverted   int // inverted ranges dropped in lenient mode
+}
+
+// LocalMetadata describes the file a DatabaseLocal was loaded from
+type LocalMetadata struct {
+	Format   string // name the format was registered under
+	Path     string
+	Size     int64     // bytes on disk, before any decompression
+	LoadedAt time.Time // when the file finished loading
+	Duration time.Duration
+}
+
+// LocalStats summarises the ranges of a DatabaseLocal
+type LocalStats struct {
+	Ranges     int
+	IPv4Ranges int
+	IPv6Ranges int
+	Countries  int // distinct country codes
+	// Percentage of each address space covered by the ranges
+	IPv4Coverage float64
+	IPv6Coverage float64
+}
+
+// NewLocalDatabase loads the file at path with the loader registered for
+// format. The file may be gzip-compressed and may start with a UTF-8 byte
+// order mark, whatever its format.
+func NewLocalDatabase(format, path string, opts LoadOptions) (*DatabaseLocal, error) {
+	read, err := formatReader(format, opts)
+	if err != nil {
+		return nil, err
+	}
+	return loadLocal(format, path, opts.Validation, read)
+}
+
+// readLocalFile is NewLocalDatabase for a file opened by a reload
+func readLocalFile(format string, file *DatasetFile, opts LoadOptions) (*DatabaseLocal, error) {
+	read, err := formatReader(format, opts)
+	if err != nil {
+		return nil, err
+	}
+	return readLocal(format, file.Path, file.Size(), file, time.Now(), opts.Validation, read)
+}
+
+// formatReader returns the loader registered for format, bound to opts
+func formatReader(format string, opts LoadOptions) (func(io.Reader) ([]IPLocation, error), error) {
+	load, ok := lookupFormat(format)
+	if !ok {
+		return nil, fmt.Errorf("unsupported dataset format %q, expected one of %v", format, Formats())
+	}
+	return func(r io.Reader) ([]IPLocation, error) {
+		return load(r, opts)
+	}, nil
+}
+
+// LocalLoader returns a FileLoader serving files in format from a
+// DatabaseLocal, for NewReloadableDatabase
+func LocalLoader(format string, opts LoadOptions) FileLoader {
+	return localLoader(format, opts, false)
+}
+
+// loadLocal opens path, hands its contents to read and indexes the ranges read
+func loadLocal(format, path string, mode ValidationMode, read func(io.Reader) ([]IPLocation, error)) (*DatabaseLocal, error) {
+	start := time.Now()
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening %s file: %v", format, err)
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	return readLocal(format, path, info.Size(), file, start, mode, read)
+}
+
+// readLocal hands the contents of the file at path, read from file, to
+// read and indexes the ranges read. Loading is timed from start.
+func readLocal(format, path string, size int64, file io.Reader, start time.Time, mode ValidationMode, read func(io.Reader) ([]IPLocation, error)) (*DatabaseLocal, error) {
+	input, err := decompressedReader(file)
+	if err != nil {
+		log.Printf("Error reading %s file: %v", format, err)
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+
+	locations, err := read(input)
+	if err != nil {
+		log.Printf("Error reading %s file: %v", format, err)
+		return nil, err
+	}
+
+	// Sort the locations by IPFrom for efficient searching, keeping the
+	// file's order among identical ranges
+	slices.SortStableFunc(locations, compareLocations)
+
+	meta := LocalMetadata{Format: format, Path: path, Size: size, LoadedAt: time.Now(), Duration: time.Since(start)}
+	log.Printf("Loaded %s %s: %d ranges in %v", format, path, len(locations), meta.Duration)
+	return &DatabaseLocal{Locations: locations, Validation: mode, meta: meta}, nil
+}
+
+func (d *DatabaseLocal) Find(ctx context.Context, ipStr string) (*models.Location, error) {
+	loc, _, err := d.FindRange(ctx, ipStr)
+	return loc, err
+}
+
+// FindRange looks ipStr up and also returns the dataset range it matched
+func (d *DatabaseLocal) FindRange(ctx context.Context, ipStr string) (*models.Location, Range, error) {
+	const funcName = "DatabaseLocal.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, Range{}, timeoutError(funcName, ipStr, err)
+	}
+
+	ipNum, err := ipStringToNumber(ipStr)
+	if err != nil {
+		log.Printf("[%s] Error converting IP '%s' to a number: %v", funcName, ipStr, err)
+		return nil, Range{}, invalidInputError(funcName, ipStr)
+	}
+
+	// Binary search to find the IP range
+	index := sort.Search(len(d.Locations), func(i int) bool {
+		return d.Locations[i].IPTo.Compare(ipNum) >= 0
+	})
+
+	if index < len(d.Locations) && d.Locations[index].IPFrom.Compare(ipNum) <= 0 {
+		loc := d.Locations[index]
+		log.Printf("[%s] IP '%s' found in range %s - %s", funcName, ipStr, loc.IPFrom, loc.IPTo)
+		return loc.toLocation(), loc.rangeOf(), nil
+	}
+
+	log.Printf("[%s] IP '%s' not found in any range", funcName, ipStr)
+	return nil, Range{}, notFoundError(funcName, ipStr)
+}
+
+// Validate checks that the dataset holds at least one range and that
+// coordinates lie on the globe, then looks for the problems ValidateLocations
+// reports and handles them as d.Validation says. Inverted ranges cannot be
+// searched: strict mode refuses them and lenient mode drops them, so
+// Validate must run before the dataset serves lookups.
+func (d *DatabaseLocal) Validate() error {
+	if d.Validation != ValidationStrict {
+		d.dropInverted()
+	}
+	if len(d.Locations) == 0 {
+		return fmt.Errorf("%w: no IP ranges loaded", utils.ErrInvalidDataset)
+	}
+	for _, loc := range d.Locations {
+		if loc.IPTo.Less(loc.IPFrom) {
+			return fmt.Errorf("%w: inverted range %s - %s", utils.ErrInvalidDataset, loc.IPFrom, loc.IPTo)
+		}
+		if err := loc.validateCoordinates(); err != nil {
+			return fmt.Errorf("%w: range %s - %s: %v", utils.ErrInvalidDataset, loc.IPFrom, loc.IPTo, err)
+		}
+	}
+	return checkRanges(d.Locations, d.inverted, d.Validation)
+}
+
+// dropInverted removes the inverted ranges, counting them for checkRanges.
+// Locations is only copied if there are any, as it may be shared.
+func (d *DatabaseLocal) dropInverted() {
+	kept := d.Locations
+	for i := range d.Locations {
+		loc := &d.Locations[i]
+		if !loc.IPTo.Less(loc.IPFrom) {
+			if len(kept) < len(d.Locations) {
+				kept = append(kept, *loc)
+			}
+			continue
+		}
+		if len(kept) == len(d.Locations) {
+			kept = append(make([]IPLocation, 0, len(d.Locations)-1), d.Locations[:i]...)
+		}
+		log.Printf("Dropping inverted range %s - %s (%s)", loc.IPFrom.IP(), loc.IPTo.IP(), loc.Country)
+		d.inverted++
+	}
+	d.Locations = kept
+}
+
+// CheckHealth reports an error if the dataset holds no ranges to answer lookups from
+func (d *DatabaseLocal) CheckHealth(_ context.Context) error {
+	if len(d.Locations) == 0 {
+		return fmt.Errorf("%w: no IP ranges loaded", utils.ErrInvalidDataset)
+	}
+	return nil
+}
+
+// Metadata describes the file the ranges were loaded from. It is empty for
+// a DatabaseLocal built in memory.
+func (d *DatabaseLocal) Metadata() LocalMetadata {
+	return d.meta
+}
+
+// Dataset describes the ranges and the file they were loaded from
+func (d *DatabaseLocal) Dataset(_ context.Context) DatasetMetadata {
+	meta := DatasetMetadata{Type: d.meta.Format, Path: d.meta.Path, Records: len(d.Locations)}
+	if !d.meta.LoadedAt.IsZero() {
+		loadedAt := d.meta.LoadedAt
+		meta.LoadedAt = &loadedAt
+	}
+	return meta
+}
+
+// Stats counts the ranges by family and country and measures their coverage
+func (d *DatabaseLocal) Stats() LocalStats {
+	stats := LocalStats{Ranges: len(d.Locations)}
+	countries := make(map[string]struct{})
+	var coverage coverageCounter
+	for i := range d.Locations {
+		loc := &d.Locations[i]
+		if loc.IPFrom.IsIPv4() {
+			stats.IPv4Ranges++
+		} else {
+			stats.IPv6Ranges++
+		}
+		countries[loc.Country] = struct{}{}
+		if !loc.IPTo.Less(loc.IPFrom) {
+			coverage.add(loc.rangeOf())
+		}
+	}
+	stats.Countries = len(countries)
+	stats.IPv4Coverage, stats.IPv6Coverage = coverage.percentages()
+	return stats
+}
+
+// All iterates over the ranges in order of IPFrom. The ranges are shared
+// with the database and must not be modified.
+func (d *DatabaseLocal) All() iter.Seq[*IPLocation] {
+	return func(yield func(*IPLocation) bool) {
+		for i := range d.Locations {
+			if !yield(&d.Locations[i]) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package database
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of f read-only and shared, so every
+// process mapping the same file shares its pages. The mapping reads the
+// file as it is on disk: truncating or rewriting it in place while mapped
+// changes the data under lookups or raises SIGBUS, so mapped datasets must
+// be replaced by renaming a new file over them.
+func mapFile(f *os.File, size int) ([]byte, func([]byte) error, error) {
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, syscall.Munmap, nil
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"log"
+	"math"
+	"net"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbLanguage is the locale used when picking names out of MaxMind records
+const mmdbLanguage = "en"
+
+// MMDBDatabase answers lookups directly from a MaxMind DB file (GeoLite2 or GeoIP2 format)
+type MMDBDatabase struct {
+	path   string
+	reader *maxminddb.Reader
+	unmap  func() error // releases the mapping the reader was built on, if we made it
+}
+
+// mmdbRecord is the subset of a GeoIP2 City/Country record mapped onto models.Location
+type mmdbRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Subdivisions []struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude       *float64 `maxminddb:"latitude"`
+		Longitude      *float64 `maxminddb:"longitude"`
+		AccuracyRadius uint16   `maxminddb:"accuracy_radius"`
+		TimeZone       string   `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	// Set in GeoLite2/GeoIP2 ASN databases, or under traits in Enterprise ones
+	ASN          uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+	Traits       struct {
+		ASN          uint32 `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+	} `maxminddb:"traits"`
+}
+
+func NewMMDBDatabase(filePath string) (*MMDBDatabase, error) {
+	reader, err := maxminddb.Open(filePath)
+	if err != nil {
+		log.Printf("Error opening MMDB file: %v", err)
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+
+	log.Printf("Loaded MMDB %s (build epoch %d, IPv%d)", reader.Metadata.DatabaseType, reader.Metadata.BuildEpoch, reader.Metadata.IPVersion)
+	return &MMDBDatabase{path: filePath, reader: reader}, nil
+}
+
+// newMMDBDatabase serves the MMDB file mapped at data, unmapping it if it
+// is not a valid MMDB file
+func newMMDBDatabase(path string, data []byte, unmap func([]byte) error) (*MMDBDatabase, error) {
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		unmap(data)
+		log.Printf("Error opening MMDB file: %v", err)
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+
+	log.Printf("Loaded MMDB %s (build epoch %d, IPv%d)", reader.Metadata.DatabaseType, reader.Metadata.BuildEpoch, reader.Metadata.IPVersion)
+	return &MMDBDatabase{path: path, reader: reader, unmap: func() error { return unmap(data) }}, nil
+}
+
+func (db *MMDBDatabase) Find(ctx context.Context, ipStr string) (*models.Location, error) {
+	loc, _, err := db.FindRange(ctx, ipStr)
+	return loc, err
+}
+
+// FindRange looks ipStr up and also returns the range of the MMDB network it matched
+func (db *MMDBDatabase) FindRange(ctx context.Context, ipStr string) (*models.Location, Range, error) {
+	const funcName = "MMDBDatabase.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, Range{}, timeoutError(funcName, ipStr, err)
+	}
+
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		log.Printf("[%s] Invalid IP '%s'", funcName, ipStr)
+		return nil, Range{}, invalidInputError(funcName, ipStr)
+	}
+
+	var record mmdbRecord
+	network, ok, err := db.reader.LookupNetwork(ip, &record)
+	if err != nil {
+		log.Printf("[%s] Error looking up IP '%s': %v", funcName, ipStr, err)
+		return nil, Range{}, backendError(funcName, ipStr, err)
+	}
+
+	// Records of ASN databases carry no country, so only a record with
+	// neither a country nor an autonomous system counts as not found
+	loc := record.toLocation()
+	if !ok || (loc.Country == "" && loc.ASN == 0 && loc.Organization == "") {
+		log.Printf("[%s] IP '%s' not found in MMDB", funcName, ipStr)
+		return nil, Range{}, notFoundError(funcName, ipStr)
+	}
+
+	matched, err := db.answeredRange(ip, network)
+	if err != nil {
+		log.Printf("[%s] Error converting network %s of IP '%s': %v", funcName, network, ipStr, err)
+		return nil, Range{}, backendError(funcName, ipStr, err)
+	}
+
+	log.Printf("[%s] IP '%s' found in network %s", funcName, ipStr, network)
+	return loc, matched, nil
+}
+
+// answeredRange converts the network an MMDB lookup of ip matched. The
+// reader answers IPv4 lookups with ::/n when a record spans the whole IPv4
+// subtree, and of that network the IPv4 space is what the answer applies to.
+func (db *MMDBDatabase) answeredRange(ip net.IP, network *net.IPNet) (Range, error) {
+	if ones, _ := network.Mask.Size(); ip.To4() != nil && len(network.IP) == net.IPv6len && ones <= 96 {
+		return Range{From: IPv4Number(0), To: IPv4Number(math.MaxUint32)}, nil
+	}
+	return networkRange(network)
+}
+
+// CheckHealth reports an error if the MMDB file holds no search tree
+func (db *MMDBDatabase) CheckHealth(_ context.Context) error {
+	if db.reader.Metadata.NodeCount == 0 {
+		return fmt.Errorf("%w: MMDB search tree is empty", utils.ErrInvalidDataset)
+	}
+	return nil
+}
+
+// Dataset describes the MMDB file from its own metadata. The number of
+// networks is not recorded there, so Records is left empty.
+func (db *MMDBDatabase) Dataset(_ context.Context) DatasetMetadata {
+	metadata := db.reader.Metadata
+	meta := DatasetMetadata{Type: "mmdb", Path: db.path, Vendor: metadata.DatabaseType}
+	if metadata.BuildEpoch != 0 {
+		builtAt := time.Unix(int64(metadata.BuildEpoch), 0).UTC()
+		meta.BuildDate = &builtAt
+	}
+	return meta
+}
+
+// Close releases the memory-mapped MMDB file
+func (db *MMDBDatabase) Close() error {
+	err := db.reader.Close()
+	if db.unmap != nil {
+		if unmapErr := db.unmap(); err == nil {
+			err = unmapErr
+		}
+	}
+	return err
+}
+
+func (r *mmdbRecord) toLocation() *models.Location {
+	loc := &models.Location{
+		Country:        r.Country.IsoCode,
+		City:           r.City.Names[mmdbLanguage],
+		Latitude:       r.Location.Latitude,
+		Longitude:      r.Location.Longitude,
+		AccuracyRadius: r.Location.AccuracyRadius,
+		PostalCode:     r.Postal.Code,
+		TimeZone:       r.Location.TimeZone,
+		ASN:            r.ASN,
+		Organization:   r.Organization,
+	}
+	if loc.ASN == 0 {
+		loc.ASN, loc.Organization = r.Traits.ASN, r.Traits.Organization
+	}
+	if loc.Country == "" {
+		loc.Country = r.RegisteredCountry.IsoCode
+	}
+	if len(r.Subdivisions) > 0 {
+		loc.Region = r.Subdivisions[0].Names[mmdbLanguage]
+		if loc.Region == "" {
+			loc.Region = r.Subdivisions[0].IsoCode
+		}
+	}
+	return loc
+}
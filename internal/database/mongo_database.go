@@ -2,12 +2,10 @@ package database
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"ip2country-service/internal/models"
 	"ip2country-service/pkg/utils"
 	"log"
-	"net"
 	"time"
 
 	"ip2country-service/monitoring"
@@ -17,7 +15,11 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// mongoDisconnectTimeout bounds how long Close waits for in-use connections to be returned
+const mongoDisconnectTimeout = 10 * time.Second
+
 type MongoDatabase struct {
+	client     *mongo.Client
 	collection *mongo.Collection
 }
 
@@ -39,29 +41,81 @@ func NewMongoDatabase(uri, dbName string) (*MongoDatabase, error) {
 	log.Println("Successfully connected to MongoDB")
 
 	collection := client.Database(dbName).Collection("ip_locations")
-	return &MongoDatabase{collection: collection}, nil
+	db := &MongoDatabase{client: client, collection: collection}
+	if err := db.EnsureIndexes(context.TODO()); err != nil {
+		// Lookups work without it; listing ranges by location scans the collection
+		log.Printf("Error creating MongoDB indexes: %v", err)
+	}
+	return db, nil
 }
 
-func ipToUint32Mongo(ipStr string) (uint32, error) {
-	ip := net.ParseIP(ipStr)
-	if ip == nil {
-		return 0, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
+// locationCollation compares location names case-insensitively. Queries
+// only use an index built with the same collation.
+var locationCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// EnsureIndexes creates the index ListRanges relies on, if it is missing
+func (db *MongoDatabase) EnsureIndexes(ctx context.Context) error {
+	_, err := db.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "country", Value: 1}, {Key: "region", Value: 1}, {Key: "city", Value: 1}, {Key: "ip_from", Value: 1}},
+		Options: options.Index().SetName("location_ranges").SetCollation(locationCollation),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrMongoDB, err)
+	}
+	return nil
+}
+
+// CheckHealth pings MongoDB and checks that the collection holds any ranges
+func (db *MongoDatabase) CheckHealth(ctx context.Context) error {
+	if err := db.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrMongoDB, err)
+	}
+	count, err := db.collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrMongoDB, err)
 	}
-	ip = ip.To4()
-	if ip == nil {
-		return 0, fmt.Errorf("%w: %s", utils.ErrInvalidIP, ipStr)
+	if count == 0 {
+		return fmt.Errorf("%w: collection %s is empty", utils.ErrInvalidDataset, db.collection.Name())
 	}
-	return binary.BigEndian.Uint32(ip), nil
+	return nil
 }
 
-func (db *MongoDatabase) Find(ipStr string) (*models.Location, error) {
+// Dataset describes the collection answering lookups. The record count is
+// MongoDB's estimate, and is left empty if it cannot be had.
+func (db *MongoDatabase) Dataset(ctx context.Context) DatasetMetadata {
+	meta := DatasetMetadata{Type: "mongodb", Path: db.collection.Database().Name() + "." + db.collection.Name()}
+	if count, err := db.collection.EstimatedDocumentCount(ctx); err == nil {
+		meta.Records = int(count)
+	} else {
+		log.Printf("Error counting MongoDB documents: %v", err)
+	}
+	return meta
+}
+
+// Close disconnects from MongoDB, waiting for in-flight operations to finish
+func (db *MongoDatabase) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mongoDisconnectTimeout)
+	defer cancel()
+	return db.client.Disconnect(ctx)
+}
+
+func (db *MongoDatabase) Find(ctx context.Context, ipStr string) (*models.Location, error) {
+	loc, _, err := db.FindRange(ctx, ipStr)
+	return loc, err
+}
+
+// FindRange looks ipStr up and also returns the range of the document it matched
+func (db *MongoDatabase) FindRange(ctx context.Context, ipStr string) (*models.Location, Range, error) {
 	const funcName = "MongoDatabase.Find"
-	ipNum, err := ipToUint32Mongo(ipStr)
+	ipNum, err := ipStringToNumber(ipStr)
 	if err != nil {
-		log.Printf("[%s] Error converting IP '%s' to uint32: %v", funcName, ipStr, err)
-		return nil, err
+		log.Printf("[%s] Error converting IP '%s' to a number: %v", funcName, ipStr, err)
+		return nil, Range{}, invalidInputError(funcName, ipStr)
 	}
 
+	// IPv4 ranges are stored as integers and IPv6 ranges as 16-byte binary;
+	// MongoDB only compares values of the same type, so the filter selects
+	// the matching representation automatically.
 	filter := bson.M{
 		"ip_from": bson.M{"$lte": ipNum},
 		"ip_to":   bson.M{"$gte": ipNum},
@@ -72,22 +126,73 @@ func (db *MongoDatabase) Find(ipStr string) (*models.Location, error) {
 
 	dbQueryStart := time.Now()
 	var location IPLocation
-	err = db.collection.FindOne(context.TODO(), filter).Decode(&location)
+	err = db.collection.FindOne(ctx, filter).Decode(&location)
 	monitoring.DatabaseQueryDuration.WithLabelValues().Observe(time.Since(dbQueryStart).Seconds())
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			log.Printf("[%s] IP '%s' not found in MongoDB", funcName, ipStr)
-			return nil, utils.ErrDatabaseQuery
+			return nil, Range{}, notFoundError(funcName, ipStr)
 		}
 		log.Printf("[%s] Error finding IP '%s': %v", funcName, ipStr, err)
-		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+		if mongo.IsTimeout(err) {
+			return nil, Range{}, timeoutError(funcName, ipStr, err)
+		}
+		return nil, Range{}, backendError(funcName, ipStr, err)
 	}
 
 	log.Printf("[%s] IP '%s' found in MongoDB", funcName, ipStr)
-	return &models.Location{
-		Country: location.Country,
-		Region:  location.Region,
-		City:    location.City,
-	}, nil
+	return location.toLocation(), location.rangeOf(), nil
+}
+
+// ListRanges finds the matching documents through the location index. IPv4
+// ranges sort before IPv6 ranges, as MongoDB orders numbers before binary data.
+func (db *MongoDatabase) ListRanges(ctx context.Context, q RangeQuery) (RangePage, error) {
+	const funcName = "MongoDatabase.ListRanges"
+	filter := bson.D{{Key: "country", Value: q.Country}}
+	if q.Region != "" {
+		filter = append(filter, bson.E{Key: "region", Value: q.Region})
+	}
+	if q.City != "" {
+		filter = append(filter, bson.E{Key: "city", Value: q.City})
+	}
+
+	dbQueryStart := time.Now()
+	defer func() {
+		monitoring.DatabaseQueryDuration.WithLabelValues().Observe(time.Since(dbQueryStart).Seconds())
+	}()
+
+	total, err := db.collection.CountDocuments(ctx, filter, options.Count().SetCollation(locationCollation))
+	if err != nil {
+		return RangePage{}, db.listError(funcName, err)
+	}
+	findOpts := options.Find().
+		SetCollation(locationCollation).
+		SetSort(bson.D{{Key: "ip_from", Value: 1}}).
+		SetSkip(int64(max(q.Offset, 0)))
+	if q.Limit > 0 {
+		findOpts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := db.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return RangePage{}, db.listError(funcName, err)
+	}
+	var locations []IPLocation
+	if err := cursor.All(ctx, &locations); err != nil {
+		return RangePage{}, db.listError(funcName, err)
+	}
+
+	page := RangePage{Total: int(total), Ranges: make([]LocatedRange, 0, len(locations))}
+	for i := range locations {
+		page.Ranges = append(page.Ranges, LocatedRange{Range: locations[i].rangeOf(), Location: locations[i].toLocation()})
+	}
+	return page, nil
+}
+
+func (db *MongoDatabase) listError(funcName string, err error) error {
+	log.Printf("[%s] Error listing ranges: %v", funcName, err)
+	if mongo.IsTimeout(err) {
+		return timeoutError(funcName, "", err)
+	}
+	return backendError(funcName, "", err)
 }
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"log"
+	"math/bits"
+	"strings"
+	"time"
+)
+
+// unversioned stands in for the version of databases that cannot identify their dataset
+const unversioned = "0"
+
+// OverrideDatabase corrects a base dataset with a small file of overrides,
+// such as an organisation's own office ranges or a misattributed cloud block,
+// without regenerating the base dataset. An address covered by an override
+// is answered from the most specific override containing it, marked with
+// models.SourceOverride; any other address is answered by the base database.
+// The overrides file is reloaded like a dataset file.
+type OverrideDatabase struct {
+	base      IPDatabase
+	overrides *ReloadableDatabase // serves *overrideSet snapshots
+}
+
+// NewOverrideDatabase loads the overrides at path, a CSV or JSON file in
+// the default dataset formats, in front of base. The file may hold no ranges.
+func NewOverrideDatabase(base IPDatabase, path string) (*OverrideDatabase, error) {
+	overrides, err := NewReloadableDatabase(path, loadOverrides)
+	if err != nil {
+		return nil, err
+	}
+	return &OverrideDatabase{base: base, overrides: overrides}, nil
+}
+
+func (db *OverrideDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	loc, _, err := db.FindRange(ctx, ip)
+	return loc, err
+}
+
+// FindRange answers from the overrides, then from the base database. The
+// range returned is narrowed to the addresses that get the same answer, so
+// that it never spans an override that does not apply to ip.
+func (db *OverrideDatabase) FindRange(ctx context.Context, ip string) (*models.Location, Range, error) {
+	const funcName = "OverrideDatabase.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, Range{}, timeoutError(funcName, ip, err)
+	}
+
+	ipNum, err := ipStringToNumber(ip)
+	if err != nil {
+		// Let the base database classify the bad input
+		return FindRange(ctx, db.base, ip)
+	}
+
+	set := db.current()
+	if loc, matched, ok := set.lookup(ipNum); ok {
+		log.Printf("[%s] IP '%s' overridden by range %s - %s", funcName, ip, matched.From, matched.To)
+		return loc, matched, nil
+	}
+
+	loc, matched, err := FindRange(ctx, db.base, ip)
+	if err != nil {
+		return nil, Range{}, err
+	}
+	return loc, set.narrow(matched, ipNum), nil
+}
+
+// Reload re-reads the overrides file and the base dataset, if it can be reloaded
+func (db *OverrideDatabase) Reload() error {
+	var errs []error
+	if reloader, ok := As[Reloader](db.base); ok {
+		errs = append(errs, reloader.Reload())
+	}
+	if err := db.overrides.Reload(); err != nil {
+		errs = append(errs, fmt.Errorf("overrides: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// OnReload registers fn to be called after the overrides or the base dataset are swapped
+func (db *OverrideDatabase) OnReload(fn func()) {
+	if reloader, ok := As[Reloader](db.base); ok {
+		reloader.OnReload(fn)
+	}
+	db.overrides.OnReload(fn)
+}
+
+// Watch reloads the overrides file when it changes, as ReloadableDatabase.Watch does
+func (db *OverrideDatabase) Watch(ctx context.Context, interval time.Duration) {
+	db.overrides.Watch(ctx, interval)
+}
+
+// Version combines the versions of the overrides and of the base dataset,
+// so answers derived from either change with it
+func (db *OverrideDatabase) Version() string {
+	base := unversioned
+	if v, ok := As[Versioned](db.base); ok && v.Version() != "" {
+		base = v.Version()
+	}
+	return base + "+" + db.overrides.Version()
+}
+
+// Dataset describes the base dataset, with the version of the answers and
+// the overrides file in front of it
+func (db *OverrideDatabase) Dataset(ctx context.Context) DatasetMetadata {
+	meta, _ := Describe(ctx, db.base)
+	meta.Version = db.Version()
+	overrides := db.overrides.Dataset(ctx)
+	overrides.Type = formatOf(overrides.Path)
+	meta.Overrides = &overrides
+	return meta
+}
+
+// Unwrap returns the base database
+func (db *OverrideDatabase) Unwrap() IPDatabase {
+	return db.base
+}
+
+// CheckHealth checks the base database; the overrides are always in memory
+func (db *OverrideDatabase) CheckHealth(ctx context.Context) error {
+	if checker, ok := db.base.(interface{ CheckHealth(context.Context) error }); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Close releases the base database
+func (db *OverrideDatabase) Close() error {
+	if closer, ok := db.base.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (db *OverrideDatabase) current() *overrideSet {
+	return db.overrides.current.Load().db.(*overrideSet)
+}
+
+// overrideSet holds the ranges of an overrides file, which may nest. There
+// are expected to be a handful, so lookups scan them all.
+type overrideSet struct {
+	locations []IPLocation
+}
+
+// loadOverrides reads an overrides file in the format its name says, or as JSON
+func loadOverrides(file *DatasetFile) (IPDatabase, error) {
+	db, err := readLocalFile(formatOf(file.Path), file, LoadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &overrideSet{locations: db.Locations}, nil
+}
+
+// formatOf guesses the format of a file from its name, which may carry a
+// further extension such as .gz
+func formatOf(path string) string {
+	name := strings.ToLower(path)
+	switch {
+	case strings.Contains(name, ".csv"):
+		return "csv"
+	case strings.Contains(name, ".ndjson"), strings.Contains(name, ".jsonl"):
+		return "ndjson"
+	default:
+		return "json"
+	}
+}
+
+func (s *overrideSet) Find(ctx context.Context, ip string) (*models.Location, error) {
+	loc, _, err := s.FindRange(ctx, ip)
+	return loc, err
+}
+
+// FindRange answers from the overrides alone
+func (s *overrideSet) FindRange(ctx context.Context, ip string) (*models.Location, Range, error) {
+	const funcName = "overrideSet.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, Range{}, timeoutError(funcName, ip, err)
+	}
+	ipNum, err := ipStringToNumber(ip)
+	if err != nil {
+		return nil, Range{}, invalidInputError(funcName, ip)
+	}
+	if loc, matched, ok := s.lookup(ipNum); ok {
+		return loc, matched, nil
+	}
+	return nil, Range{}, notFoundError(funcName, ip)
+}
+
+// Dataset counts the overrides
+func (s *overrideSet) Dataset(_ context.Context) DatasetMetadata {
+	return DatasetMetadata{Records: len(s.locations)}
+}
+
+// Validate checks that no override is inverted and that coordinates lie on
+// the globe. Overrides may overlap, and the file may be empty.
+func (s *overrideSet) Validate() error {
+	for _, loc := range s.locations {
+		if loc.IPTo.Less(loc.IPFrom) {
+			return fmt.Errorf("%w: inverted override %s - %s", utils.ErrInvalidDataset, loc.IPFrom, loc.IPTo)
+		}
+		if err := loc.validateCoordinates(); err != nil {
+			return fmt.Errorf("%w: override %s - %s: %v", utils.ErrInvalidDataset, loc.IPFrom, loc.IPTo, err)
+		}
+	}
+	return nil
+}
+
+// lookup returns the most specific override containing n, with its range
+// narrowed to the addresses it applies to. Of equally specific overrides,
+// the one starting last wins, and of identical ones the last in the file,
+// as loading sorts them stably.
+func (s *overrideSet) lookup(n IPNumber) (*models.Location, Range, bool) {
+	var best *IPLocation
+	for i := range s.locations {
+		loc := &s.locations[i]
+		if loc.rangeOf().Contains(n) && (best == nil || !wider(loc.rangeOf(), best.rangeOf())) {
+			best = loc
+		}
+	}
+	if best == nil {
+		return nil, Range{}, false
+	}
+
+	loc := best.toLocation()
+	loc.Source = models.SourceOverride
+	return loc, s.narrow(best.rangeOf(), n), true
+}
+
+// narrow shrinks r, which contains n, to the addresses around n that no
+// override excluding n covers, so the answer for n applies to all of them
+func (s *overrideSet) narrow(r Range, n IPNumber) Range {
+	for _, loc := range s.locations {
+		other := loc.rangeOf()
+		if other.Contains(n) || !other.Overlaps(r) {
+			continue
+		}
+		if other.To.Less(n) {
+			r.From = other.To.Next()
+		} else {
+			r.To = other.From.Prev()
+		}
+	}
+	return r
+}
+
+// wider reports whether a spans more addresses than b
+func wider(a, b Range) bool {
+	aLo, borrow := bits.Sub64(a.To.Lo, a.From.Lo, 0)
+	aHi, _ := bits.Sub64(a.To.Hi, a.From.Hi, borrow)
+	bLo, borrow := bits.Sub64(b.To.Lo, b.From.Lo, 0)
+	bHi, _ := bits.Sub64(b.To.Hi, b.From.Hi, borrow)
+	return IPNumber{Hi: bHi, Lo: bLo}.Less(IPNumber{Hi: aHi, Lo: aLo})
+}
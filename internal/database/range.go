@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/ipnum"
+	"net"
+	"net/netip"
+)
+
+// Range is an inclusive span of addresses that share one location
+type Range struct {
+	From IPNumber `json:"from"`
+	To   IPNumber `json:"to"`
+}
+
+// Contains reports whether n lies within the range
+func (r Range) Contains(n IPNumber) bool {
+	return r.From.Compare(n) <= 0 && n.Compare(r.To) <= 0
+}
+
+// Overlaps reports whether the two ranges share any address
+func (r Range) Overlaps(other Range) bool {
+	return r.From.Compare(other.To) <= 0 && other.From.Compare(r.To) <= 0
+}
+
+// CIDRs returns the networks covering the range exactly, in order.
+// Networks within the IPv4 space are written in IPv4 notation.
+func (r Range) CIDRs() []string {
+	var networks []string
+	forEachPrefix(r, func(prefix IPNumber, length int) {
+		if prefix.IsIPv4() && length >= addressBits-32 {
+			networks = append(networks, fmt.Sprintf("%s/%d", prefix.IP(), length-(addressBits-32)))
+			return
+		}
+		var ip [net.IPv6len]byte
+		binary.BigEndian.PutUint64(ip[:8], prefix.Hi)
+		binary.BigEndian.PutUint64(ip[8:], prefix.Lo)
+		networks = append(networks, netip.PrefixFrom(netip.AddrFrom16(ip), length).String())
+	})
+	return networks
+}
+
+// RangeFinder is implemented by backends that can report the range a
+// location was matched from, so callers such as the lookup cache can reuse
+// one answer for every address in it
+type RangeFinder interface {
+	FindRange(ctx context.Context, ip string) (*models.Location, Range, error)
+}
+
+// FindRange looks ip up in db together with the range the answer applies
+// to. Backends that cannot report ranges answer for the single address.
+func FindRange(ctx context.Context, db IPDatabase, ip string) (*models.Location, Range, error) {
+	if finder, ok := db.(RangeFinder); ok {
+		return finder.FindRange(ctx, ip)
+	}
+
+	loc, err := db.Find(ctx, ip)
+	if err != nil {
+		return nil, Range{}, err
+	}
+	n, err := ipStringToNumber(ip)
+	if err != nil {
+		return nil, Range{}, invalidInputError("database.FindRange", ip)
+	}
+	return loc, Range{From: n, To: n}, nil
+}
+
+// networkRange converts a CIDR network to the range of addresses it covers
+func networkRange(network *net.IPNet) (Range, error) {
+	from, to, err := ipnum.FromNetwork(network)
+	return Range{From: from, To: to}, err
+}
+
+// ParseAddress parses an address given either as a base-10 integer or in
+// IPv4 or IPv6 text form
+func ParseAddress(s string) (IPNumber, error) {
+	return ipnum.ParseAddress(s)
+}
+
+// ParseRange parses a range written as a CIDR network (10.1.0.0/16), as
+// first and last addresses separated by a hyphen (10.1.0.0-10.1.255.255,
+// either address as text or an integer) or as a single address. Ranges in
+// the IPv4-mapped IPv6 space are folded into IPv4 ranges.
+func ParseRange(s string) (Range, error) {
+	from, to, err := ipnum.ParseRange(s)
+	if err != nil {
+		return Range{}, err
+	}
+	return Range{From: from, To: to}, nil
+}
+
+// parseBounds builds a dataset range from its ip_from and ip_to values. When
+// ip_to is empty, ip_from may hold any form ParseRange accepts. The returned
+// field names which value was invalid.
+func parseBounds(from, to string) (Range, string, error) {
+	first, last, invalid, err := ipnum.ParseBounds(from, to)
+	if err != nil {
+		return Range{}, invalid, err
+	}
+	return Range{From: first, To: last}, "", nil
+}
+
+// rangeOf returns the range covered by a dataset row
+func (l *IPLocation) rangeOf() Range {
+	return Range{From: l.IPFrom, To: l.IPTo}
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"slices"
+	"strings"
+)
+
+// RangeQuery selects the ranges attributed to a country, and optionally to
+// one of its regions or cities. Names are compared case-insensitively.
+type RangeQuery struct {
+	Country string
+	Region  string
+	City    string
+	Offset  int // matching ranges to skip
+	Limit   int // most ranges to return; 0 returns all of them
+}
+
+// LocatedRange is a range with the answer given for its addresses
+type LocatedRange struct {
+	Range
+	Location *models.Location
+}
+
+// RangePage is one page of the ranges matching a RangeQuery, in address order
+type RangePage struct {
+	Ranges []LocatedRange
+	Total  int // ranges matching the query across all pages
+}
+
+// RangeLister is implemented by databases that can list their ranges by
+// location, the reverse of a lookup
+type RangeLister interface {
+	ListRanges(ctx context.Context, q RangeQuery) (RangePage, error)
+}
+
+// ListRanges lists the ranges of db matching q, looking through decorators
+// that cannot list them themselves. The error wraps utils.ErrUnsupportedQuery
+// if no database in the chain can.
+func ListRanges(ctx context.Context, db IPDatabase, q RangeQuery) (RangePage, error) {
+	lister, ok := As[RangeLister](db)
+	if !ok {
+		return RangePage{}, fmt.Errorf("%w: %T cannot list ranges", utils.ErrUnsupportedQuery, db)
+	}
+	return lister.ListRanges(ctx, q)
+}
+
+// matches reports whether loc is selected by the query's filters
+func (q RangeQuery) matches(loc *IPLocation) bool {
+	return strings.EqualFold(loc.Country, q.Country) &&
+		(q.Region == "" || strings.EqualFold(loc.Region, q.Region)) &&
+		(q.City == "" || strings.EqualFold(loc.City, q.City))
+}
+
+// window returns the bounds of the page within total matching ranges
+func (q RangeQuery) window(total int) (int, int) {
+	start := min(max(q.Offset, 0), total)
+	end := total
+	if q.Limit > 0 {
+		end = min(start+q.Limit, total)
+	}
+	return start, end
+}
+
+// ListRanges scans the ranges in order, building answers only for the
+// requested page
+func (d *DatabaseLocal) ListRanges(ctx context.Context, q RangeQuery) (RangePage, error) {
+	if err := ctx.Err(); err != nil {
+		return RangePage{}, timeoutError("DatabaseLocal.ListRanges", "", err)
+	}
+
+	var matched []int
+	for i := range d.Locations {
+		if q.matches(&d.Locations[i]) {
+			matched = append(matched, i)
+		}
+	}
+
+	start, end := q.window(len(matched))
+	page := RangePage{Total: len(matched), Ranges: make([]LocatedRange, 0, end-start)}
+	for _, i := range matched[start:end] {
+		loc := &d.Locations[i]
+		page.Ranges = append(page.Ranges, LocatedRange{Range: loc.rangeOf(), Location: loc.toLocation()})
+	}
+	return page, nil
+}
+
+// ListRanges scans the range records in place, decoding only the requested page
+func (db *SnapshotDatabase) ListRanges(ctx context.Context, q RangeQuery) (RangePage, error) {
+	if err := ctx.Err(); err != nil {
+		return RangePage{}, timeoutError("SnapshotDatabase.ListRanges", "", err)
+	}
+
+	country, region, city := []byte(q.Country), []byte(q.Region), []byte(q.City)
+	field := func(b []byte, at int) []byte {
+		return db.rawStringAt(binary.LittleEndian.Uint32(b[at:]))
+	}
+	var matched []int
+	for i := 0; i < db.count; i++ {
+		b := db.record(i)
+		if bytes.EqualFold(field(b, recCountry), country) &&
+			(len(region) == 0 || bytes.EqualFold(field(b, recRegion), region)) &&
+			(len(city) == 0 || bytes.EqualFold(field(b, recCity), city)) {
+			matched = append(matched, i)
+		}
+	}
+
+	start, end := q.window(len(matched))
+	page := RangePage{Total: len(matched), Ranges: make([]LocatedRange, 0, end-start)}
+	for _, i := range matched[start:end] {
+		page.Ranges = append(page.Ranges, LocatedRange{Range: db.rangeAt(i), Location: db.locationAt(i).toLocation()})
+	}
+	return page, nil
+}
+
+// ListRanges lists the addresses answered for the query's location, as
+// lookups would answer them: a range nested in a wider one is cut out of it
+func (db *TrieDatabase) ListRanges(ctx context.Context, q RangeQuery) (RangePage, error) {
+	if err := ctx.Err(); err != nil {
+		return RangePage{}, timeoutError("TrieDatabase.ListRanges", "", err)
+	}
+
+	// The trie answers every address from one range of the dataset, or
+	// none; adjacent blocks answered from the same one join back together
+	var matched []Range
+	var locations []int32
+	pending, answer := Range{}, int32(-1)
+	flush := func() {
+		if answer >= 0 && q.matches(&db.Locations[answer]) {
+			matched = append(matched, pending)
+			locations = append(locations, answer)
+		}
+	}
+	db.walk(0, -1, func(r Range, location int32) {
+		if location == answer && location >= 0 {
+			pending.To = r.To
+			return
+		}
+		flush()
+		pending, answer = r, location
+	})
+	flush()
+
+	start, end := q.window(len(matched))
+	page := RangePage{Total: len(matched), Ranges: make([]LocatedRange, 0, end-start)}
+	for i := start; i < end; i++ {
+		page.Ranges = append(page.Ranges, LocatedRange{Range: matched[i], Location: db.Locations[locations[i]].toLocation()})
+	}
+	return page, nil
+}
+
+// walk calls fn, in address order, with the blocks of the node at index
+// that no more specific prefix cuts into, and the location answering them:
+// the node's own, or inherited from the nearest ancestor with one
+func (db *TrieDatabase) walk(index int32, inherited int32, fn func(r Range, location int32)) {
+	node := &db.nodes[index]
+	answer := inherited
+	if node.location >= 0 {
+		answer = node.location
+	}
+	block := blockRange(node.prefix, int(node.length))
+	from := block.From
+	for _, child := range node.children {
+		if child == 0 {
+			continue
+		}
+		childBlock := blockRange(db.nodes[child].prefix, int(db.nodes[child].length))
+		if from.Less(childBlock.From) {
+			fn(Range{From: from, To: childBlock.From.Prev()}, answer)
+		}
+		db.walk(child, answer, fn)
+		if !childBlock.To.Less(block.To) {
+			return
+		}
+		from = childBlock.To.Next()
+	}
+	fn(Range{From: from, To: block.To}, answer)
+}
+
+// ListRanges lists the ranges of the dataset currently being served
+func (db *ReloadableDatabase) ListRanges(ctx context.Context, q RangeQuery) (RangePage, error) {
+	current, err := db.acquire()
+	if err != nil {
+		return RangePage{}, err
+	}
+	defer current.handle.release()
+	lister, ok := current.db.(RangeLister)
+	if !ok {
+		return RangePage{}, fmt.Errorf("%w: %T cannot list ranges", utils.ErrUnsupportedQuery, current.db)
+	}
+	return lister.ListRanges(ctx, q)
+}
+
+// ListRanges lists the addresses answered for the query's location, as
+// lookups would answer them: the base dataset's matching ranges without the
+// addresses overrides answer for, and the matching overrides without the
+// addresses more specific overrides answer for
+func (db *OverrideDatabase) ListRanges(ctx context.Context, q RangeQuery) (RangePage, error) {
+	all := q
+	all.Offset, all.Limit = 0, 0
+	base, err := ListRanges(ctx, db.base, all)
+	if err != nil {
+		return RangePage{}, err
+	}
+
+	set := db.current()
+	overrides := make([]Range, len(set.locations))
+	for i := range set.locations {
+		overrides[i] = set.locations[i].rangeOf()
+	}
+
+	var ranges []LocatedRange
+	for _, r := range base.Ranges {
+		for _, piece := range subtractRanges(r.Range, overrides) {
+			ranges = append(ranges, LocatedRange{Range: piece, Location: r.Location})
+		}
+	}
+	for i := range set.locations {
+		loc := &set.locations[i]
+		if !q.matches(loc) {
+			continue
+		}
+		var preferred []Range
+		for j, other := range overrides {
+			if j != i && set.prefers(j, i) {
+				preferred = append(preferred, other)
+			}
+		}
+		location := loc.toLocation()
+		location.Source = models.SourceOverride
+		for _, piece := range subtractRanges(overrides[i], preferred) {
+			ranges = append(ranges, LocatedRange{Range: piece, Location: location})
+		}
+	}
+
+	slices.SortFunc(ranges, func(a, b LocatedRange) int { return a.From.Compare(b.From) })
+	start, end := q.window(len(ranges))
+	return RangePage{Ranges: ranges[start:end], Total: len(ranges)}, nil
+}
+
+// prefers reports whether lookups answer from override i rather than
+// override j where both apply, as lookup decides
+func (s *overrideSet) prefers(i, j int) bool {
+	a, b := s.locations[i].rangeOf(), s.locations[j].rangeOf()
+	if wider(b, a) {
+		return true
+	}
+	return !wider(a, b) && i > j
+}
+
+// subtractRanges returns what is left of r once every range in cuts is
+// taken out of it, in order
+func subtractRanges(r Range, cuts []Range) []Range {
+	var overlapping []Range
+	for _, cut := range cuts {
+		if cut.Overlaps(r) {
+			overlapping = append(overlapping, cut)
+		}
+	}
+	if len(overlapping) == 0 {
+		return []Range{r}
+	}
+	slices.SortFunc(overlapping, func(a, b Range) int { return a.From.Compare(b.From) })
+
+	var pieces []Range
+	from := r.From
+	for _, cut := range overlapping {
+		if cut.To.Less(from) {
+			continue // within what was already cut
+		}
+		if from.Less(cut.From) {
+			pieces = append(pieces, Range{From: from, To: cut.From.Prev()})
+		}
+		if !cut.To.Less(r.To) {
+			return pieces
+		}
+		from = cut.To.Next()
+	}
+	return append(pieces, Range{From: from, To: r.To})
+}
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"ip2country-service/internal/models"
+	"ip2country-service/monitoring"
+	"ip2country-service/pkg/utils"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FileLoader parses an opened dataset file into a ready-to-serve
+// IPDatabase. It reads the file through file, or maps it with file.Map, and
+// does not close it.
+type FileLoader func(file *DatasetFile) (IPDatabase, error)
+
+// Reloader is implemented by databases that can swap in a fresh copy of their dataset
+type Reloader interface {
+	Reload() error
+	OnReload(fn func())
+}
+
+// datasetValidator is implemented by snapshots that can check their own contents
+type datasetValidator interface {
+	Validate() error
+}
+
+// snapshot wraps an IPDatabase so it can be stored in an atomic.Pointer
+type snapshot struct {
+	db       IPDatabase
+	handle   *handle   // shared by the copies made of a snapshot whose file is unchanged
+	modTime  time.Time // modification time of the file it was loaded from
+	checksum string    // SHA-256 of the file's contents
+	version  string    // prefix of checksum
+	loadedAt time.Time
+	sidecar  *sidecar // nil without a sidecar file
+}
+
+// handle counts the users of a loaded database: the ReloadableDatabase
+// serving it and the lookups running against it. The database is closed,
+// releasing resources such as a memory-mapped file, once it has been
+// replaced and the last lookup using it is done.
+type handle struct {
+	db   IPDatabase
+	refs atomic.Int64
+}
+
+func newHandle(db IPDatabase) *handle {
+	h := &handle{db: db}
+	h.refs.Store(1)
+	return h
+}
+
+// acquire takes a reference, unless the database has already been closed
+func (h *handle) acquire() bool {
+	for {
+		refs := h.refs.Load()
+		if refs == 0 {
+			return false
+		}
+		if h.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference, closing the database with the last one
+func (h *handle) release() {
+	if h.refs.Add(-1) == 0 {
+		closeSnapshot(h.db)
+	}
+}
+
+// fileState identifies a version of the dataset file on disk
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// ReloadableDatabase serves lookups from an immutable snapshot of a
+// file-backed dataset and atomically replaces it when the file changes.
+// Lookups that started before a swap finish against the old snapshot, which
+// is closed once the last of them is done.
+type ReloadableDatabase struct {
+	path      string
+	load      FileLoader
+	current   atomic.Pointer[snapshot]
+	mu        sync.Mutex // serializes reloads and guards the fields below
+	loaded    fileState
+	listeners []func()
+	closed    bool
+}
+
+func NewReloadableDatabase(path string, load FileLoader) (*ReloadableDatabase, error) {
+	db := &ReloadableDatabase{path: path, load: load}
+	if err := db.Reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *ReloadableDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	current, err := db.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer current.handle.release()
+	return current.db.Find(ctx, ip)
+}
+
+// FindRange looks ip up in the current snapshot, reporting the matched range if the snapshot can
+func (db *ReloadableDatabase) FindRange(ctx context.Context, ip string) (*models.Location, Range, error) {
+	current, err := db.acquire()
+	if err != nil {
+		return nil, Range{}, err
+	}
+	defer current.handle.release()
+	return FindRange(ctx, current.db, ip)
+}
+
+// acquire returns the current snapshot, holding a reference to it that the
+// caller releases once done with it. A reload may replace the snapshot and
+// drop the last reference in between loading and acquiring it, in which
+// case the snapshot that replaced it is used.
+func (db *ReloadableDatabase) acquire() (*snapshot, error) {
+	for {
+		current := db.current.Load()
+		if current.handle.acquire() {
+			return current, nil
+		}
+		if db.current.Load() == current {
+			return nil, fmt.Errorf("%w: dataset %s is closed", utils.ErrDatabaseQuery, db.path)
+		}
+	}
+}
+
+// OnReload registers fn to be called after every successful swap
+func (db *ReloadableDatabase) OnReload(fn func()) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.listeners = append(db.listeners, fn)
+}
+
+// Reload parses and validates the dataset file and swaps it in. On failure the
+// previous snapshot keeps serving and the error is returned. The file is
+// opened once and hashed as it is parsed, so the version reported is that
+// of the data served. A file whose contents have not changed is not swapped
+// in again.
+func (db *ReloadableDatabase) Reload() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return fmt.Errorf("%w: dataset %s is closed", utils.ErrDatabaseQuery, db.path)
+	}
+
+	start := time.Now()
+	file, err := openDatasetFile(db.path)
+	if err != nil {
+		monitoring.DatabaseReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	defer file.Close()
+	state := file.state
+
+	// The sidecar only describes the dataset, so a broken one does not stop it loading
+	side, err := readSidecar(db.path)
+	if err != nil {
+		log.Printf("Ignoring the metadata of dataset %s: %v", db.path, err)
+	}
+	var checksum string
+	next, err := db.load(file)
+	if err == nil {
+		checksum, err = file.digest()
+	}
+	if err == nil {
+		if v, ok := next.(datasetValidator); ok {
+			err = v.Validate()
+		}
+	}
+	if err != nil {
+		monitoring.DatabaseReloads.WithLabelValues("failure").Inc()
+		log.Printf("Failed to load dataset %s, keeping previous data: %v", db.path, err)
+		closeSnapshot(next)
+		return err
+	}
+
+	// Rewriting a file with the same contents changes nothing being served,
+	// so the caches built on it stay valid
+	if current := db.current.Load(); current != nil && current.checksum == checksum {
+		closeSnapshot(next)
+		refreshed := *current
+		refreshed.modTime = state.modTime
+		refreshed.sidecar = side
+		db.current.Store(&refreshed)
+		db.loaded = state
+		monitoring.DatabaseReloads.WithLabelValues("success").Inc()
+		log.Printf("Dataset %s is unchanged at version %s", db.path, current.version)
+		return nil
+	}
+
+	previous := db.current.Swap(&snapshot{
+		db:       next,
+		handle:   newHandle(next),
+		modTime:  state.modTime,
+		checksum: checksum,
+		version:  checksum[:16],
+		loadedAt: time.Now(),
+		sidecar:  side,
+	})
+	db.loaded = state
+	monitoring.DatabaseReloads.WithLabelValues("success").Inc()
+	monitoring.DatasetLoadedTimestamp.SetToCurrentTime()
+	log.Printf("Loaded dataset %s in %s", db.path, time.Since(start))
+
+	if previous != nil {
+		previous.handle.release()
+		for _, fn := range db.listeners {
+			fn()
+		}
+	}
+	return nil
+}
+
+// Watch polls the dataset file every interval and reloads it once a change
+// has been observed and the file has stopped changing, so that a file which
+// is still being written is not picked up half-way. It returns when ctx is done.
+func (db *ReloadableDatabase) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pending, failed fileState
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, err := statFile(db.path)
+		if err != nil {
+			log.Printf("Error watching dataset %s: %v", db.path, err)
+			continue
+		}
+
+		db.mu.Lock()
+		unchanged := state == db.loaded
+		db.mu.Unlock()
+		if unchanged || state == failed {
+			pending = fileState{}
+			continue
+		}
+		if state != pending {
+			// The file changed since the last tick; wait for it to settle
+			pending = state
+			continue
+		}
+
+		log.Printf("Dataset %s changed, reloading", db.path)
+		if err := db.Reload(); err != nil {
+			// Don't retry the same broken file on every tick
+			failed = state
+		}
+		pending = fileState{}
+	}
+}
+
+// ModTime returns the modification time of the dataset file currently being served
+func (db *ReloadableDatabase) ModTime() time.Time {
+	return db.current.Load().modTime
+}
+
+// CheckHealth checks the snapshot currently being served, if it can check itself
+func (db *ReloadableDatabase) CheckHealth(ctx context.Context) error {
+	current, err := db.acquire()
+	if err != nil {
+		return err
+	}
+	defer current.handle.release()
+	if checker, ok := current.db.(interface{ CheckHealth(context.Context) error }); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Version identifies the dataset currently being served by a digest of its
+// file, so it changes with the contents and is the same on every replica
+func (db *ReloadableDatabase) Version() string {
+	return db.current.Load().version
+}
+
+// Dataset describes the dataset currently being served: what the snapshot
+// says about itself, completed by its file and sidecar
+func (db *ReloadableDatabase) Dataset(ctx context.Context) DatasetMetadata {
+	current := db.current.Load()
+	var meta DatasetMetadata
+	if described, ok := current.db.(Described); ok && current.handle.acquire() {
+		meta = described.Dataset(ctx)
+		current.handle.release()
+	}
+	meta.Path = db.path
+	meta.Checksum = current.checksum
+	meta.Version = current.version
+	loadedAt := current.loadedAt
+	meta.LoadedAt = &loadedAt
+	current.sidecar.apply(&meta)
+	return meta
+}
+
+// Close releases the current snapshot. Its resources are released once the
+// lookups still running against it are done, and later lookups fail.
+func (db *ReloadableDatabase) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	db.current.Load().handle.release()
+	return nil
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	if info.IsDir() {
+		return fileState{}, fmt.Errorf("%w: %s is a directory", utils.ErrDatabaseQuery, path)
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// closeSnapshot closes db if it holds resources such as a memory-mapped file
+func closeSnapshot(db IPDatabase) {
+	if c, ok := db.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"ip2country-service/pkg/utils"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// A snapshot is a compiled dataset that is memory-mapped and searched in
+// place instead of being parsed. All integers are little-endian.
+//
+//	header   snapshotHeaderSize bytes, see below
+//	ranges   one snapshotRecordSize record per range, sorted by ip_from
+//	strings  a deduplicated table of uint16-length-prefixed strings; the
+//	         records refer to them by offset, and offset 0 is the empty string
+//
+// The header holds the magic, the format version, the record size, the
+// number of ranges, the offset of each section and the size of the string
+// table, followed by a CRC-32C of everything after the header and the time
+// the snapshot was compiled, in Unix seconds.
+const (
+	snapshotMagic      = "IP2CSNAP"
+	snapshotVersion    = 1
+	snapshotHeaderSize = 64
+	snapshotRecordSize = 80
+)
+
+// Offsets of the fields of a range record
+const (
+	recFromHi       = 0
+	recFromLo       = 8
+	recToHi         = 16
+	recToLo         = 24
+	recCountry      = 32 // string offsets, uint32
+	recRegion       = 36
+	recCity         = 40
+	recPostalCode   = 44
+	recTimeZone     = 48
+	recOrganization = 52
+	recLatitude     = 56 // float64 bits
+	recLongitude    = 64
+	recASN          = 72 // uint32
+	recAccuracy     = 76 // uint16
+	recFlags        = 78 // uint16, see below
+)
+
+// Record flags saying which optional coordinates are set, since 0 is a valid value
+const (
+	flagLatitude uint16 = 1 << iota
+	flagLongitude
+)
+
+var snapshotCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteSnapshot compiles locations into a snapshot at path. The file is
+// written next to path and renamed into place, so a server watching path
+// never sees it half-written. locations are sorted as the loaders sort them
+// if they are not.
+func WriteSnapshot(path string, locations []IPLocation) (err error) {
+	if !slices.IsSortedFunc(locations, compareLocations) {
+		locations = slices.Clone(locations)
+		slices.SortStableFunc(locations, compareLocations)
+	}
+
+	table, offsets, err := buildStringTable(locations)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// Write the sections after a blank header, then go back to fill it in
+	if _, err := tmp.Write(make([]byte, snapshotHeaderSize)); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	checksum := crc32.New(snapshotCRC)
+	w := bufio.NewWriter(io.MultiWriter(tmp, checksum))
+	var record [snapshotRecordSize]byte
+	for i := range locations {
+		encodeRecord(record[:], &locations[i], offsets)
+		if _, err := w.Write(record[:]); err != nil {
+			return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+		}
+	}
+	if _, err := w.Write(table); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+
+	rangesSize := uint64(len(locations)) * snapshotRecordSize
+	var header [snapshotHeaderSize]byte
+	copy(header[0:8], snapshotMagic)
+	binary.LittleEndian.PutUint32(header[8:], snapshotVersion)
+	binary.LittleEndian.PutUint32(header[12:], snapshotRecordSize)
+	binary.LittleEndian.PutUint64(header[16:], uint64(len(locations)))
+	binary.LittleEndian.PutUint64(header[24:], snapshotHeaderSize)
+	binary.LittleEndian.PutUint64(header[32:], snapshotHeaderSize+rangesSize)
+	binary.LittleEndian.PutUint64(header[40:], uint64(len(table)))
+	binary.LittleEndian.PutUint32(header[48:], checksum.Sum32())
+	binary.LittleEndian.PutUint64(header[56:], uint64(time.Now().Unix()))
+	if _, err := tmp.WriteAt(header[:], 0); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+
+	// CreateTemp makes the file private; snapshots are read by the server's user
+	if err := tmp.Chmod(0o644); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	return nil
+}
+
+// compareLocations orders locations by IPFrom, then IPTo, so identical
+// ranges end up next to each other
+func compareLocations(a, b IPLocation) int {
+	if c := a.IPFrom.Compare(b.IPFrom); c != 0 {
+		return c
+	}
+	return a.IPTo.Compare(b.IPTo)
+}
+
+// buildStringTable stores every distinct string of locations once and
+// returns the table with the offset of each string
+func buildStringTable(locations []IPLocation) ([]byte, map[string]uint32, error) {
+	table := []byte{0, 0} // the empty string, at offset 0
+	offsets := map[string]uint32{"": 0}
+	for i := range locations {
+		loc := &locations[i]
+		for _, s := range []string{loc.Country, loc.Region, loc.City, loc.PostalCode, loc.TimeZone, loc.Organization} {
+			if _, ok := offsets[s]; ok {
+				continue
+			}
+			if len(s) > math.MaxUint16 {
+				return nil, nil, fmt.Errorf("%w: string of %d bytes in range %s - %s is too long for a snapshot", utils.ErrInvalidDataset, len(s), loc.IPFrom, loc.IPTo)
+			}
+			if len(table) > math.MaxUint32-2-len(s) {
+				return nil, nil, fmt.Errorf("%w: the dataset's strings do not fit in a snapshot", utils.ErrInvalidDataset)
+			}
+			offsets[s] = uint32(len(table))
+			table = binary.LittleEndian.AppendUint16(table, uint16(len(s)))
+			table = append(table, s...)
+		}
+	}
+	return table, offsets, nil
+}
+
+func encodeRecord(b []byte, loc *IPLocation, offsets map[string]uint32) {
+	binary.LittleEndian.PutUint64(b[recFromHi:], loc.IPFrom.Hi)
+	binary.LittleEndian.PutUint64(b[recFromLo:], loc.IPFrom.Lo)
+	binary.LittleEndian.PutUint64(b[recToHi:], loc.IPTo.Hi)
+	binary.LittleEndian.PutUint64(b[recToLo:], loc.IPTo.Lo)
+	binary.LittleEndian.PutUint32(b[recCountry:], offsets[loc.Country])
+	binary.LittleEndian.PutUint32(b[recRegion:], offsets[loc.Region])
+	binary.LittleEndian.PutUint32(b[recCity:], offsets[loc.City])
+	binary.LittleEndian.PutUint32(b[recPostalCode:], offsets[loc.PostalCode])
+	binary.LittleEndian.PutUint32(b[recTimeZone:], offsets[loc.TimeZone])
+	binary.LittleEndian.PutUint32(b[recOrganization:], offsets[loc.Organization])
+
+	var flags uint16
+	var latitude, longitude float64
+	if loc.Latitude != nil {
+		flags |= flagLatitude
+		latitude = *loc.Latitude
+	}
+	if loc.Longitude != nil {
+		flags |= flagLongitude
+		longitude = *loc.Longitude
+	}
+	binary.LittleEndian.PutUint64(b[recLatitude:], math.Float64bits(latitude))
+	binary.LittleEndian.PutUint64(b[recLongitude:], math.Float64bits(longitude))
+	binary.LittleEndian.PutUint32(b[recASN:], loc.ASN)
+	binary.LittleEndian.PutUint16(b[recAccuracy:], loc.AccuracyRadius)
+	binary.LittleEndian.PutUint16(b[recFlags:], flags)
+}
@@ -0,0 +1,253 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// SnapshotDatabase answers lookups from a memory-mapped snapshot written by
+// WriteSnapshot, binary-searching the range table in place. Opening one only
+// maps the file and verifies its checksum, and every process serving the
+// same file shares its pages. The file must be replaced by renaming a new
+// one over it, never rewritten in place, as WriteSnapshot does.
+type SnapshotDatabase struct {
+	path    string
+	data    []byte // the mapped file
+	ranges  []byte
+	strings []byte
+	count   int
+	builtAt time.Time // zero if the snapshot does not record it
+	unmap   func([]byte) error
+}
+
+func NewSnapshotDatabase(filePath string) (*SnapshotDatabase, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("Error opening snapshot file: %v", err)
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	if err := checkSnapshotSize(filePath, info.Size()); err != nil {
+		return nil, err
+	}
+
+	data, unmap, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		log.Printf("Error mapping snapshot file: %v", err)
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseQuery, err)
+	}
+	return newSnapshotDatabase(filePath, data, unmap)
+}
+
+// checkSnapshotSize rejects files that cannot be a snapshot or be mapped
+func checkSnapshotSize(path string, size int64) error {
+	if size < snapshotHeaderSize {
+		return fmt.Errorf("%w: %s is too short to be a snapshot", utils.ErrInvalidDataset, path)
+	}
+	if size > math.MaxInt {
+		return fmt.Errorf("%w: %s is too large to map", utils.ErrInvalidDataset, path)
+	}
+	return nil
+}
+
+// newSnapshotDatabase serves the snapshot mapped at data, unmapping it if
+// it is not a valid snapshot
+func newSnapshotDatabase(path string, data []byte, unmap func([]byte) error) (*SnapshotDatabase, error) {
+	db := &SnapshotDatabase{path: path, data: data, unmap: unmap}
+	if err := db.parseHeader(); err != nil {
+		unmap(data)
+		return nil, fmt.Errorf("%w: %s: %v", utils.ErrInvalidDataset, path, err)
+	}
+
+	log.Printf("Loaded snapshot %s: %d ranges, %d bytes of strings", path, db.count, len(db.strings))
+	return db, nil
+}
+
+// parseHeader locates the sections of the mapped file and verifies its checksum
+func (db *SnapshotDatabase) parseHeader() error {
+	header := db.data[:snapshotHeaderSize]
+	if !bytes.Equal(header[0:8], []byte(snapshotMagic)) {
+		return fmt.Errorf("not a snapshot file")
+	}
+	if version := binary.LittleEndian.Uint32(header[8:]); version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, want %d", version, snapshotVersion)
+	}
+	if size := binary.LittleEndian.Uint32(header[12:]); size != snapshotRecordSize {
+		return fmt.Errorf("unsupported record size %d", size)
+	}
+
+	count := binary.LittleEndian.Uint64(header[16:])
+	rangesOffset := binary.LittleEndian.Uint64(header[24:])
+	stringsOffset := binary.LittleEndian.Uint64(header[32:])
+	stringsSize := binary.LittleEndian.Uint64(header[40:])
+	size := uint64(len(db.data))
+	if rangesOffset < snapshotHeaderSize || rangesOffset > size || count > (size-rangesOffset)/snapshotRecordSize ||
+		stringsOffset < rangesOffset+count*snapshotRecordSize || stringsOffset > size || stringsSize != size-stringsOffset {
+		return fmt.Errorf("sections do not match the file size of %d bytes", size)
+	}
+	if checksum := crc32.Checksum(db.data[snapshotHeaderSize:], snapshotCRC); checksum != binary.LittleEndian.Uint32(header[48:]) {
+		return fmt.Errorf("checksum mismatch, the file is corrupt or truncated")
+	}
+
+	db.count = int(count)
+	if built := binary.LittleEndian.Uint64(header[56:]); built != 0 && built <= math.MaxInt64 {
+		db.builtAt = time.Unix(int64(built), 0).UTC()
+	}
+	db.ranges = db.data[rangesOffset : rangesOffset+count*snapshotRecordSize]
+	db.strings = db.data[stringsOffset:]
+	return nil
+}
+
+func (db *SnapshotDatabase) Find(ctx context.Context, ipStr string) (*models.Location, error) {
+	loc, _, err := db.FindRange(ctx, ipStr)
+	return loc, err
+}
+
+// FindRange looks ipStr up and also returns the dataset range it matched
+func (db *SnapshotDatabase) FindRange(ctx context.Context, ipStr string) (*models.Location, Range, error) {
+	const funcName = "SnapshotDatabase.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, Range{}, timeoutError(funcName, ipStr, err)
+	}
+
+	ipNum, err := ipStringToNumber(ipStr)
+	if err != nil {
+		log.Printf("[%s] Error converting IP '%s' to a number: %v", funcName, ipStr, err)
+		return nil, Range{}, invalidInputError(funcName, ipStr)
+	}
+
+	// Binary search to find the IP range
+	index := sort.Search(db.count, func(i int) bool {
+		return db.rangeAt(i).To.Compare(ipNum) >= 0
+	})
+
+	if index < db.count {
+		if matched := db.rangeAt(index); matched.From.Compare(ipNum) <= 0 {
+			log.Printf("[%s] IP '%s' found in range %s - %s", funcName, ipStr, matched.From, matched.To)
+			return db.locationAt(index).toLocation(), matched, nil
+		}
+	}
+
+	log.Printf("[%s] IP '%s' not found in any range", funcName, ipStr)
+	return nil, Range{}, notFoundError(funcName, ipStr)
+}
+
+// Validate checks that the snapshot holds at least one range and that its
+// records are sorted by start and end with none inverted, as the binary
+// search in FindRange relies on. It reads every record, so it runs when the
+// snapshot is loaded rather than on health checks.
+func (db *SnapshotDatabase) Validate() error {
+	if db.count == 0 {
+		return fmt.Errorf("%w: no IP ranges loaded", utils.ErrInvalidDataset)
+	}
+	var previous Range
+	for i := 0; i < db.count; i++ {
+		r := db.rangeAt(i)
+		if r.To.Less(r.From) {
+			return fmt.Errorf("%w: %s: record %d has inverted range %s - %s", utils.ErrInvalidDataset, db.path, i, r.From, r.To)
+		}
+		if i > 0 && (r.From.Less(previous.From) || (r.From == previous.From && r.To.Less(previous.To))) {
+			return fmt.Errorf("%w: %s: record %d (%s - %s) sorts before the one preceding it", utils.ErrInvalidDataset, db.path, i, r.From, r.To)
+		}
+		previous = r
+	}
+	return nil
+}
+
+// CheckHealth reports an error if the snapshot holds no ranges to answer lookups from
+func (db *SnapshotDatabase) CheckHealth(_ context.Context) error {
+	if db.count == 0 {
+		return fmt.Errorf("%w: no IP ranges loaded", utils.ErrInvalidDataset)
+	}
+	return nil
+}
+
+// Dataset describes the snapshot, including when it was compiled
+func (db *SnapshotDatabase) Dataset(_ context.Context) DatasetMetadata {
+	meta := DatasetMetadata{Type: "snapshot", Path: db.path, Records: db.count}
+	if !db.builtAt.IsZero() {
+		builtAt := db.builtAt
+		meta.BuildDate = &builtAt
+	}
+	return meta
+}
+
+// Close unmaps the snapshot file. Locations already returned stay valid, as
+// their strings are copied out of the mapping.
+func (db *SnapshotDatabase) Close() error {
+	return db.unmap(db.data)
+}
+
+func (db *SnapshotDatabase) record(i int) []byte {
+	return db.ranges[i*snapshotRecordSize : (i+1)*snapshotRecordSize]
+}
+
+func (db *SnapshotDatabase) rangeAt(i int) Range {
+	b := db.record(i)
+	return Range{
+		From: IPNumber{Hi: binary.LittleEndian.Uint64(b[recFromHi:]), Lo: binary.LittleEndian.Uint64(b[recFromLo:])},
+		To:   IPNumber{Hi: binary.LittleEndian.Uint64(b[recToHi:]), Lo: binary.LittleEndian.Uint64(b[recToLo:])},
+	}
+}
+
+// locationAt decodes the i-th range, copying its strings out of the mapping
+func (db *SnapshotDatabase) locationAt(i int) *IPLocation {
+	b := db.record(i)
+	r := db.rangeAt(i)
+	loc := &IPLocation{
+		IPFrom:         r.From,
+		IPTo:           r.To,
+		Country:        db.stringAt(binary.LittleEndian.Uint32(b[recCountry:])),
+		Region:         db.stringAt(binary.LittleEndian.Uint32(b[recRegion:])),
+		City:           db.stringAt(binary.LittleEndian.Uint32(b[recCity:])),
+		PostalCode:     db.stringAt(binary.LittleEndian.Uint32(b[recPostalCode:])),
+		TimeZone:       db.stringAt(binary.LittleEndian.Uint32(b[recTimeZone:])),
+		Organization:   db.stringAt(binary.LittleEndian.Uint32(b[recOrganization:])),
+		ASN:            binary.LittleEndian.Uint32(b[recASN:]),
+		AccuracyRadius: binary.LittleEndian.Uint16(b[recAccuracy:]),
+	}
+	flags := binary.LittleEndian.Uint16(b[recFlags:])
+	if flags&flagLatitude != 0 {
+		latitude := math.Float64frombits(binary.LittleEndian.Uint64(b[recLatitude:]))
+		loc.Latitude = &latitude
+	}
+	if flags&flagLongitude != 0 {
+		longitude := math.Float64frombits(binary.LittleEndian.Uint64(b[recLongitude:]))
+		loc.Longitude = &longitude
+	}
+	return loc
+}
+
+// stringAt returns a copy of the string at offset in the string table, or
+// "" if the offset does not point at one
+func (db *SnapshotDatabase) stringAt(offset uint32) string {
+	return string(db.rawStringAt(offset))
+}
+
+// rawStringAt is stringAt without the copy; the bytes are the mapping's own
+func (db *SnapshotDatabase) rawStringAt(offset uint32) []byte {
+	if uint64(offset)+2 > uint64(len(db.strings)) {
+		return nil
+	}
+	n := uint64(binary.LittleEndian.Uint16(db.strings[offset:]))
+	start := uint64(offset) + 2
+	if start+n > uint64(len(db.strings)) {
+		return nil
+	}
+	return db.strings[start : start+n]
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"ip2country-service/internal/models"
+	"log"
+	"math/bits"
+)
+
+// addressBits is the length of an IPNumber; IPv4 addresses occupy the last
+// 32 bits, under ::/96
+const addressBits = 128
+
+// TrieDatabase answers lookups from a path-compressed binary radix trie
+// rather than by binary search. Every range is decomposed into the CIDR
+// prefixes covering it, and a lookup returns the longest prefix matching the
+// address, so nested ranges resolve to the most specific one where binary
+// search would return whichever range it lands on. Where two ranges yield
+// the same prefix, the narrower range wins, and of equal ones the later.
+type TrieDatabase struct {
+	DatabaseLocal
+	nodes []trieNode // nodes[0] is the root, the /0 prefix
+}
+
+// trieNode is a prefix in the trie. Children are indexes into nodes, with 0
+// meaning none since the root is nobody's child.
+type trieNode struct {
+	prefix   IPNumber // masked to length bits
+	location int32    // index into Locations, or -1 if no range yields this prefix
+	children [2]int32
+	length   uint8
+}
+
+// NewTrieDatabase indexes the ranges of local, keeping its validation mode.
+// In lenient mode inverted ranges are dropped first, as Validate would drop
+// them, so the trie's indexes into Locations stay valid.
+func NewTrieDatabase(local DatabaseLocal) *TrieDatabase {
+	if local.Validation != ValidationStrict {
+		local.dropInverted()
+	}
+	db := &TrieDatabase{DatabaseLocal: local, nodes: []trieNode{{location: -1}}}
+	for i := range db.Locations {
+		loc := &db.Locations[i]
+		if loc.IPTo.Less(loc.IPFrom) {
+			continue // rejected by Validate
+		}
+		forEachPrefix(loc.rangeOf(), func(prefix IPNumber, length int) {
+			db.insert(prefix, length, int32(i))
+		})
+	}
+	return db
+}
+
+func (db *TrieDatabase) Find(ctx context.Context, ipStr string) (*models.Location, error) {
+	loc, _, err := db.FindRange(ctx, ipStr)
+	return loc, err
+}
+
+// FindRange returns the location of the longest prefix containing ipStr.
+// The range returned is the largest block around the address that no more
+// specific prefix cuts into, so every address in it gets the same answer.
+func (db *TrieDatabase) FindRange(ctx context.Context, ipStr string) (*models.Location, Range, error) {
+	const funcName = "TrieDatabase.Find"
+	if err := ctx.Err(); err != nil {
+		return nil, Range{}, timeoutError(funcName, ipStr, err)
+	}
+
+	ipNum, err := ipStringToNumber(ipStr)
+	if err != nil {
+		log.Printf("[%s] Error converting IP '%s' to a number: %v", funcName, ipStr, err)
+		return nil, Range{}, invalidInputError(funcName, ipStr)
+	}
+
+	best := -1
+	for index := 0; ; {
+		node := &db.nodes[index]
+		if commonPrefixLength(ipNum, node.prefix) < int(node.length) {
+			break
+		}
+		if node.location >= 0 {
+			best = index
+		}
+		if node.length == addressBits {
+			break
+		}
+		index = int(node.children[bitAt(ipNum, int(node.length))])
+		if index == 0 {
+			break
+		}
+	}
+
+	if best < 0 {
+		log.Printf("[%s] IP '%s' not found in any range", funcName, ipStr)
+		return nil, Range{}, notFoundError(funcName, ipStr)
+	}
+	loc := &db.Locations[db.nodes[best].location]
+	log.Printf("[%s] IP '%s' found in range %s - %s", funcName, ipStr, loc.IPFrom, loc.IPTo)
+	return loc.toLocation(), db.answeredBlock(best, ipNum), nil
+}
+
+// answeredBlock returns the largest prefix block containing n that lies
+// within the node at index best and holds no more specific prefix
+func (db *TrieDatabase) answeredBlock(best int, n IPNumber) Range {
+	node := &db.nodes[best]
+	for {
+		if node.length == addressBits || node.children == [2]int32{} {
+			return blockRange(n, int(node.length))
+		}
+		child := node.children[bitAt(n, int(node.length))]
+		if child == 0 {
+			// Only the other half holds more specific prefixes
+			return blockRange(n, int(node.length)+1)
+		}
+		next := &db.nodes[child]
+		if common := commonPrefixLength(n, next.prefix); common < int(next.length) {
+			// n leaves the child's prefix at bit common, where nothing more specific lies
+			return blockRange(n, common+1)
+		}
+		// The child holds n but no range of its own, or it would have matched
+		node = next
+	}
+}
+
+// insert stores location under the prefix, splitting nodes as needed
+func (db *TrieDatabase) insert(prefix IPNumber, length int, location int32) {
+	index := 0
+	for {
+		node := &db.nodes[index]
+		if int(node.length) == length {
+			if node.location < 0 || !wider(db.Locations[location].rangeOf(), db.Locations[node.location].rangeOf()) {
+				node.location = location
+			}
+			return
+		}
+
+		side := bitAt(prefix, int(node.length))
+		childIndex := node.children[side]
+		if childIndex == 0 {
+			db.nodes[index].children[side] = db.newNode(prefix, length, location)
+			return
+		}
+
+		child := db.nodes[childIndex]
+		common := min(commonPrefixLength(prefix, child.prefix), int(child.length), length)
+		if common == int(child.length) {
+			index = int(childIndex)
+			continue
+		}
+
+		// The new prefix and the child part ways at bit common: put a node there above both
+		split := db.newNode(prefix, common, -1)
+		db.nodes[split].children[bitAt(child.prefix, common)] = childIndex
+		if common == length {
+			db.nodes[split].location = location
+		} else {
+			db.nodes[split].children[bitAt(prefix, common)] = db.newNode(prefix, length, location)
+		}
+		db.nodes[index].children[side] = split
+		return
+	}
+}
+
+func (db *TrieDatabase) newNode(prefix IPNumber, length int, location int32) int32 {
+	db.nodes = append(db.nodes, trieNode{prefix: maskPrefix(prefix, length), length: uint8(length), location: location})
+	return int32(len(db.nodes) - 1)
+}
+
+// forEachPrefix calls fn with each CIDR prefix of the smallest set covering r exactly
+func forEachPrefix(r Range, fn func(prefix IPNumber, length int)) {
+	for from := r.From; ; {
+		// The largest block starting at from that is aligned and ends within r
+		size := trailingZeros(from)
+		for size > 0 && r.To.Less(orLowBits(from, size)) {
+			size--
+		}
+		fn(from, addressBits-size)
+
+		last := orLowBits(from, size)
+		if !last.Less(r.To) {
+			return
+		}
+		from = last.Next()
+	}
+}
+
+// bitAt returns bit i of n, counting from the most significant
+func bitAt(n IPNumber, i int) int {
+	if i < 64 {
+		return int(n.Hi>>(63-i)) & 1
+	}
+	return int(n.Lo>>(127-i)) & 1
+}
+
+// commonPrefixLength returns the number of leading bits a and b share
+func commonPrefixLength(a, b IPNumber) int {
+	if x := a.Hi ^ b.Hi; x != 0 {
+		return bits.LeadingZeros64(x)
+	}
+	return 64 + bits.LeadingZeros64(a.Lo^b.Lo)
+}
+
+// trailingZeros returns the number of trailing zero bits of n, 128 for 0
+func trailingZeros(n IPNumber) int {
+	if n.Lo != 0 {
+		return bits.TrailingZeros64(n.Lo)
+	}
+	return 64 + bits.TrailingZeros64(n.Hi)
+}
+
+// orLowBits returns n with its k lowest bits set
+func orLowBits(n IPNumber, k int) IPNumber {
+	switch {
+	case k == 0:
+		return n
+	case k < 64:
+		return IPNumber{Hi: n.Hi, Lo: n.Lo | (1<<k - 1)}
+	case k < 128:
+		return IPNumber{Hi: n.Hi | (1<<(k-64) - 1), Lo: ^uint64(0)}
+	default:
+		return IPNumber{Hi: ^uint64(0), Lo: ^uint64(0)}
+	}
+}
+
+// maskPrefix clears every bit of n after the first length
+func maskPrefix(n IPNumber, length int) IPNumber {
+	host := orLowBits(IPNumber{}, addressBits-length)
+	return IPNumber{Hi: n.Hi &^ host.Hi, Lo: n.Lo &^ host.Lo}
+}
+
+// blockRange returns the range of the prefix of n with the given length
+func blockRange(n IPNumber, length int) Range {
+	from := maskPrefix(n, length)
+	return Range{From: from, To: orLowBits(from, addressBits-length)}
+}
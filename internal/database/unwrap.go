@@ -0,0 +1,32 @@
+package database
+
+// Unwrapper is implemented by databases that decorate another IPDatabase,
+// such as a shared cache in front of the dataset
+type Unwrapper interface {
+	Unwrap() IPDatabase
+}
+
+// Versioned is implemented by databases that can identify the dataset they
+// serve. The version changes whenever the data does, so anything derived
+// from lookups can be keyed by it.
+type Versioned interface {
+	Version() string
+}
+
+// As returns the first database in db's decorator chain, starting with db
+// itself, that is a T. It lets callers reach capabilities such as Reloader
+// through decorators that do not forward them.
+func As[T any](db IPDatabase) (T, bool) {
+	for db != nil {
+		if t, ok := db.(T); ok {
+			return t, true
+		}
+		u, ok := db.(Unwrapper)
+		if !ok {
+			break
+		}
+		db = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
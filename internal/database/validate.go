@@ -0,0 +1,203 @@
+package database
+
+import (
+	"fmt"
+	"ip2country-service/monitoring"
+	"ip2country-service/pkg/utils"
+	"log"
+	"math"
+	"strings"
+)
+
+// ValidationMode says what happens when a dataset fails the checks in
+// ValidationReport. Empty datasets and impossible coordinates are rejected
+// in every mode, as lookups cannot be answered from them.
+type ValidationMode string
+
+const (
+	// ValidationLenient loads the dataset anyway, without its inverted
+	// ranges, logging a summary and exposing the counts as metrics
+	ValidationLenient ValidationMode = "lenient"
+	// ValidationStrict refuses the dataset, so the service does not start
+	// and a reload keeps the previous data
+	ValidationStrict ValidationMode = "strict"
+)
+
+// ParseValidationMode reads the DATASET_VALIDATION setting
+func ParseValidationMode(s string) (ValidationMode, error) {
+	switch mode := ValidationMode(strings.ToLower(s)); mode {
+	case "", ValidationLenient:
+		return ValidationLenient, nil
+	case ValidationStrict:
+		return ValidationStrict, nil
+	default:
+		return "", fmt.Errorf("invalid DATASET_VALIDATION %q: must be strict or lenient", s)
+	}
+}
+
+// maxValidationExamples bounds the problems a report describes individually
+const maxValidationExamples = 10
+
+// ValidationReport describes the problems found in a dataset. Overlapping
+// ranges make the binary search return whichever range it lands on, so
+// lookups in them are answered inconsistently.
+type ValidationReport struct {
+	Ranges           int
+	Overlapping      int // Ranges sharing addresses with an earlier range
+	Duplicates       int // Ranges repeating an earlier range exactly
+	Inverted         int // Ranges whose ip_from is greater than their ip_to
+	UnknownCountries int // Ranges whose country is not an ISO 3166-1 alpha-2 code
+	// Percentage of each address space covered by the ranges
+	IPv4Coverage float64
+	IPv6Coverage float64
+	// Gaps counts the stretches of addresses left uncovered between two
+	// ranges of the same family. They are not problems, as datasets need
+	// not cover every address, so Issues does not count them.
+	Gaps int
+	// Examples describes the first few problems found
+	Examples []string
+	// GapExamples lists the first few gaps found
+	GapExamples []string
+}
+
+// Issues returns the number of problems found
+func (r *ValidationReport) Issues() int {
+	return r.Overlapping + r.Duplicates + r.Inverted + r.UnknownCountries
+}
+
+func (r *ValidationReport) String() string {
+	return fmt.Sprintf("%d ranges, %d overlapping, %d duplicates, %d inverted, %d unknown countries, %.2f%% of IPv4 and %.4f%% of IPv6 covered, %d gaps",
+		r.Ranges, r.Overlapping, r.Duplicates, r.Inverted, r.UnknownCountries, r.IPv4Coverage, r.IPv6Coverage, r.Gaps)
+}
+
+func (r *ValidationReport) example(format string, args ...interface{}) {
+	if len(r.Examples) < maxValidationExamples {
+		r.Examples = append(r.Examples, fmt.Sprintf(format, args...))
+	}
+}
+
+// ValidateLocations checks locations, which must be sorted by IPFrom, then
+// IPTo, as the loaders leave them
+func ValidateLocations(locations []IPLocation) *ValidationReport {
+	report := &ValidationReport{Ranges: len(locations)}
+	var coverage coverageCounter
+
+	// widest is the earlier range reaching furthest, which any overlapping range must overlap
+	var widest *IPLocation
+	for i := range locations {
+		loc := &locations[i]
+		if !IsCountryCode(loc.Country) {
+			report.UnknownCountries++
+			report.example("unknown country %q for %s", loc.Country, describeRange(loc.rangeOf()))
+		}
+		if loc.IPTo.Less(loc.IPFrom) {
+			report.Inverted++
+			report.example("inverted range %s", describeRange(loc.rangeOf()))
+			continue
+		}
+		if gap, found := coverage.add(loc.rangeOf()); found {
+			report.Gaps++
+			if len(report.GapExamples) < maxValidationExamples {
+				report.GapExamples = append(report.GapExamples, describeRange(gap))
+			}
+		}
+
+		switch {
+		case widest == nil:
+		case i > 0 && locations[i-1].rangeOf() == loc.rangeOf():
+			report.Duplicates++
+			report.example("duplicate range %s (%s and %s)", describeRange(loc.rangeOf()), locations[i-1].Country, loc.Country)
+		case loc.IPFrom.Compare(widest.IPTo) <= 0:
+			report.Overlapping++
+			report.example("range %s (%s) overlaps %s (%s)", describeRange(loc.rangeOf()), loc.Country, describeRange(widest.rangeOf()), widest.Country)
+		}
+		if widest == nil || widest.IPTo.Less(loc.IPTo) {
+			widest = loc
+		}
+	}
+
+	report.IPv4Coverage, report.IPv6Coverage = coverage.percentages()
+	return report
+}
+
+// describeRange formats a range with its bounds as addresses
+func describeRange(r Range) string {
+	return fmt.Sprintf("%s - %s", r.From.IP(), r.To.IP())
+}
+
+// coverageCounter adds up the addresses covered by ranges passed in order of
+// their start, counting addresses shared by several ranges once, and finds
+// the gaps between them
+type coverageCounter struct {
+	ipv4, ipv6 float64
+	covered    Range // the union of the ranges so far that ends last
+	started    bool
+}
+
+// add counts the addresses of r not covered yet. If r starts past the end
+// of the ranges before it, in the same address family, it also returns the
+// gap left between them.
+func (c *coverageCounter) add(r Range) (gap Range, found bool) {
+	if c.started && r.From.Compare(c.covered.To) <= 0 {
+		// Count only the part past what is already covered
+		if !c.covered.To.Less(r.To) {
+			return
+		}
+		r.From = c.covered.To.Next()
+	} else if c.started && c.covered.To.Next().Less(r.From) && c.covered.To.IsIPv4() == r.From.IsIPv4() {
+		gap, found = Range{From: c.covered.To.Next(), To: r.From.Prev()}, true
+	}
+	c.started = true
+	c.covered = r
+
+	maxIPv4 := IPv4Number(math.MaxUint32)
+	if r.From.IsIPv4() {
+		to := r.To
+		if maxIPv4.Less(to) {
+			to = maxIPv4
+		}
+		c.ipv4 += float64(to.Lo-r.From.Lo) + 1
+		if !maxIPv4.Less(r.To) {
+			return
+		}
+		r.From = maxIPv4.Next()
+	}
+	c.ipv6 += approximate(r.To) - approximate(r.From) + 1
+	return
+}
+
+// percentages returns the share of the IPv4 and IPv6 address spaces covered
+func (c *coverageCounter) percentages() (float64, float64) {
+	return c.ipv4 / math.Exp2(32) * 100, c.ipv6 / math.Exp2(128) * 100
+}
+
+// approximate returns n as a float, for proportions of the IPv6 space
+func approximate(n IPNumber) float64 {
+	return float64(n.Hi)*math.Exp2(64) + float64(n.Lo)
+}
+
+// checkRanges runs ValidateLocations and applies mode to the result,
+// recording the counts as metrics when the dataset is accepted. Inverted
+// ranges are expected to have been rejected, or dropped and counted in
+// inverted, already.
+func checkRanges(locations []IPLocation, inverted int, mode ValidationMode) error {
+	report := ValidateLocations(locations)
+	report.Inverted += inverted
+	if report.Issues() > 0 {
+		if mode == ValidationStrict {
+			return fmt.Errorf("%w: %s; first problem: %s", utils.ErrInvalidDataset, report, report.Examples[0])
+		}
+		log.Printf("Dataset validation found %d problems: %s", report.Issues(), report)
+		for _, example := range report.Examples {
+			log.Printf("Dataset validation: %s", example)
+		}
+	}
+
+	monitoring.DatasetValidationIssues.WithLabelValues("overlapping").Set(float64(report.Overlapping))
+	monitoring.DatasetValidationIssues.WithLabelValues("duplicate").Set(float64(report.Duplicates))
+	monitoring.DatasetValidationIssues.WithLabelValues("inverted").Set(float64(report.Inverted))
+	monitoring.DatasetValidationIssues.WithLabelValues("unknown_country").Set(float64(report.UnknownCountries))
+	monitoring.DatasetCoverage.WithLabelValues("ipv4").Set(report.IPv4Coverage)
+	monitoring.DatasetCoverage.WithLabelValues("ipv6").Set(report.IPv6Coverage)
+	return nil
+}
@@ -0,0 +1,149 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ip2country-service/monitoring"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check results reported in Report and CheckResult
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// drainingCheck is the name of the built-in check that fails during shutdown
+const drainingCheck = "draining"
+
+// Checker reports whether one dependency is usable, returning nil if it is
+type Checker func(ctx context.Context) error
+
+// Dependency is implemented by components that can check their own health,
+// such as database backends and the Redis rate limiter
+type Dependency interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the body returned by the probes; Status is "fail" if any check failed
+type Report struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks,omitempty"`
+}
+
+type namedChecker struct {
+	name  string
+	check Checker
+}
+
+// Status tracks whether the instance should receive new traffic. It runs
+// the registered dependency checks on demand, and once shutdown begins it
+// reports draining, so load balancers polling the readiness probe take the
+// instance out of rotation while in-flight requests finish.
+type Status struct {
+	draining atomic.Bool
+	timeout  time.Duration // per check; 0 leaves only the caller's deadline
+
+	mu       sync.RWMutex
+	checkers []namedChecker
+}
+
+// NewStatus creates a Status whose checks each get at most checkTimeout
+func NewStatus(checkTimeout time.Duration) *Status {
+	return &Status{timeout: checkTimeout}
+}
+
+// Register adds a readiness check reported under name
+func (s *Status) Register(name string, check Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkers = append(s.checkers, namedChecker{name: name, check: check})
+}
+
+// StartDraining marks the instance as shutting down; it cannot be undone
+func (s *Status) StartDraining() {
+	s.draining.Store(true)
+}
+
+// Draining reports whether StartDraining has been called
+func (s *Status) Draining() bool {
+	return s.draining.Load()
+}
+
+// Ready runs every registered check concurrently, plus the draining check,
+// and records each result in the health_check_status and
+// health_check_duration_seconds gauges
+func (s *Status) Ready(ctx context.Context) Report {
+	s.mu.RLock()
+	checkers := append([]namedChecker{{name: drainingCheck, check: s.checkDraining}}, s.checkers...)
+	s.mu.RUnlock()
+
+	results := make([]CheckResult, len(checkers))
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		wg.Add(1)
+		go func(i int, c namedChecker) {
+			defer wg.Done()
+			results[i] = s.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (s *Status) run(ctx context.Context, c namedChecker) CheckResult {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := c.check(ctx)
+	latency := time.Since(start)
+
+	result := CheckResult{Name: c.name, Status: StatusOK, LatencyMs: float64(latency.Microseconds()) / 1000}
+	passing := 1.0
+	if err != nil {
+		result.Status, result.Error = StatusFail, err.Error()
+		passing = 0
+	}
+	monitoring.HealthCheckStatus.WithLabelValues(c.name).Set(passing)
+	monitoring.HealthCheckDuration.WithLabelValues(c.name).Set(latency.Seconds())
+	return result
+}
+
+func (s *Status) checkDraining(_ context.Context) error {
+	if s.Draining() {
+		return errors.New("shutting down")
+	}
+	return nil
+}
+
+// DatasetAge fails once the dataset last modified at modTime() is older than maxAge
+func DatasetAge(maxAge time.Duration, modTime func() time.Time) Checker {
+	return func(_ context.Context) error {
+		if age := time.Since(modTime()); age > maxAge {
+			return fmt.Errorf("dataset is %s old, maximum is %s", age.Round(time.Second), maxAge)
+		}
+		return nil
+	}
+}
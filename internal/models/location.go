@@ -1,7 +1,23 @@
 package models
 
+// Location is what a lookup returns. Only Country is always set; every other
+// field is optional and omitted from responses when the dataset lacks it.
 type Location struct {
 	Country string `json:"country,omitempty"`
 	Region  string `json:"region,omitempty"`
 	City    string `json:"city,omitempty"`
+	// Coordinates are pointers because 0 is a valid latitude and longitude
+	Latitude       *float64 `json:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty"`
+	AccuracyRadius uint16   `json:"accuracy_radius,omitempty"` // Kilometres around the coordinates
+	PostalCode     string   `json:"postal_code,omitempty"`
+	TimeZone       string   `json:"timezone,omitempty"` // IANA name, e.g. "Europe/London"
+	ASN            uint32   `json:"asn,omitempty"`      // Autonomous system number
+	Organization   string   `json:"organization,omitempty"`
+	// Source is SourceOverride when the answer comes from the overrides file
+	// rather than the dataset; it is returned whichever fields are selected
+	Source string `json:"source,omitempty"`
 }
+
+// SourceOverride marks answers taken from the overrides file
+const SourceOverride = "override"
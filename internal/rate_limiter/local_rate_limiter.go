@@ -1,8 +1,9 @@
 package rate_limiter
 
 import (
+	"context"
+	"ip2country-service/monitoring"
 	"math/rand"
-	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -32,38 +33,59 @@ func NewLocalRateLimiter(rate, capacity float64, jitter time.Duration) *LocalRat
 
 func (rl *LocalRateLimiter) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		bucket, rate, capacity, err := quota(r, rl.rate, rl.capacity)
 		if err != nil {
 			http.Error(w, `{"error": "Invalid client address"}`, http.StatusBadRequest)
 			return
 		}
-		ip := host
-		now := time.Now()
 
-		rl.mu.Lock()
-		c, exists := rl.clients[ip]
-		if !exists {
-			c = &client{tokens: rl.capacity, lastCheck: now}
-			rl.clients[ip] = c
-		}
-
-		elapsed := now.Sub(c.lastCheck).Seconds()
-		c.tokens = min(rl.capacity, c.tokens+elapsed*rl.rate)
-		c.lastCheck = now
-
-		if c.tokens >= 1 {
-			c.tokens--
-			rl.mu.Unlock() // Unlock before proceeding
+		state := rl.take(bucket, 1, rate, capacity)
+		state.setHeaders(w)
+		if state.allowed {
 			jitter := time.Duration(rand.Int63n(int64(rl.jitter)))
 			time.Sleep(jitter)
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, withCharger(r, capacity, func(_ context.Context, cost float64) (bool, error) {
+				state := rl.take(bucket, cost, rate, capacity)
+				state.setHeaders(w)
+				return state.allowed, nil
+			}))
 		} else {
-			rl.mu.Unlock() // Unlock before responding
+			monitoring.RateLimitExceeded.WithLabelValues(r.URL.Path).Inc()
 			http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
 		}
 	})
 }
 
+// LimitFailedAuth throttles clients failing authentication, per client IP
+func (rl *LocalRateLimiter) LimitFailedAuth(next http.Handler) http.Handler {
+	return limitFailures(next, rl.rate, rl.capacity, func(_ context.Context, bucket string, cost, rate, capacity float64) (bucketState, error) {
+		return rl.take(bucket, cost, rate, capacity), nil
+	})
+}
+
+// take refills the bucket and removes cost tokens from it if enough are available
+func (rl *LocalRateLimiter) take(bucket string, cost, rate, capacity float64) bucketState {
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	c, exists := rl.clients[bucket]
+	if !exists {
+		c = &client{tokens: capacity, lastCheck: now}
+		rl.clients[bucket] = c
+	}
+
+	elapsed := now.Sub(c.lastCheck).Seconds()
+	c.tokens = min(capacity, c.tokens+elapsed*rate)
+	c.lastCheck = now
+
+	allowed := c.tokens >= cost
+	if allowed {
+		c.tokens -= cost
+	}
+	return newBucketState(allowed, c.tokens, cost, capacity, rate)
+}
+
 func min(a, b float64) float64 {
 	if a < b {
 		return a
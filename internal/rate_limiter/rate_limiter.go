@@ -1,13 +1,189 @@
 package rate_limiter
 
 import (
+	"context"
 	"fmt"
 	"ip2country-service/config"
+	"ip2country-service/internal/auth"
+	"ip2country-service/internal/client_ip"
+	"ip2country-service/monitoring"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type RateLimiter interface {
 	Limit(next http.Handler) http.Handler
+	// LimitFailedAuth throttles clients failing authentication, and goes in
+	// front of the authentication middleware
+	LimitFailedAuth(next http.Handler) http.Handler
+}
+
+// bucketState is a client's token bucket as observed by one attempt to take tokens
+type bucketState struct {
+	allowed    bool
+	capacity   float64
+	remaining  float64       // tokens left after the attempt
+	retryAfter time.Duration // until the bucket holds enough tokens for the attempt; 0 if allowed
+	reset      time.Duration // until the bucket is full again
+}
+
+// newBucketState derives the timings of a bucket holding tokens after an attempt to take cost tokens
+func newBucketState(allowed bool, tokens, cost, capacity, rate float64) bucketState {
+	state := bucketState{allowed: allowed, capacity: capacity, remaining: tokens}
+	if rate > 0 {
+		if !allowed {
+			state.retryAfter = time.Duration((cost - tokens) / rate * float64(time.Second))
+		}
+		state.reset = time.Duration((capacity - tokens) / rate * float64(time.Second))
+	}
+	return state
+}
+
+// setHeaders writes the IETF draft RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, plus Retry-After when the attempt was rejected.
+// Durations are rounded up to whole seconds as both specifications require.
+func (s bucketState) setHeaders(w http.ResponseWriter) {
+	header := w.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(int(s.capacity)))
+	header.Set("RateLimit-Remaining", strconv.Itoa(int(math.Max(0, s.remaining))))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(s.reset)))
+	if s.allowed {
+		header.Del("Retry-After")
+	} else {
+		header.Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(s.retryAfter))))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// quota picks the bucket a request is charged to and its refill rate and
+// capacity. Requests made with an API key share the key's bucket whatever
+// address they come from; all others get a bucket per client IP. The
+// caller's plan overrides the limiter's default rate and capacity.
+func quota(r *http.Request, rate, capacity float64) (string, float64, float64, error) {
+	principal := auth.FromContext(r.Context())
+	if principal != nil {
+		if principal.RateLimit > 0 {
+			rate = principal.RateLimit
+		}
+		if principal.RateCapacity > 0 {
+			capacity = principal.RateCapacity
+		}
+		if !principal.Anonymous {
+			return "key:" + principal.Name, rate, capacity, nil
+		}
+	}
+
+	ip, err := client_ip.FromRequest(r)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	return ip, rate, capacity, nil
+}
+
+// takeFunc takes cost tokens from bucket, refilled at rate up to capacity,
+// if enough are available
+type takeFunc func(ctx context.Context, bucket string, cost, rate, capacity float64) (bucketState, error)
+
+// limitFailures charges every request answered with 401 or 403 to a bucket
+// of failed attempts kept per client IP, with the default rate and capacity,
+// and refuses requests with 429 while that bucket is empty. It runs before
+// the key is checked, so a client guessing keys is throttled even though
+// Limit only sees authenticated requests. Clients that authenticate never
+// touch the bucket.
+func limitFailures(next http.Handler, rate, capacity float64, take takeFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, err := client_ip.FromRequest(r)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid client address"}`, http.StatusBadRequest)
+			return
+		}
+		bucket := "auth_failures:" + ip
+
+		// Taking nothing refills the bucket and reports what it holds
+		state, err := take(r.Context(), bucket, 0, rate, capacity)
+		if err != nil {
+			log.Printf("Rate limiter error for %s: %v", bucket, err)
+			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
+			return
+		}
+		if state.remaining < 1 {
+			newBucketState(false, state.remaining, 1, capacity, rate).setHeaders(w)
+			monitoring.RateLimitExceeded.WithLabelValues(r.URL.Path).Inc()
+			http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == http.StatusUnauthorized || recorder.status == http.StatusForbidden {
+			if _, err := take(context.WithoutCancel(r.Context()), bucket, 1, rate, capacity); err != nil {
+				log.Printf("Rate limiter error for %s: %v", bucket, err)
+			}
+		}
+	})
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// chargeKey is the context key under which Limit stores the client's bucket
+type chargeKey struct{}
+
+// charger takes cost tokens from the bucket of the client that made a request,
+// or none at all if the bucket holds fewer than cost tokens
+type charger func(ctx context.Context, cost float64) (bool, error)
+
+// clientBucket is what handlers can do with the bucket a request was charged to
+type clientBucket struct {
+	charge   charger
+	capacity float64
+}
+
+// withCharger returns r with c attached for handlers that call Charge, and
+// the capacity of the bucket it charges for handlers that call Capacity
+func withCharger(r *http.Request, capacity float64, c charger) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), chargeKey{}, clientBucket{charge: c, capacity: capacity}))
+}
+
+// Charge takes cost additional tokens from the bucket of the client whose
+// request ctx belongs to, on top of the token Limit already took. Handlers
+// whose work scales with the request, such as batch lookups, use it to charge
+// per item. It reports true if the tokens were available or if the request
+// did not pass through a rate limiter.
+func Charge(ctx context.Context, cost float64) (bool, error) {
+	bucket, ok := ctx.Value(chargeKey{}).(clientBucket)
+	if !ok || cost <= 0 {
+		return true, nil
+	}
+	return bucket.charge(ctx, cost)
+}
+
+// Capacity returns the most tokens the bucket of the client whose request
+// ctx belongs to can ever hold, under the client's plan. A handler charging
+// per item can reject work that no amount of waiting would pay for. It
+// reports false if the request did not pass through a rate limiter.
+func Capacity(ctx context.Context) (float64, bool) {
+	bucket, ok := ctx.Value(chargeKey{}).(clientBucket)
+	return bucket.capacity, ok
 }
 
 func NewRateLimiter(cfg *config.Config) (RateLimiter, error) {
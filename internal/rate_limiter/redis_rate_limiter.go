@@ -6,8 +6,8 @@ import (
 	"ip2country-service/config"
 	"log"
 	"math/rand"
-	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"ip2country-service/monitoring"
@@ -37,29 +37,46 @@ func NewRedisRateLimiter(cfg *config.Config) *RedisRateLimiter {
 	}
 }
 
+// CheckHealth pings the Redis server holding the buckets
+func (rl *RedisRateLimiter) CheckHealth(ctx context.Context) error {
+	return rl.client.Ping(ctx).Err()
+}
+
+// Close releases the limiter's Redis connections
+func (rl *RedisRateLimiter) Close() error {
+	return rl.client.Close()
+}
+
 func (rl *RedisRateLimiter) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		bucket, rate, capacity, err := quota(r, rl.rate, rl.capacity)
 		if err != nil {
 			http.Error(w, `{"error": "Invalid client address"}`, http.StatusBadRequest)
 			return
 		}
-		ip := host
-		key := "rate_limit:" + ip
+		key := "rate_limit:" + bucket
 
-		allowed, err := rl.allowRequest(ctx, key)
+		state, err := rl.allowRequest(ctx, key, 1, rate, capacity)
 		if err != nil {
 			// Log the error for debugging
-			log.Printf("Rate limiter error for IP %s: %v", ip, err)
+			log.Printf("Rate limiter error for %s: %v", bucket, err)
 			http.Error(w, `{"error": "Internal server error"}`, http.StatusInternalServerError)
 			return
 		}
 
-		if allowed {
+		state.setHeaders(w)
+		if state.allowed {
 			jitter := time.Duration(rand.Int63n(int64(rl.jitter)))
 			time.Sleep(jitter)
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, withCharger(r, capacity, func(ctx context.Context, cost float64) (bool, error) {
+				state, err := rl.allowRequest(ctx, key, cost, rate, capacity)
+				if err != nil {
+					return false, err
+				}
+				state.setHeaders(w)
+				return state.allowed, nil
+			}))
 		} else {
 			monitoring.RateLimitExceeded.WithLabelValues(r.URL.Path).Inc()
 			http.Error(w, `{"error": "Rate limit exceeded"}`, http.StatusTooManyRequests)
@@ -67,7 +84,16 @@ func (rl *RedisRateLimiter) Limit(next http.Handler) http.Handler {
 	})
 }
 
-func (rl *RedisRateLimiter) allowRequest(ctx context.Context, key string) (bool, error) {
+// LimitFailedAuth throttles clients failing authentication, per client IP
+func (rl *RedisRateLimiter) LimitFailedAuth(next http.Handler) http.Handler {
+	return limitFailures(next, rl.rate, rl.capacity, func(ctx context.Context, bucket string, cost, rate, capacity float64) (bucketState, error) {
+		return rl.allowRequest(ctx, "rate_limit:"+bucket, cost, rate, capacity)
+	})
+}
+
+// allowRequest atomically refills the bucket stored under key and takes
+// requested tokens from it if enough are available
+func (rl *RedisRateLimiter) allowRequest(ctx context.Context, key string, requested, rate, capacity float64) (bucketState, error) {
 	now := time.Now().UnixMilli() // Use milliseconds
 	script := `
         local tokens_key = KEYS[1]
@@ -105,25 +131,50 @@ func (rl *RedisRateLimiter) allowRequest(ctx context.Context, key string) (bool,
         redis.call("SETEX", tokens_key, 60, new_tokens)
         redis.call("SETEX", timestamp_key, 60, now)
 
-        if allowed then
-            return 1
-        else
-            return 0
+        -- Milliseconds until the bucket holds enough tokens for this request
+        -- (the time to the next token for single-token requests) and until it is full
+        local wait_ms = 0
+        local reset_ms = 0
+        if rate > 0 then
+            if not allowed then
+                wait_ms = math.ceil((requested - new_tokens) / rate * 1000)
+            end
+            reset_ms = math.ceil((capacity - new_tokens) / rate * 1000)
         end
+
+        -- Redis truncates Lua numbers to integers, so remaining tokens are sent as a string
+        return {allowed and 1 or 0, tostring(new_tokens), wait_ms, reset_ms}
     `
 
 	keys := []string{key + ":tokens", key + ":ts"}
-	args := []interface{}{rl.rate, rl.capacity, now, 1}
+	args := []interface{}{rate, capacity, now, requested}
 
 	result, err := rl.client.Eval(ctx, script, keys, args...).Result()
 	if err != nil {
-		return false, err
+		return bucketState{}, err
 	}
 
-	intResult, ok := result.(int64)
-	if !ok {
-		return false, fmt.Errorf("unexpected script result type: %T", result)
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 4 {
+		return bucketState{}, fmt.Errorf("unexpected script result: %v", result)
+	}
+	allowed, ok1 := values[0].(int64)
+	tokens, ok2 := values[1].(string)
+	waitMs, ok3 := values[2].(int64)
+	resetMs, ok4 := values[3].(int64)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return bucketState{}, fmt.Errorf("unexpected script result types: %T, %T, %T, %T", values[0], values[1], values[2], values[3])
+	}
+	remaining, err := strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return bucketState{}, fmt.Errorf("unexpected remaining tokens %q: %w", tokens, err)
 	}
 
-	return intResult == 1, nil
+	return bucketState{
+		allowed:    allowed == 1,
+		capacity:   capacity,
+		remaining:  remaining,
+		retryAfter: time.Duration(waitMs) * time.Millisecond,
+		reset:      time.Duration(resetMs) * time.Millisecond,
+	}, nil
 }
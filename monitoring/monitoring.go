@@ -71,8 +71,125 @@ var (
 		},
 		[]string{"path"},
 	)
+
+	NegativeCacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_negative_hits_total",
+			Help: "Total number of lookups answered not found from the cache",
+		},
+		[]string{"path"},
+	)
+
+	CoalescedLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lookup_coalesced_total",
+			Help: "Total number of lookups that waited for an identical in-flight database query instead of issuing their own",
+		},
+		[]string{"path"},
+	)
+
+	CacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+			Help: "Total number of entries removed from the lookup cache",
+		},
+		[]string{"reason"},
+	)
+
+	CacheEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_entries",
+			Help: "Number of entries in the lookup cache",
+		},
+	)
+
+	CacheBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "cache_bytes",
+			Help: "Approximate memory used by the lookup cache entries in bytes",
+		},
+	)
+
+	RedisCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "redis_cache_requests_total",
+			Help: "Total number of reads from the shared Redis lookup cache by result (hit, miss, error or skipped while Redis is unavailable)",
+		},
+		[]string{"result"},
+	)
+
+	AuthFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_auth_failures_total",
+			Help: "Total number of HTTP requests rejected for a missing, invalid or disabled API key",
+		},
+		[]string{"path", "reason"},
+	)
+
+	HealthCheckStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "health_check_status",
+			Help: "Result of the latest readiness check per dependency (1 = passing, 0 = failing)",
+		},
+		[]string{"check"},
+	)
+
+	HealthCheckDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "health_check_duration_seconds",
+			Help: "Duration of the latest readiness check per dependency in seconds",
+		},
+		[]string{"check"},
+	)
+
+	BatchSize = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "batch_lookup_size",
+			Help:    "Number of IPs per batch lookup request",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 7),
+		},
+	)
+
+	DatabaseReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "database_reloads_total",
+			Help: "Total number of dataset reload attempts",
+		},
+		[]string{"result"},
+	)
+
+	DatasetLoadedTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dataset_loaded_timestamp_seconds",
+			Help: "Unix time at which the currently served dataset was loaded",
+		},
+	)
+
+	DatasetValidationIssues = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dataset_validation_issues",
+			Help: "Number of ranges in the served dataset with each validation problem (overlapping, duplicate, inverted or unknown_country)",
+		},
+		[]string{"issue"},
+	)
+
+	DatasetCoverage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dataset_coverage_percent",
+			Help: "Percentage of the IPv4 or IPv6 address space covered by the served dataset",
+		},
+		[]string{"family"},
+	)
+
+	DatasetInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dataset_info",
+			Help: "Always 1, labelled with the type, version, checksum, vendor, vendor version and build date of the served dataset",
+		},
+		[]string{"type", "version", "checksum", "vendor", "vendor_version", "build_date"},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(RequestsTotal, RequestDuration, RateLimitExceeded, IPLookupDuration, DatabaseQueryDuration, AllowedFieldsUsage, CacheHits, CacheMisses)
+	prometheus.MustRegister(RequestsTotal, RequestDuration, RateLimitExceeded, IPLookupDuration, DatabaseQueryDuration, AllowedFieldsUsage, CacheHits, CacheMisses, NegativeCacheHits, CoalescedLookups, CacheEvictions, CacheEntries, CacheBytes, RedisCacheRequests, AuthFailures, HealthCheckStatus, HealthCheckDuration, BatchSize, DatabaseReloads, DatasetLoadedTimestamp, DatasetValidationIssues, DatasetCoverage, DatasetInfo)
 }
@@ -0,0 +1,196 @@
+// Package ipnum holds the 128-bit address numbers datasets are keyed by and
+// the parsing of the address and range forms datasets may use. It is shared
+// by the service and the MongoDB migration tool in data/, so both read a
+// dataset the same way.
+package ipnum
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"ip2country-service/pkg/utils"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Number is an unsigned 128-bit integer holding an IPv4 or IPv6 address.
+// IPv4 addresses, including IPv4-mapped IPv6 addresses such as ::ffff:10.0.0.1,
+// are stored in the low 32 bits so existing IPv4 datasets keep their values.
+// The IPv6 addresses of ::/96 would share those numbers, so FromIP refuses
+// them.
+type Number struct {
+	Hi uint64
+	Lo uint64
+}
+
+// ipv4MappedPrefix is the low word of the ::ffff:0:0/96 prefix used by IPv4-mapped IPv6 addresses
+const ipv4MappedPrefix = 0xffff << 32
+
+// FromIPv4 returns the Number for an IPv4 address given as a 32-bit integer
+func FromIPv4(v uint32) Number {
+	return Number{Lo: uint64(v)}
+}
+
+// FromIP converts a parsed IP address to its Number
+func FromIP(ip net.IP) (Number, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return FromIPv4(binary.BigEndian.Uint32(ip4)), nil
+	}
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return Number{}, fmt.Errorf("%w: %v", utils.ErrInvalidIP, ip)
+	}
+	n := Number{
+		Hi: binary.BigEndian.Uint64(ip16[:8]),
+		Lo: binary.BigEndian.Uint64(ip16[8:]),
+	}
+	if n.IsIPv4() {
+		// ::1 would otherwise be looked up as 0.0.0.1
+		return Number{}, fmt.Errorf("%w: %v lies in ::/96, whose numbers are those of IPv4 addresses", utils.ErrInvalidIP, ip)
+	}
+	return n, nil
+}
+
+// ParseNumber parses a base-10 integer of up to 128 bits
+func ParseNumber(s string) (Number, error) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return Number{}, fmt.Errorf("%w: %q", utils.ErrUnsupportedIPFormat, s)
+	}
+	var buf [16]byte
+	n.FillBytes(buf[:])
+	return Number{
+		Hi: binary.BigEndian.Uint64(buf[:8]),
+		Lo: binary.BigEndian.Uint64(buf[8:]),
+	}, nil
+}
+
+// IsIPv4 reports whether the number falls in the IPv4 address space
+func (n Number) IsIPv4() bool {
+	return n.Hi == 0 && n.Lo <= 0xffffffff
+}
+
+// Compare returns -1, 0 or +1 depending on whether n is less than, equal to or greater than other
+func (n Number) Compare(other Number) int {
+	switch {
+	case n.Hi < other.Hi:
+		return -1
+	case n.Hi > other.Hi:
+		return 1
+	case n.Lo < other.Lo:
+		return -1
+	case n.Lo > other.Lo:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Less reports whether n sorts before other
+func (n Number) Less(other Number) bool {
+	return n.Compare(other) < 0
+}
+
+// Next returns the number after n, wrapping at the top of the address space
+func (n Number) Next() Number {
+	if n.Lo == math.MaxUint64 {
+		return Number{Hi: n.Hi + 1}
+	}
+	return Number{Hi: n.Hi, Lo: n.Lo + 1}
+}
+
+// Prev returns the number before n, wrapping at zero
+func (n Number) Prev() Number {
+	if n.Lo == 0 {
+		return Number{Hi: n.Hi - 1, Lo: math.MaxUint64}
+	}
+	return Number{Hi: n.Hi, Lo: n.Lo - 1}
+}
+
+// IP returns the address as a net.IP, using the 4-byte form for IPv4
+func (n Number) IP() net.IP {
+	if n.IsIPv4() {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(n.Lo))
+		return ip
+	}
+	return toIPv6(n)
+}
+
+// String returns the base-10 representation of the number
+func (n Number) String() string {
+	if n.Hi == 0 {
+		return fmt.Sprintf("%d", n.Lo)
+	}
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], n.Hi)
+	binary.BigEndian.PutUint64(buf[8:], n.Lo)
+	return new(big.Int).SetBytes(buf[:]).String()
+}
+
+// MarshalJSON encodes the number as a JSON integer literal
+func (n Number) MarshalJSON() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON integer or a string holding a base-10 integer
+func (n *Number) UnmarshalJSON(data []byte) error {
+	var s string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else {
+		s = string(data)
+	}
+	parsed, err := ParseNumber(s)
+	if err != nil {
+		return err
+	}
+	*n = parsed
+	return nil
+}
+
+// MarshalBSONValue stores IPv4 numbers as int64 so existing collections and
+// indexes keep working, and IPv6 numbers as 16-byte big-endian binary, which
+// MongoDB compares byte-wise and therefore in numeric order.
+func (n Number) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if n.IsIPv4() {
+		return bsontype.Int64, bsoncore.AppendInt64(nil, int64(n.Lo)), nil
+	}
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], n.Hi)
+	binary.BigEndian.PutUint64(buf[8:], n.Lo)
+	return bsontype.Binary, bsoncore.AppendBinary(nil, bsontype.BinaryGeneric, buf[:]), nil
+}
+
+// UnmarshalBSONValue decodes numbers written by MarshalBSONValue as well as
+// int32 and double values produced by other import tools.
+func (n *Number) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bsontype.Int32:
+		*n = Number{Lo: uint64(uint32(value.Int32()))}
+	case bsontype.Int64:
+		*n = Number{Lo: uint64(value.Int64())}
+	case bsontype.Double:
+		*n = Number{Lo: uint64(value.Double())}
+	case bsontype.Binary:
+		_, b := value.Binary()
+		if len(b) != net.IPv6len {
+			return fmt.Errorf("%w: binary IP of length %d", utils.ErrUnsupportedIPFormat, len(b))
+		}
+		*n = Number{
+			Hi: binary.BigEndian.Uint64(b[:8]),
+			Lo: binary.BigEndian.Uint64(b[8:]),
+		}
+	default:
+		return fmt.Errorf("%w: BSON type %s", utils.ErrUnsupportedIPFormat, t)
+	}
+	return nil
+}
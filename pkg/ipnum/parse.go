@@ -0,0 +1,181 @@
+package ipnum
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"ip2country-service/pkg/utils"
+	"net"
+	"strings"
+)
+
+// ParseAddress parses an address given either as a base-10 integer or in
+// IPv4 or IPv6 text form. Like FromIP, it refuses IPv6 addresses in ::/96.
+func ParseAddress(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return FromIP(ip)
+	}
+	return ParseNumber(s)
+}
+
+// parseBound parses an address bounding a range, in any form ParseAddress
+// accepts. IPv6 addresses are taken from their 16 bytes, so a range may
+// start in ::/96 as long as checkRange finds it extends beyond it.
+func parseBound(s string) (n Number, ipv6 bool, err error) {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil && ip.To4() == nil {
+		return fromIPv6(ip), true, nil
+	}
+	n, err = ParseAddress(s)
+	return n, false, err
+}
+
+// fromIPv6 returns the number of a 16-byte address without refusing those in ::/96
+func fromIPv6(ip net.IP) Number {
+	return Number{
+		Hi: binary.BigEndian.Uint64(ip[:8]),
+		Lo: binary.BigEndian.Uint64(ip[8:]),
+	}
+}
+
+// toIPv6 returns the 16-byte address of a number, even one in ::/96
+func toIPv6(n Number) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], n.Hi)
+	binary.BigEndian.PutUint64(ip[8:], n.Lo)
+	return ip
+}
+
+// checkRange refuses a range written with IPv6 addresses that lies within
+// ::/96, whose numbers are those of IPv4 addresses, and folds ranges in the
+// IPv4-mapped IPv6 space into IPv4 ranges. IPv6 ranges extending beyond
+// ::/96, such as ::/64 or ::/0, keep their numbers.
+func checkRange(from, to Number, ipv6 bool) (Number, Number, error) {
+	if ipv6 && to.IsIPv4() {
+		return Number{}, Number{}, fmt.Errorf("%w: %v - %v lies in ::/96, whose numbers are those of IPv4 addresses",
+			utils.ErrInvalidIP, toIPv6(from), toIPv6(to))
+	}
+	from, to = normalize(from, to)
+	return from, to, nil
+}
+
+// ParseRange parses a range written as a CIDR network (10.1.0.0/16), as
+// first and last addresses separated by a hyphen (10.1.0.0-10.1.255.255,
+// either address as text or an integer) or as a single address, and returns
+// its first and last numbers. Ranges in the IPv4-mapped IPv6 space are
+// folded into IPv4 ranges.
+func ParseRange(s string) (Number, Number, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.Contains(s, "/"):
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return Number{}, Number{}, fmt.Errorf("%w: %q", utils.ErrUnsupportedIPFormat, s)
+		}
+		return FromNetwork(network)
+	case strings.Contains(s, "-"):
+		first, last, _ := strings.Cut(s, "-")
+		from, fromIPv6, err := parseBound(first)
+		if err != nil {
+			return Number{}, Number{}, err
+		}
+		to, toIPv6, err := parseBound(last)
+		if err != nil {
+			return Number{}, Number{}, err
+		}
+		return checkRange(from, to, fromIPv6 || toIPv6)
+	default:
+		from, ipv6, err := parseBound(s)
+		if err != nil {
+			return Number{}, Number{}, err
+		}
+		return checkRange(from, from, ipv6)
+	}
+}
+
+// ParseBounds builds a dataset range from its ip_from and ip_to values. When
+// ip_to is empty, ip_from may hold any form ParseRange accepts. On error,
+// the returned field names which value was invalid.
+func ParseBounds(from, to string) (Number, Number, string, error) {
+	if strings.TrimSpace(to) == "" {
+		first, last, err := ParseRange(from)
+		return first, last, "ip_from", err
+	}
+	first, firstIPv6, err := parseBound(from)
+	if err != nil {
+		return Number{}, Number{}, "ip_from", err
+	}
+	last, lastIPv6, err := parseBound(to)
+	if err != nil {
+		return Number{}, Number{}, "ip_to", err
+	}
+	first, last, err = checkRange(first, last, firstIPv6 || lastIPv6)
+	if err != nil {
+		return Number{}, Number{}, "ip_from", err
+	}
+	return first, last, "", nil
+}
+
+// ParseJSONBounds builds the range of a JSON dataset entry from its network
+// key, or else from its raw ip_from and ip_to values, each an integer or a
+// string. ip_from may also hold a network when ip_to is absent.
+func ParseJSONBounds(network string, from, to json.RawMessage) (Number, Number, error) {
+	if network != "" {
+		return ParseRange(network)
+	}
+	first := jsonBound(from)
+	if first == "" {
+		return Number{}, Number{}, fmt.Errorf("%w: entry has neither ip_from nor network", utils.ErrUnsupportedIPFormat)
+	}
+	firstNum, lastNum, _, err := ParseBounds(first, jsonBound(to))
+	return firstNum, lastNum, err
+}
+
+// jsonBound returns a JSON number or string as text, or "" for null or a missing value
+func jsonBound(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// FromNetwork returns the first and last numbers of a CIDR network. IPv6
+// networks are read from their 16 bytes, so networks such as ::/0 or ::/64
+// that start in ::/96 are accepted; only those lying within it are refused.
+func FromNetwork(network *net.IPNet) (Number, Number, error) {
+	ip := network.IP
+	if len(network.Mask) == net.IPv4len {
+		ip = ip.To4()
+	}
+	if len(ip) != len(network.Mask) {
+		return Number{}, Number{}, fmt.Errorf("%w: malformed network %v", utils.ErrInvalidIP, network)
+	}
+
+	first := ip.Mask(network.Mask)
+	last := make(net.IP, len(ip))
+	for i := range ip {
+		last[i] = ip[i] | ^network.Mask[i]
+	}
+	if len(ip) == net.IPv4len {
+		from, _ := FromIP(first)
+		to, _ := FromIP(last)
+		return from, to, nil
+	}
+	return checkRange(fromIPv6(first), fromIPv6(last), true)
+}
+
+// normalize folds ranges expressed in the IPv4-mapped IPv6 space
+// (::ffff:0:0/96), as shipped by several vendors, into plain IPv4 ranges so
+// that IPv4 and IPv4-mapped lookups resolve to the same record.
+func normalize(from, to Number) (Number, Number) {
+	if from.Hi == 0 && to.Hi == 0 &&
+		from.Lo&^0xffffffff == ipv4MappedPrefix && to.Lo&^0xffffffff == ipv4MappedPrefix {
+		return FromIPv4(uint32(from.Lo)), FromIPv4(uint32(to.Lo))
+	}
+	return from, to
+}
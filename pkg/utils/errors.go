@@ -8,10 +8,23 @@ var (
 	ErrIpNotFound          = errors.New("IP not found in database")
 	ErrBuildResponse       = errors.New("error building response")
 	ErrDatabaseQuery       = errors.New("error querying database")
+	ErrDatabaseTimeout     = errors.New("database lookup timed out")
 	ErrInvalidFields       = errors.New("invalid fields requested")
 	ErrJSONMarshal         = errors.New("error marshaling JSON")
 	ErrJSONUnmarshal       = errors.New("error unmarshaling JSON")
 	ErrInternalServer      = errors.New("internal server error")
 	ErrUnsupportedIPFormat = errors.New("unsupported IP format")
 	ErrMongoDB             = errors.New("error querying MongoDB")
+	ErrInvalidDataset      = errors.New("invalid dataset")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrInvalidBatch        = errors.New("invalid batch request")
+	ErrBatchTooLarge       = errors.New("too many IPs in batch request")
+	ErrRateLimitExceeded   = errors.New("rate limit exceeded")
+	ErrMissingAPIKey       = errors.New("API key required")
+	ErrInvalidAPIKey       = errors.New("invalid API key")
+	ErrAPIKeyDisabled      = errors.New("API key disabled")
+	ErrAdminDisabled       = errors.New("admin endpoints are disabled, set ADMIN_TOKEN to enable them")
+	ErrInvalidCountry      = errors.New("invalid country code")
+	ErrInvalidPagination   = errors.New("invalid pagination parameters")
+	ErrUnsupportedQuery    = errors.New("query not supported by this database type")
 )
@@ -1,12 +1,18 @@
 package api_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"ip2country-service/api"
 	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/health"
 	"ip2country-service/internal/models"
 
 	"github.com/gorilla/mux"
@@ -20,7 +26,7 @@ func (m *mockDatabase) Find(ip string) (*models.Location, error) {
 
 func TestRegisterHandlers(t *testing.T) {
 	router := mux.NewRouter()
-	db := &mockDatabase{}
+	db := database.FromLegacy(&mockDatabase{})
 	cfg := &config.Config{}
 
 	api.RegisterHandlers(router, db, cfg)
@@ -48,3 +54,73 @@ func TestRegisterHandlers(t *testing.T) {
 		}
 	}
 }
+
+func TestHealthCheck_Draining(t *testing.T) {
+	// The health check answers on the root router, even with every request
+	// to the API subrouter refused as by API key authentication
+	router := mux.NewRouter()
+	apiRouter := router.PathPrefix("/api/v1").Subrouter()
+	apiRouter.Use(func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	})
+	api.RegisterHandlers(apiRouter, database.FromLegacy(&mockDatabase{}), &config.Config{})
+	status := health.NewStatus(time.Second)
+	api.RegisterProbes(router, status)
+
+	check := func(want int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/health", nil))
+		if rr.Code != want {
+			t.Errorf("health check returned %d, want %d", rr.Code, want)
+		}
+	}
+
+	check(http.StatusOK)
+	status.StartDraining()
+	check(http.StatusServiceUnavailable)
+}
+
+func TestProbes(t *testing.T) {
+	router := mux.NewRouter()
+	status := health.NewStatus(time.Second)
+	api.RegisterProbes(router, status)
+
+	var failing bool
+	status.Register("database", func(ctx context.Context) error {
+		if failing {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+
+	probe := func(path string) (int, health.Report) {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var report health.Report
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s returned invalid JSON: %v", path, err)
+		}
+		return rr.Code, report
+	}
+
+	if code, report := probe("/readyz"); code != http.StatusOK || report.Status != health.StatusOK || len(report.Checks) != 2 {
+		t.Errorf("expected a passing readiness report with draining and database checks, got %d %+v", code, report)
+	}
+
+	failing = true
+	code, report := probe("/readyz")
+	if code != http.StatusServiceUnavailable || report.Status != health.StatusFail {
+		t.Errorf("expected a failing readiness report, got %d %+v", code, report)
+	}
+	if check := report.Checks[1]; check.Name != "database" || check.Status != health.StatusFail || check.Error != "unreachable" {
+		t.Errorf("unexpected database check result %+v", check)
+	}
+
+	// Liveness ignores dependencies so broken backends don't get pods restarted
+	if code, report := probe("/livez"); code != http.StatusOK || report.Status != health.StatusOK {
+		t.Errorf("expected liveness to pass, got %d %+v", code, report)
+	}
+}
@@ -0,0 +1,117 @@
+package v1_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "ip2country-service/api/v1"
+	"ip2country-service/config"
+	"ip2country-service/internal/cache"
+	"ip2country-service/internal/database"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestReloadDataset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	content := `[{"ip_from": 167772160, "ip_to": 167772415, "country": "US", "region": "California", "city": "Los Angeles"}]`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.NewIPDatabase(&config.Config{DatabaseType: "json", DatabasePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		db     database.IPDatabase
+		token  string
+		header string
+		want   int
+	}{
+		{"Valid token", db, "secret", "Bearer secret", http.StatusOK},
+		{"No token configured", db, "", "", http.StatusForbidden},
+		{"No token configured with a header", db, "", "Bearer ", http.StatusForbidden},
+		{"Missing token", db, "secret", "", http.StatusUnauthorized},
+		{"Wrong token", db, "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"Not reloadable", database.FromLegacy(&mockDatabase{}), "secret", "Bearer secret", http.StatusNotImplemented},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := v1.NewAdminHandler(tt.db, &config.Config{AdminToken: tt.token}, nil)
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ReloadDataset(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	cfg := &config.Config{AdminToken: "secret", CacheMaxEntries: 10}
+	db := database.FromLegacy(&mockDatabase{})
+	ipHandler := v1.NewIPHandler(db, cfg)
+	ipHandler.GetLocation(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/find-country?ip=10.0.0.1", nil))
+	if ipHandler.Cache().Len() != 1 {
+		t.Fatalf("expected the lookup to be cached, got %d entries", ipHandler.Cache().Len())
+	}
+
+	handler := v1.NewAdminHandler(db, cfg, ipHandler.Cache())
+	rr := httptest.NewRecorder()
+	handler.PurgeCache(rr, httptest.NewRequest(http.MethodPost, "/admin/cache/purge", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	handler.PurgeCache(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+	if ipHandler.Cache().Len() != 0 {
+		t.Errorf("expected the cache to be empty, got %d entries", ipHandler.Cache().Len())
+	}
+}
+
+func TestPurgeCache_SharedCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := &config.Config{AdminToken: "secret", CacheMaxEntries: 10, CacheTTL: time.Minute}
+	db := cache.NewRedisTier(database.FromLegacy(&mockDatabase{}), redis.NewClient(&redis.Options{Addr: mr.Addr()}), cfg)
+	defer db.Close()
+	ipHandler := v1.NewIPHandler(db, cfg)
+	ipHandler.GetLocation(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/find-country?ip=10.0.0.1", nil))
+	if keys := mr.Keys(); len(keys) != 1 {
+		t.Fatalf("expected the lookup to be shared through Redis, got keys %v", keys)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	v1.NewAdminHandler(db, cfg, ipHandler.Cache()).PurgeCache(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("expected the shared cache to be empty, got keys %v", keys)
+	}
+	if !strings.Contains(rr.Body.String(), `"shared_entries":1`) {
+		t.Errorf("expected the purged shared entries to be reported, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,164 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "ip2country-service/api/v1"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/rate_limiter"
+)
+
+func newBatchHandler() *v1.IPHandler {
+	cfg := &config.Config{
+		AllowedFields: []string{"country", "region", "city"},
+		BatchMaxIPs:   3,
+	}
+	return v1.NewIPHandler(database.FromLegacy(&mockDatabase{}), cfg)
+}
+
+func postBatch(t *testing.T, handler http.Handler, url, body string) (*httptest.ResponseRecorder, []v1.BatchResult) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var response struct {
+		Results []v1.BatchResult `json:"results"`
+	}
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("could not parse response: %v", err)
+		}
+	}
+	return rr, response.Results
+}
+
+func TestGetLocationsBatch(t *testing.T) {
+	handler := http.HandlerFunc(newBatchHandler().GetLocationsBatch)
+
+	t.Run("JSON array", func(t *testing.T) {
+		rr, results := postBatch(t, handler, "/find-country/batch", `["10.0.0.1", "invalid_ip", "10.0.0.2"]`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		wantStatus := []int{http.StatusOK, http.StatusBadRequest, http.StatusInternalServerError}
+		if len(results) != len(wantStatus) {
+			t.Fatalf("got %d results, want %d", len(results), len(wantStatus))
+		}
+		for i, want := range wantStatus {
+			if results[i].Status != want {
+				t.Errorf("result %d (%s) status = %d, want %d", i, results[i].IP, results[i].Status, want)
+			}
+		}
+		if results[0].IP != "10.0.0.1" || results[0].Location["city"] != "Los Angeles" {
+			t.Errorf("unexpected first result: %+v", results[0])
+		}
+		if results[1].Error == "" || results[1].Location != nil {
+			t.Errorf("expected error for invalid IP, got %+v", results[1])
+		}
+	})
+
+	t.Run("Newline-delimited text with fields", func(t *testing.T) {
+		rr, results := postBatch(t, handler, "/find-country/batch?fields=country", "10.0.0.1\n\n  10.0.0.1  \n")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if len(results) != 2 {
+			t.Fatalf("got %d results, want 2", len(results))
+		}
+		for _, result := range results {
+			if len(result.Location) != 1 || result.Location["country"] != "US" {
+				t.Errorf("expected only country in location, got %+v", result.Location)
+			}
+		}
+	})
+
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want int
+	}{
+		{"Invalid fields", "/find-country/batch?fields=isp", `["10.0.0.1"]`, http.StatusBadRequest},
+		{"Too many IPs", "/find-country/batch", `["10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"]`, http.StatusRequestEntityTooLarge},
+		{"Empty body", "/find-country/batch", "", http.StatusBadRequest},
+		{"Malformed JSON", "/find-country/batch", `["10.0.0.1"`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, _ := postBatch(t, handler, tt.url, tt.body)
+			if rr.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetLocationsBatch_ChargesPerIP(t *testing.T) {
+	limiter := rate_limiter.NewLocalRateLimiter(0.001, 3, time.Millisecond)
+	handler := limiter.Limit(http.HandlerFunc(newBatchHandler().GetLocationsBatch))
+
+	rr, _ := postBatch(t, handler, "/find-country/batch", `["10.0.0.1", "10.0.0.1", "10.0.0.1"]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first batch returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// The bucket held three tokens and the first batch used all of them
+	rr, _ = postBatch(t, handler, "/find-country/batch", `["10.0.0.1"]`)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("second batch returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestGetLocationsBatch_LargerThanCapacity(t *testing.T) {
+	limiter := rate_limiter.NewLocalRateLimiter(0.001, 2, time.Millisecond)
+	handler := limiter.Limit(http.HandlerFunc(newBatchHandler().GetLocationsBatch))
+
+	// Three IPs can never fit in a bucket of two tokens, however long the client waits
+	rr, _ := postBatch(t, handler, "/find-country/batch", `["10.0.0.1", "10.0.0.1", "10.0.0.1"]`)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("batch returned wrong status code: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if !strings.Contains(rr.Body.String(), "at most 2 IPs") {
+		t.Errorf("response %q does not name the largest batch allowed", rr.Body)
+	}
+	if retry := rr.Header().Get("Retry-After"); retry != "" {
+		t.Errorf("Retry-After = %q, want none for a batch that can never succeed", retry)
+	}
+
+	// Only the request's own token was taken, so a batch that fits still succeeds
+	if rr, _ = postBatch(t, handler, "/find-country/batch", `["10.0.0.1"]`); rr.Code != http.StatusOK {
+		t.Errorf("batch within capacity returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestGetLocationsBatch_EffectiveLimit(t *testing.T) {
+	// newBatchHandler accepts up to 3 IPs; the bucket capacity may lower that
+	for _, tt := range []struct {
+		capacity float64
+		largest  int
+	}{
+		{capacity: 5, largest: 3}, // BATCH_MAX_IPS applies
+		{capacity: 2, largest: 2}, // the capacity applies
+	} {
+		// Each batch is sent with a full bucket
+		send := func(n int) (*httptest.ResponseRecorder, []v1.BatchResult) {
+			limiter := rate_limiter.NewLocalRateLimiter(0.001, tt.capacity, time.Millisecond)
+			handler := limiter.Limit(http.HandlerFunc(newBatchHandler().GetLocationsBatch))
+			return postBatch(t, handler, "/find-country/batch", `["`+strings.Repeat(`10.0.0.1", "`, n-1)+`10.0.0.1"]`)
+		}
+
+		if rr, _ := send(tt.largest + 1); rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("capacity %v: batch of %d returned %v, want %v", tt.capacity, tt.largest+1, rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		if rr, results := send(tt.largest); rr.Code != http.StatusOK || len(results) != tt.largest {
+			t.Errorf("capacity %v: batch of %d returned %v with %d results, want %v", tt.capacity, tt.largest, rr.Code, len(results), http.StatusOK)
+		}
+	}
+}
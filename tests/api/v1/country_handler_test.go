@@ -0,0 +1,102 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	v1 "ip2country-service/api/v1"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+
+	"github.com/gorilla/mux"
+)
+
+// countryRouter serves the country ranges endpoint of db, which reads the
+// country code from the route
+func countryRouter(db database.IPDatabase) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/countries/{code}/ranges", v1.NewCountryHandler(db).GetCountryRanges).Methods(http.MethodGet)
+	return router
+}
+
+func TestGetCountryRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	content := "network,country,region,city\n" +
+		"10.0.0.0/24,US,California,Los Angeles\n" +
+		"10.0.1.0-10.0.2.255,US,Texas,Austin\n" +
+		"10.0.3.0/24,GB,England,London\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.NewIPDatabase(&config.Config{DatabaseType: "csv", DatabasePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := countryRouter(db)
+
+	get := func(url string) v1.CountryRanges {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s returned wrong status code: got %v want %v", url, rr.Code, http.StatusOK)
+		}
+		var page v1.CountryRanges
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("invalid response %q: %v", rr.Body, err)
+		}
+		return page
+	}
+
+	page := get("/countries/us/ranges?limit=1")
+	if page.Country != "US" || page.Total != 2 || len(page.Ranges) != 1 || page.NextOffset == nil || *page.NextOffset != 1 {
+		t.Fatalf("first page = %+v, want 1 of 2 US ranges and a next offset of 1", page)
+	}
+	if r := page.Ranges[0]; r.From != "10.0.0.0" || r.To != "10.0.0.255" || r.Region != "California" || r.City != "Los Angeles" {
+		t.Errorf("first range = %+v, want 10.0.0.0 - 10.0.0.255 in Los Angeles", r)
+	}
+	if page = get("/countries/US/ranges?limit=1&offset=1"); len(page.Ranges) != 1 || page.NextOffset != nil {
+		t.Errorf("last page = %+v, want 1 range and no next offset", page)
+	}
+
+	page = get("/countries/US/ranges?region=texas&format=cidr")
+	if page.Total != 1 || len(page.Ranges) != 1 {
+		t.Fatalf("Texas ranges = %+v, want 1", page)
+	}
+	if r := page.Ranges[0]; r.From != "" || !slices.Equal(r.Networks, []string{"10.0.1.0/24", "10.0.2.0/24"}) {
+		t.Errorf("Texas range = %+v, want it as 10.0.1.0/24 and 10.0.2.0/24", r)
+	}
+
+	if page = get("/countries/FR/ranges"); page.Total != 0 || page.Ranges == nil || len(page.Ranges) != 0 {
+		t.Errorf("FR ranges = %+v, want an empty list", page)
+	}
+}
+
+func TestGetCountryRanges_BadRequests(t *testing.T) {
+	router := countryRouter(database.FromLegacy(&mockDatabase{}))
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/countries/USA/ranges", http.StatusBadRequest},
+		{"/countries/1A/ranges", http.StatusBadRequest},
+		{"/countries/US/ranges?limit=0", http.StatusBadRequest},
+		{"/countries/US/ranges?limit=1001", http.StatusBadRequest},
+		{"/countries/US/ranges?offset=-1", http.StatusBadRequest},
+		{"/countries/US/ranges?format=xml", http.StatusBadRequest},
+		// The mock database can only look addresses up
+		{"/countries/US/ranges", http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if rr.Code != tt.want {
+			t.Errorf("GET %s returned wrong status code: got %v want %v", tt.url, rr.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "ip2country-service/api/v1"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGetDataset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ip_database.json")
+	content := `[{"ip_from": 167772160, "ip_to": 167772415, "country": "US", "region": "California", "city": "Los Angeles"}]`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sidecar := `{"vendor": "Example Geo", "version": "2026.10", "build_date": "2026-10-01"}`
+	if err := os.WriteFile(path+".meta.json", []byte(sidecar), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{DatabaseType: "json", DatabasePath: path}
+	db, err := database.NewIPDatabase(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := db.(database.Versioned).Version()
+
+	handler := v1.NewDatasetHandler(db)
+	rr := httptest.NewRecorder()
+	handler.GetDataset(rr, httptest.NewRequest(http.MethodGet, "/dataset", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var meta database.DatasetMetadata
+	if err := json.Unmarshal(rr.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("invalid response %q: %v", rr.Body, err)
+	}
+	if meta.Type != "json" || meta.Path != path || meta.Records != 1 || meta.Version != version || !strings.HasPrefix(meta.Checksum, version) {
+		t.Errorf("GetDataset() = %+v, want the JSON file at version %s", meta, version)
+	}
+	if meta.Vendor != "Example Geo" || meta.VendorVersion != "2026.10" || meta.BuildDate == nil || meta.LoadedAt == nil {
+		t.Errorf("GetDataset() = %+v, want the sidecar's vendor, version and build date", meta)
+	}
+
+	if labels := datasetInfoLabels(t); labels["version"] != version || labels["vendor"] != "Example Geo" || labels["build_date"] != "2026-10-01T00:00:00Z" {
+		t.Errorf("dataset_info labels = %v, want the served dataset", labels)
+	}
+
+	// Lookups say which dataset answered them
+	ipHandler := v1.NewIPHandler(db, cfg)
+	rr = httptest.NewRecorder()
+	ipHandler.GetLocation(rr, httptest.NewRequest(http.MethodGet, "/find-country?ip=10.0.0.1", nil))
+	if got := rr.Header().Get(v1.DatasetVersionHeader); got != version {
+		t.Errorf("%s = %q, want %q", v1.DatasetVersionHeader, got, version)
+	}
+}
+
+func TestGetDataset_NotDescribed(t *testing.T) {
+	handler := v1.NewDatasetHandler(database.FromLegacy(&mockDatabase{}))
+	rr := httptest.NewRecorder()
+	handler.GetDataset(rr, httptest.NewRequest(http.MethodGet, "/dataset", nil))
+	if rr.Code != http.StatusNotImplemented {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotImplemented)
+	}
+}
+
+// datasetInfoLabels returns the labels of the single dataset_info series
+func datasetInfoLabels(t *testing.T) map[string]string {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "dataset_info" {
+			continue
+		}
+		if len(family.GetMetric()) != 1 {
+			t.Fatalf("dataset_info has %d series, want 1", len(family.GetMetric()))
+		}
+		labels := make(map[string]string)
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		return labels
+	}
+	t.Fatal("dataset_info is not registered")
+	return nil
+}
@@ -1,15 +1,22 @@
 package v1_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	v1 "ip2country-service/api/v1"
 	"ip2country-service/config"
+	"ip2country-service/internal/auth"
+	"ip2country-service/internal/database"
 	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
 )
 
 type mockDatabase struct{}
@@ -26,7 +33,7 @@ func (m *mockDatabase) Find(ip string) (*models.Location, error) {
 }
 
 func TestGetLocation(t *testing.T) {
-	db := &mockDatabase{}
+	db := database.FromLegacy(&mockDatabase{})
 	cfg := &config.Config{
 		AllowedFields: []string{"country", "region", "city"},
 	}
@@ -71,3 +78,379 @@ func equal(a, b map[string]string) bool {
 	}
 	return true
 }
+
+type errorDatabase struct {
+	err error
+}
+
+func (m *errorDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	return nil, m.err
+}
+
+func TestGetLocation_ErrorStatuses(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		err  error
+		want int
+	}{
+		{"Invalid IP", "invalid_ip", nil, http.StatusBadRequest},
+		{"Not found", "10.0.0.1", &database.LookupError{Kind: database.KindNotFound, IP: "10.0.0.1"}, http.StatusNotFound},
+		{"Legacy not found", "10.0.0.1", utils.ErrIpNotFound, http.StatusNotFound},
+		{"Timeout", "10.0.0.1", &database.LookupError{Kind: database.KindTimeout, Err: context.DeadlineExceeded}, http.StatusGatewayTimeout},
+		{"Backend failure", "10.0.0.1", &database.LookupError{Kind: database.KindBackend, Err: errors.New("connection refused")}, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := v1.NewIPHandler(&errorDatabase{err: tt.err}, &config.Config{})
+			req := httptest.NewRequest(http.MethodGet, "/find-country?ip="+tt.ip, nil)
+			rr := httptest.NewRecorder()
+			handler.GetLocation(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+// slowDatabase blocks until the lookup context is done
+type slowDatabase struct{}
+
+func (m *slowDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	<-ctx.Done()
+	return nil, &database.LookupError{Kind: database.KindTimeout, IP: ip, Err: ctx.Err()}
+}
+
+func TestGetLocation_LookupTimeout(t *testing.T) {
+	handler := v1.NewIPHandler(&slowDatabase{}, &config.Config{LookupTimeout: 10 * time.Millisecond})
+	req := httptest.NewRequest(http.MethodGet, "/find-country?ip=10.0.0.1", nil)
+	rr := httptest.NewRecorder()
+	handler.GetLocation(rr, req)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestGetLocation_Me(t *testing.T) {
+	handler := v1.NewIPHandler(database.FromLegacy(&mockDatabase{}), &config.Config{})
+
+	for _, url := range []string{"/find-country", "/find-country?ip=me"} {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", url, rr.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestGetLocation_PlanAllowedFields(t *testing.T) {
+	handler := v1.NewIPHandler(database.FromLegacy(&mockDatabase{}), &config.Config{
+		AllowedFields: []string{"country", "region", "city"},
+	})
+	basic := &auth.Principal{Name: "initech", AllowedFields: []string{"country"}}
+
+	tests := []struct {
+		fields string
+		status int
+	}{
+		{"country", http.StatusOK},
+		{"city", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/find-country?ip=10.0.0.1&fields="+tt.fields, nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), basic))
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("fields=%s: expected status %d, got %d", tt.fields, tt.status, rr.Code)
+		}
+	}
+}
+
+// detailDatabase answers every lookup with a location that has optional details
+type detailDatabase struct{}
+
+func (m *detailDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	latitude, longitude := 51.5142, -0.0931
+	return &models.Location{Country: "GB", City: "London", Latitude: &latitude, Longitude: &longitude, TimeZone: "Europe/London", ASN: 64500}, nil
+}
+
+func TestGetLocation_OptionalFields(t *testing.T) {
+	handler := v1.NewIPHandler(&detailDatabase{}, &config.Config{AllowedFields: config.DefaultAllowedFields})
+
+	rr := httptest.NewRecorder()
+	handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	var full map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &full); err != nil {
+		t.Fatalf("could not parse response: %v", err)
+	}
+	if full["latitude"] != 51.5142 || full["asn"] != 64500.0 || full["timezone"] != "Europe/London" {
+		t.Errorf("expected the optional details in %v", full)
+	}
+	for _, absent := range []string{"region", "postal_code", "accuracy_radius", "organization"} {
+		if _, ok := full[absent]; ok {
+			t.Errorf("expected %s to be omitted from %v", absent, full)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1&fields=latitude,longitude", nil))
+	if rr.Body.String() != `{"latitude":51.5142,"longitude":-0.0931}` {
+		t.Errorf("expected only the coordinates, got %s", rr.Body.String())
+	}
+}
+
+// overriddenDatabase answers every lookup as if from the overrides file
+type overriddenDatabase struct{}
+
+func (m *overriddenDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	return &models.Location{Country: "GB", City: "London", Source: models.SourceOverride}, nil
+}
+
+func TestGetLocation_OverrideMarker(t *testing.T) {
+	handler := v1.NewIPHandler(&overriddenDatabase{}, &config.Config{AllowedFields: config.DefaultAllowedFields})
+
+	// The marker is kept even when it is not among the selected fields
+	rr := httptest.NewRecorder()
+	handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1&fields=country", nil))
+	if rr.Body.String() != `{"country":"GB","source":"override"}` {
+		t.Errorf("expected the country and the override marker, got %s", rr.Body.String())
+	}
+}
+
+// countingDatabase answers every lookup from one /24 and counts database queries
+type countingDatabase struct {
+	queries int
+}
+
+func (m *countingDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	loc, _, err := m.FindRange(ctx, ip)
+	return loc, err
+}
+
+func (m *countingDatabase) FindRange(ctx context.Context, ip string) (*models.Location, database.Range, error) {
+	m.queries++
+	return &models.Location{Country: "US"}, database.Range{From: database.IPv4Number(167772160), To: database.IPv4Number(167772415)}, nil
+}
+
+func TestGetLocation_RangeCache(t *testing.T) {
+	db := &countingDatabase{}
+	handler := v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10})
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.255"} {
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip="+ip, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GetLocation(%s) returned %d", ip, rr.Code)
+		}
+	}
+
+	// Every address in the matched /24 is answered from the first lookup's cache entry
+	if db.queries != 1 {
+		t.Errorf("expected 1 database query, got %d", db.queries)
+	}
+	if handler.Cache().Len() != 1 {
+		t.Errorf("expected 1 cache entry, got %d", handler.Cache().Len())
+	}
+}
+
+// gatedDatabase holds every lookup until release is closed, counting queries
+type gatedDatabase struct {
+	queries atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (m *gatedDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	m.queries.Add(1)
+	<-m.release
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &models.Location{Country: "US"}, nil
+}
+
+func TestGetLocation_NegativeCache(t *testing.T) {
+	db := &gatedDatabase{release: make(chan struct{}), err: utils.ErrIpNotFound}
+	close(db.release)
+	handler := v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10, CacheNegativeTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=192.0.2.1", nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("request %d: expected 404, got %d", i, rr.Code)
+		}
+	}
+	if got := db.queries.Load(); got != 1 {
+		t.Errorf("expected the not-found answer to be cached after 1 query, got %d queries", got)
+	}
+
+	// Without a negative TTL every miss reaches the database
+	db = &gatedDatabase{release: db.release, err: utils.ErrIpNotFound}
+	handler = v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10})
+	for i := 0; i < 2; i++ {
+		handler.GetLocation(httptest.NewRecorder(), httptest.NewRequest("GET", "/find-country?ip=192.0.2.1", nil))
+	}
+	if got := db.queries.Load(); got != 2 {
+		t.Errorf("expected 2 queries without negative caching, got %d", got)
+	}
+}
+
+func TestGetLocation_PurgedDuringLookup(t *testing.T) {
+	db := &gatedDatabase{release: make(chan struct{})}
+	handler := v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10})
+
+	done := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil))
+		done <- rr.Code
+	}()
+	for db.queries.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A reload purges the cache while the lookup is reading the old dataset
+	handler.Cache().Purge()
+	close(db.release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	if n := handler.Cache().Len(); n != 0 {
+		t.Errorf("expected the answer from before the purge not to be cached, got %d entries", n)
+	}
+}
+
+func TestGetLocation_CoalescesConcurrentMisses(t *testing.T) {
+	db := &gatedDatabase{release: make(chan struct{})}
+	handler := v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10, LookupTimeout: time.Second})
+
+	const clients = 5
+	codes := make(chan int, clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil))
+			codes <- rr.Code
+		}()
+	}
+
+	// Let every client reach the shared query before it completes
+	time.Sleep(50 * time.Millisecond)
+	close(db.release)
+	for i := 0; i < clients; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("expected 200, got %d", code)
+		}
+	}
+	if got := db.queries.Load(); got != 1 {
+		t.Errorf("expected concurrent misses to share 1 query, got %d", got)
+	}
+}
+
+func TestGetLocation_FirstCallerGoesAway(t *testing.T) {
+	// Without a LookupTimeout the shared query must still outlive the caller that started it
+	db := &ctxGatedDatabase{release: make(chan struct{})}
+	handler := v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil).WithContext(ctx))
+		first <- rr.Code
+	}()
+	for db.queries.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil))
+		second <- rr.Code
+	}()
+
+	// Let the second caller join the shared query, then disconnect the first
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-first
+	close(db.release)
+	if code := <-second; code != http.StatusOK {
+		t.Errorf("expected the waiting caller to get 200, got %d", code)
+	}
+	if got := db.queries.Load(); got != 1 {
+		t.Errorf("expected the callers to share 1 query, got %d", got)
+	}
+}
+
+// ctxGatedDatabase holds every lookup until release is closed, failing it
+// if its context is cancelled first. cancelled, if set, is closed then.
+type ctxGatedDatabase struct {
+	queries   atomic.Int32
+	release   chan struct{}
+	cancelled chan struct{}
+}
+
+func (m *ctxGatedDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	m.queries.Add(1)
+	select {
+	case <-m.release:
+		return &models.Location{Country: "US"}, nil
+	case <-ctx.Done():
+		if m.cancelled != nil {
+			close(m.cancelled)
+		}
+		return nil, fmt.Errorf("%w: %v", utils.ErrDatabaseTimeout, ctx.Err())
+	}
+}
+
+func TestGetLocation_LastCallerGoesAway(t *testing.T) {
+	// Without a LookupTimeout the shared query stops once nobody waits for it
+	db := &ctxGatedDatabase{release: make(chan struct{}), cancelled: make(chan struct{})}
+	handler := v1.NewIPHandler(db, &config.Config{CacheMaxEntries: 10})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil).WithContext(ctx))
+			done <- rr.Code
+		}()
+	}
+	for db.queries.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	<-done
+
+	select {
+	case <-db.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the query to be cancelled once every caller went away")
+	}
+
+	// A later caller starts a new query rather than joining the cancelled one
+	close(db.release)
+	rr := httptest.NewRecorder()
+	handler.GetLocation(rr, httptest.NewRequest("GET", "/find-country?ip=10.0.0.1", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected a new caller to get 200, got %d", rr.Code)
+	}
+	if got := db.queries.Load(); got != 2 {
+		t.Errorf("expected 2 queries, got %d", got)
+	}
+}
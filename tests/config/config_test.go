@@ -2,7 +2,9 @@ package config_test
 
 import (
 	"os"
+	"slices"
 	"testing"
+	"time"
 
 	"ip2country-service/config"
 )
@@ -19,6 +21,25 @@ func TestLoadConfig(t *testing.T) {
 	os.Setenv("REDIS_ADDR", "customhost:6379")
 	os.Setenv("REDIS_PASSWORD", "custompassword")
 	os.Setenv("REDIS_DB", "1")
+	os.Setenv("DATABASE_RELOAD_INTERVAL", "60")
+	os.Setenv("ADMIN_TOKEN", "secret")
+	os.Setenv("LOOKUP_TIMEOUT", "250")
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 2001:db8::/32")
+	os.Setenv("API_KEYS_FILE", "/etc/ip2country/keys.json")
+	os.Setenv("API_KEYS_REDIS", "true")
+	os.Setenv("ANONYMOUS_ACCESS", "false")
+	os.Setenv("ANONYMOUS_RATE_CAPACITY", "2")
+	os.Setenv("HTTP_READ_HEADER_TIMEOUT", "2")
+	os.Setenv("HTTP_MAX_HEADER_BYTES", "8192")
+	os.Setenv("SHUTDOWN_DRAIN_PERIOD", "0")
+	os.Setenv("CACHE_MAX_ENTRIES", "500")
+	os.Setenv("CACHE_TTL", "60")
+	os.Setenv("REDIS_CACHE", "true")
+	os.Setenv("ALLOWED_FIELDS", "country, city")
+	os.Setenv("CSV_LAYOUT", "dbip")
+	os.Setenv("CSV_COLUMNS", "asn=8, organization=9")
+	os.Setenv("DATASET_VALIDATION", "strict")
+	os.Setenv("LOOKUP_INDEX", "trie")
 
 	// Load the configuration
 	config := config.LoadConfig()
@@ -28,7 +49,7 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("Expected Port to be '9090', got '%s'", config.Port)
 	}
 	if config.RateLimit != 10 {
-		t.Errorf("Expected RateLimit to be 10, got %d", config.RateLimit)
+		t.Errorf("Expected RateLimit to be 10, got %v", config.RateLimit)
 	}
 	if config.DatabaseType != "mongodb" {
 		t.Errorf("Expected DatabaseType to be 'mongodb', got '%s'", config.DatabaseType)
@@ -57,6 +78,81 @@ func TestLoadConfig(t *testing.T) {
 	if len(config.AllowedFields) != 2 || config.AllowedFields[0] != "country" || config.AllowedFields[1] != "city" {
 		t.Errorf("Expected AllowedFields to be ['country', 'city'], got %v", config.AllowedFields)
 	}
+	if config.ReloadInterval != time.Minute {
+		t.Errorf("Expected ReloadInterval to be 1m, got %v", config.ReloadInterval)
+	}
+	if config.AdminToken != "secret" {
+		t.Errorf("Expected AdminToken to be 'secret', got '%s'", config.AdminToken)
+	}
+	if config.LookupTimeout != 250*time.Millisecond {
+		t.Errorf("Expected LookupTimeout to be 250ms, got %v", config.LookupTimeout)
+	}
+	if len(config.TrustedProxies) != 2 || config.TrustedProxies[0] != "10.0.0.0/8" || config.TrustedProxies[1] != "2001:db8::/32" {
+		t.Errorf("Expected TrustedProxies to be ['10.0.0.0/8', '2001:db8::/32'], got %v", config.TrustedProxies)
+	}
+	if config.APIKeysFile != "/etc/ip2country/keys.json" {
+		t.Errorf("Expected APIKeysFile to be '/etc/ip2country/keys.json', got '%s'", config.APIKeysFile)
+	}
+	if !config.APIKeysRedis {
+		t.Errorf("Expected APIKeysRedis to be true")
+	}
+	if config.AnonymousAccess {
+		t.Errorf("Expected AnonymousAccess to be false")
+	}
+	if config.AnonymousRateLimit != 10 {
+		t.Errorf("Expected AnonymousRateLimit to default to RateLimit 10, got %v", config.AnonymousRateLimit)
+	}
+	if config.AnonymousRateCapacity != 2 {
+		t.Errorf("Expected AnonymousRateCapacity to be 2, got %v", config.AnonymousRateCapacity)
+	}
+	if len(config.AnonymousAllowedFields) != 2 || config.AnonymousAllowedFields[0] != "country" {
+		t.Errorf("Expected AnonymousAllowedFields to default to AllowedFields, got %v", config.AnonymousAllowedFields)
+	}
+	if config.ReadHeaderTimeout != 2*time.Second {
+		t.Errorf("Expected ReadHeaderTimeout to be 2s, got %v", config.ReadHeaderTimeout)
+	}
+	if config.ReadTimeout != 10*time.Second || config.WriteTimeout != 30*time.Second || config.IdleTimeout != 120*time.Second {
+		t.Errorf("Expected default read/write/idle timeouts of 10s/30s/2m, got %v/%v/%v", config.ReadTimeout, config.WriteTimeout, config.IdleTimeout)
+	}
+	if config.MaxHeaderBytes != 8192 {
+		t.Errorf("Expected MaxHeaderBytes to be 8192, got %d", config.MaxHeaderBytes)
+	}
+	if config.ShutdownDrainPeriod != 0 {
+		t.Errorf("Expected ShutdownDrainPeriod to be 0, got %v", config.ShutdownDrainPeriod)
+	}
+	if config.ShutdownTimeout != 20*time.Second {
+		t.Errorf("Expected ShutdownTimeout to default to 20s, got %v", config.ShutdownTimeout)
+	}
+	if config.CacheMaxEntries != 500 {
+		t.Errorf("Expected CacheMaxEntries to be 500, got %d", config.CacheMaxEntries)
+	}
+	if config.CacheMaxBytes != 64<<20 {
+		t.Errorf("Expected CacheMaxBytes to default to 64MiB, got %d", config.CacheMaxBytes)
+	}
+	if config.CacheNegativeTTL != time.Minute {
+		t.Errorf("Expected CacheNegativeTTL to default to 1m, got %v", config.CacheNegativeTTL)
+	}
+	if config.CacheTTL != time.Minute {
+		t.Errorf("Expected CacheTTL to be 1m, got %v", config.CacheTTL)
+	}
+	if !config.RedisCache {
+		t.Errorf("Expected RedisCache to be true")
+	}
+	if config.CSVDelimiter != "," || config.CSVHeader != "auto" {
+		t.Errorf("Expected CSV delimiter and header to default to , and auto, got %q and %q", config.CSVDelimiter, config.CSVHeader)
+	}
+	if config.CSVLayout != "dbip" || len(config.CSVColumns) != 2 || config.CSVColumns[1] != "organization=9" {
+		t.Errorf("Expected the dbip CSV layout with 2 extra columns, got %q and %v", config.CSVLayout, config.CSVColumns)
+	}
+	if config.RedisCacheTimeout != 50*time.Millisecond {
+		t.Errorf("Expected RedisCacheTimeout to default to 50ms, got %v", config.RedisCacheTimeout)
+	}
+	if config.DatasetValidation != "strict" {
+		t.Errorf("Expected DatasetValidation to be strict, got %q", config.DatasetValidation)
+	}
+	if config.LookupIndex != "trie" {
+		t.Errorf("Expected LookupIndex to be trie, got %q", config.LookupIndex)
+	}
 
 	// Clean up environment variables
 	os.Unsetenv("PORT")
@@ -69,4 +165,33 @@ func TestLoadConfig(t *testing.T) {
 	os.Unsetenv("REDIS_ADDR")
 	os.Unsetenv("REDIS_PASSWORD")
 	os.Unsetenv("REDIS_DB")
+	os.Unsetenv("DATABASE_RELOAD_INTERVAL")
+	os.Unsetenv("ADMIN_TOKEN")
+	os.Unsetenv("LOOKUP_TIMEOUT")
+	os.Unsetenv("TRUSTED_PROXIES")
+	os.Unsetenv("API_KEYS_FILE")
+	os.Unsetenv("API_KEYS_REDIS")
+	os.Unsetenv("ANONYMOUS_ACCESS")
+	os.Unsetenv("ANONYMOUS_RATE_CAPACITY")
+	os.Unsetenv("HTTP_READ_HEADER_TIMEOUT")
+	os.Unsetenv("HTTP_MAX_HEADER_BYTES")
+	os.Unsetenv("SHUTDOWN_DRAIN_PERIOD")
+	os.Unsetenv("CACHE_MAX_ENTRIES")
+	os.Unsetenv("CACHE_TTL")
+	os.Unsetenv("REDIS_CACHE")
+	os.Unsetenv("ALLOWED_FIELDS")
+	os.Unsetenv("CSV_LAYOUT")
+	os.Unsetenv("CSV_COLUMNS")
+	os.Unsetenv("DATASET_VALIDATION")
+	os.Unsetenv("LOOKUP_INDEX")
+}
+
+func TestLoadConfig_DefaultAllowedFields(t *testing.T) {
+	os.Unsetenv("ALLOWED_FIELDS")
+	cfg := config.LoadConfig()
+	for _, field := range []string{"country", "city", "latitude", "longitude", "accuracy_radius", "postal_code", "timezone", "asn", "organization"} {
+		if !slices.Contains(cfg.AllowedFields, field) {
+			t.Errorf("Expected %s to be allowed by default, got %v", field, cfg.AllowedFields)
+		}
+	}
 }
@@ -0,0 +1,69 @@
+package auth_test
+
+import (
+	"ip2country-service/config"
+	"ip2country-service/internal/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	store, err := auth.NewFileKeyStore(writeKeyFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous := auth.AnonymousPrincipal(&config.Config{AnonymousAccess: true, AnonymousRateLimit: 0.1})
+
+	tests := []struct {
+		name      string
+		anonymous *auth.Principal
+		header    string
+		query     string
+		status    int
+		principal string
+	}{
+		{"key in header", anonymous, "gold-key", "", http.StatusOK, "acme"},
+		{"key in query", anonymous, "", "?api_key=basic-key", http.StatusOK, "initech"},
+		{"no key with anonymous access", anonymous, "", "", http.StatusOK, "anonymous"},
+		{"no key without anonymous access", nil, "", "", http.StatusUnauthorized, ""},
+		{"unknown key", anonymous, "nope", "", http.StatusUnauthorized, ""},
+		{"disabled key", anonymous, "revoked-key", "", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *auth.Principal
+			handler := auth.NewAuthenticator(store, tt.anonymous).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = auth.FromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/api/v1/find-country"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set(auth.APIKeyHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rr.Code)
+			}
+			if tt.principal == "" {
+				if got != nil {
+					t.Errorf("expected the request to be rejected, reached handler as %+v", got)
+				}
+			} else if got == nil || got.Name != tt.principal {
+				t.Errorf("expected principal %s, got %+v", tt.principal, got)
+			}
+			if tt.status == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
+
+func TestRedactedURI(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/find-country?ip=8.8.8.8&api_key=gold-key", nil)
+	if got := auth.RedactedURI(req); got != "/api/v1/find-country?api_key=REDACTED&ip=8.8.8.8" {
+		t.Errorf("unexpected redacted URI %s", got)
+	}
+}
@@ -0,0 +1,72 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"ip2country-service/internal/auth"
+	"ip2country-service/pkg/utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKeyFile writes an API key file with a full plan, a default plan and a disabled key
+func writeKeyFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"keys": [
+		{"key": "gold-key", "name": "acme", "rate_limit": 50, "rate_capacity": 100, "allowed_fields": ["country", "region", "city"]},
+		{"key": "basic-key", "name": "initech"},
+		{"key": "revoked-key", "name": "globex", "disabled": true}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileKeyStore(t *testing.T) {
+	store, err := auth.NewFileKeyStore(writeKeyFile(t))
+	if err != nil {
+		t.Fatalf("NewFileKeyStore returned error: %v", err)
+	}
+
+	principal, err := store.Lookup(context.Background(), "gold-key")
+	if err != nil {
+		t.Fatalf("Lookup returned error: %v", err)
+	}
+	if principal.Name != "acme" || principal.RateLimit != 50 || principal.RateCapacity != 100 || len(principal.AllowedFields) != 3 {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+
+	principal, err = store.Lookup(context.Background(), "basic-key")
+	if err != nil {
+		t.Fatalf("Lookup returned error: %v", err)
+	}
+	if principal.RateLimit != 0 || principal.AllowedFields != nil {
+		t.Errorf("expected a key without a plan to use the defaults, got %+v", principal)
+	}
+
+	if _, err := store.Lookup(context.Background(), "unknown"); !errors.Is(err, utils.ErrInvalidAPIKey) {
+		t.Errorf("expected ErrInvalidAPIKey for an unknown key, got %v", err)
+	}
+}
+
+func TestFileKeyStore_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"malformed":     `{"keys": [`,
+		"missing name":  `{"keys": [{"key": "k1"}]}`,
+		"duplicate key": `{"keys": [{"key": "k1", "name": "a"}, {"key": "k1", "name": "b"}]}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := auth.NewFileKeyStore(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
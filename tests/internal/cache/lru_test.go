@@ -0,0 +1,146 @@
+package cache_test
+
+import (
+	"ip2country-service/internal/cache"
+	"ip2country-service/internal/database"
+	"testing"
+	"time"
+)
+
+// span returns the range from-to of IPv4 addresses given as integers
+func span(from, to uint32) database.Range {
+	return database.Range{From: database.IPv4Number(from), To: database.IPv4Number(to)}
+}
+
+func TestLRU_RangeLookup(t *testing.T) {
+	c := cache.NewLRU(10, 0, 0)
+	c.Set(span(100, 199), "a", 0)
+	c.Set(span(300, 300), "b", 0)
+
+	lookups := map[uint32]interface{}{99: nil, 100: "a", 150: "a", 199: "a", 200: nil, 299: nil, 300: "b", 301: nil}
+	for ip, want := range lookups {
+		got, ok := c.Get(database.IPv4Number(ip))
+		if ok != (want != nil) || got != want {
+			t.Errorf("Get(%d) = %v, %v; want %v", ip, got, ok, want)
+		}
+	}
+
+	// IPv6 addresses never fall inside IPv4 ranges
+	if _, ok := c.Get(database.IPNumber{Hi: 1, Lo: 150}); ok {
+		t.Error("expected an IPv6 address to miss")
+	}
+}
+
+func TestLRU_ReplacesOverlappingRanges(t *testing.T) {
+	c := cache.NewLRU(10, 0, 0)
+	c.Set(span(100, 199), "a", 0)
+	c.Set(span(200, 299), "b", 0)
+	c.Set(span(400, 499), "c", 0)
+
+	// A range spanning the first two replaces both
+	c.Set(span(150, 250), "d", 0)
+
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+	for ip, want := range map[uint32]interface{}{120: nil, 150: "d", 250: "d", 280: nil, 450: "c"} {
+		got, ok := c.Get(database.IPv4Number(ip))
+		if ok != (want != nil) || got != want {
+			t.Errorf("Get(%d) = %v, %v; want %v", ip, got, ok, want)
+		}
+	}
+}
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := cache.NewLRU(2, 0, 0)
+	c.Set(span(0, 9), "a", 0)
+	c.Set(span(10, 19), "b", 0)
+
+	// Reading a makes b the least recently used entry
+	if v, ok := c.Get(database.IPv4Number(5)); !ok || v != "a" {
+		t.Fatalf("Get(5) = %v, %v; want a, true", v, ok)
+	}
+	c.Set(span(20, 29), "c", 0)
+
+	if _, ok := c.Get(database.IPv4Number(15)); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, ip := range []uint32{5, 25} {
+		if _, ok := c.Get(database.IPv4Number(ip)); !ok {
+			t.Errorf("expected %d to be cached", ip)
+		}
+	}
+	if c.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", c.Len())
+	}
+}
+
+func TestLRU_MemoryBound(t *testing.T) {
+	// Each entry costs its value size plus bookkeeping overhead
+	c := cache.NewLRU(0, 2000, 0)
+	for i := uint32(0); i < 100; i++ {
+		c.Set(span(i*10, i*10+9), i, 100)
+	}
+
+	if c.Bytes() > 2000 {
+		t.Errorf("Bytes() = %d, want at most 2000", c.Bytes())
+	}
+	if c.Len() == 0 || c.Len() >= 100 {
+		t.Errorf("Len() = %d, want some but not all entries", c.Len())
+	}
+	if _, ok := c.Get(database.IPv4Number(995)); !ok {
+		t.Error("expected the newest entry to be cached")
+	}
+
+	// Values larger than the whole cache are not stored
+	c.Set(span(5000, 5000), 0, 4000)
+	if _, ok := c.Get(database.IPv4Number(5000)); ok {
+		t.Error("expected an oversized value to be rejected")
+	}
+}
+
+func TestLRU_TTL(t *testing.T) {
+	c := cache.NewLRU(10, 0, 20*time.Millisecond)
+	c.Set(span(0, 9), "a", 0)
+	if _, ok := c.Get(database.IPv4Number(1)); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get(database.IPv4Number(1)); ok {
+		t.Error("expected a to expire")
+	}
+	if c.Len() != 0 {
+		t.Errorf("Len() = %d, want expired entry dropped", c.Len())
+	}
+}
+
+func TestLRU_Purge(t *testing.T) {
+	c := cache.NewLRU(10, 0, 0)
+	c.Set(span(0, 9), "a", 10)
+	c.Set(span(10, 19), "b", 10)
+
+	if n := c.Purge(); n != 2 {
+		t.Errorf("Purge() = %d, want 2", n)
+	}
+	if c.Len() != 0 || c.Bytes() != 0 {
+		t.Errorf("expected an empty cache, got %d entries and %d bytes", c.Len(), c.Bytes())
+	}
+}
+
+func TestLRU_SetIfGeneration(t *testing.T) {
+	c := cache.NewLRU(10, 0, 0)
+	gen := c.Generation()
+	if !c.SetIfGeneration(gen, span(0, 9), "a", 0, 0) {
+		t.Error("SetIfGeneration() refused a value read since the last purge")
+	}
+
+	// A value read before a purge belongs to the data the purge discarded
+	c.Purge()
+	if c.SetIfGeneration(gen, span(10, 19), "b", 0, 0) {
+		t.Error("SetIfGeneration() stored a value read before the purge")
+	}
+	if c.Len() != 0 {
+		t.Errorf("expected an empty cache, got %d entries", c.Len())
+	}
+}
@@ -0,0 +1,158 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"ip2country-service/config"
+	"ip2country-service/internal/cache"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// rangeDatabase serves US for 10.0.0.0/24 and counts the lookups that reach it
+type rangeDatabase struct {
+	queries atomic.Int32
+}
+
+func (d *rangeDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	loc, _, err := d.FindRange(ctx, ip)
+	return loc, err
+}
+
+func (d *rangeDatabase) FindRange(_ context.Context, ip string) (*models.Location, database.Range, error) {
+	d.queries.Add(1)
+	matched := span(167772160, 167772415)
+	if ipNum, err := database.IPToNumber(net.ParseIP(ip)); err == nil && matched.Contains(ipNum) {
+		return &models.Location{Country: "US", City: "Los Angeles"}, matched, nil
+	}
+	return nil, database.Range{}, &database.LookupError{Kind: database.KindNotFound, Op: "rangeDatabase.Find", IP: ip}
+}
+
+func newRedisTier(t *testing.T, db database.IPDatabase, addr string) *cache.RedisTier {
+	t.Helper()
+	cfg := &config.Config{CacheTTL: time.Minute, CacheNegativeTTL: time.Minute, RedisCacheTimeout: 100 * time.Millisecond}
+	tier := cache.NewRedisTier(db, redis.NewClient(&redis.Options{Addr: addr}), cfg)
+	t.Cleanup(func() { tier.Close() })
+	return tier
+}
+
+func TestRedisTier_SharesLookupsBetweenReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &rangeDatabase{}
+	first, second := newRedisTier(t, db, mr.Addr()), newRedisTier(t, db, mr.Addr())
+
+	loc, matched, err := first.FindRange(context.Background(), "10.0.0.1")
+	if err != nil || loc.Country != "US" || matched != span(167772160, 167772415) {
+		t.Fatalf("FindRange() = %v, %v, %v; want US for 10.0.0.0/24", loc, matched, err)
+	}
+
+	// Another replica is answered from Redis, range included
+	loc, matched, err = second.FindRange(context.Background(), "10.0.0.1")
+	if err != nil || loc.Country != "US" || loc.City != "Los Angeles" || matched != span(167772160, 167772415) {
+		t.Errorf("FindRange() from Redis = %v, %v, %v; want US for 10.0.0.0/24", loc, matched, err)
+	}
+	if n := db.queries.Load(); n != 1 {
+		t.Errorf("database queried %d times, want 1", n)
+	}
+
+	// Not-found answers are shared too
+	for _, tier := range []*cache.RedisTier{first, second} {
+		if _, err := tier.Find(context.Background(), "192.168.0.1"); !errors.Is(err, utils.ErrIpNotFound) {
+			t.Errorf("Find() error = %v, want ErrIpNotFound", err)
+		}
+	}
+	if n := db.queries.Load(); n != 2 {
+		t.Errorf("database queried %d times, want 2", n)
+	}
+}
+
+func TestRedisTier_DatasetVersionInvalidates(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	write := func(country string) {
+		content := `[{"ip_from": 167772160, "ip_to": 167772415, "country": "` + country + `"}]`
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("US")
+	db, err := database.NewReloadableDatabase(path, database.LocalLoader("json", database.LoadOptions{}))
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	tier := newRedisTier(t, db, mr.Addr())
+
+	if loc, err := tier.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" {
+		t.Fatalf("Find() = %v, %v; want US", loc, err)
+	}
+
+	write("CA")
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if loc, err := tier.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "CA" {
+		t.Errorf("Find() after reload = %v, %v; want CA", loc, err)
+	}
+}
+
+func TestRedisTier_FailsOpen(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &rangeDatabase{}
+	tier := newRedisTier(t, db, mr.Addr())
+	mr.Close()
+
+	for i := 0; i < 3; i++ {
+		if loc, err := tier.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" {
+			t.Fatalf("Find() with Redis down = %v, %v; want US from the database", loc, err)
+		}
+	}
+	if n := db.queries.Load(); n != 3 {
+		t.Errorf("database queried %d times, want 3", n)
+	}
+}
+
+func TestRedisTier_Purge(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &rangeDatabase{}
+	tier := newRedisTier(t, db, mr.Addr())
+	mr.Set("unrelated", "kept")
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "192.168.0.1"} {
+		tier.Find(context.Background(), ip)
+	}
+	purged, err := tier.Purge(context.Background())
+	if err != nil || purged != 3 {
+		t.Errorf("Purge() = %d, %v; want 3", purged, err)
+	}
+	if !mr.Exists("unrelated") {
+		t.Error("Purge() deleted a key it did not write")
+	}
+
+	tier.Find(context.Background(), "10.0.0.1")
+	if n := db.queries.Load(); n != 4 {
+		t.Errorf("database queried %d times, want 4 after purge", n)
+	}
+}
+
+func TestRedisTier_Unwrap(t *testing.T) {
+	mr := miniredis.RunT(t)
+	db := &rangeDatabase{}
+	tier := newRedisTier(t, db, mr.Addr())
+
+	if found, ok := database.As[*rangeDatabase](tier); !ok || found != db {
+		t.Errorf("As() = %v, %v; want the wrapped database", found, ok)
+	}
+	if _, ok := database.As[database.Reloader](tier); ok {
+		t.Error("As() found a Reloader the chain does not contain")
+	}
+}
@@ -0,0 +1,83 @@
+package client_ip_test
+
+import (
+	"ip2country-service/internal/client_ip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	resolver, err := client_ip.NewResolver([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("NewResolver() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"Direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"Untrusted peer ignores headers", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"X-Forwarded-For", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"X-Forwarded-For skips trusted hops", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"X-Forwarded-For all trusted", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "10.2.2.2, 10.9.9.9"}, "10.2.2.2"},
+		{"X-Real-IP", "192.0.2.1:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"Forwarded", "10.1.2.3:5000", map[string]string{"Forwarded": `for=198.51.100.3;proto=https, for=10.9.9.9`}, "198.51.100.3"},
+		{"Forwarded IPv6 with port", "[2001:db8::5]:5000", map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`}, "2001:db8:cafe::17"},
+		{"Forwarded takes precedence", "10.1.2.3:5000", map[string]string{"Forwarded": "for=198.51.100.3", "X-Forwarded-For": "198.51.100.1"}, "198.51.100.3"},
+		{"Obfuscated hop", "10.1.2.3:5000", map[string]string{"Forwarded": "for=_hidden, for=10.9.9.9"}, "10.9.9.9"},
+		{"Malformed X-Forwarded-For", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "not-an-ip"}, "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+
+			got, err := resolver.Resolve(req)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResolver_Invalid(t *testing.T) {
+	for _, entry := range []string{"10.0.0.0/33", "proxy.local"} {
+		if _, err := client_ip.NewResolver([]string{entry}); err == nil {
+			t.Errorf("NewResolver(%q) expected error", entry)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	resolver, _ := client_ip.NewResolver([]string{"10.0.0.0/8"})
+
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = client_ip.FromRequest(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "198.51.100.1" {
+		t.Errorf("FromRequest() = %s, want 198.51.100.1", got)
+	}
+
+	// Without the middleware only the peer address is used
+	if ip, err := client_ip.FromRequest(req); err != nil || ip != "10.1.2.3" {
+		t.Errorf("FromRequest() without middleware = %s, %v; want 10.1.2.3", ip, err)
+	}
+}
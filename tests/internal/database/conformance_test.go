@@ -0,0 +1,328 @@
+package database_test
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// conformanceLocations is the dataset every backend is loaded with before
+// running the conformance suite
+func conformanceLocations(t *testing.T) []database.IPLocation {
+	return []database.IPLocation{
+		{IPFrom: database.IPv4Number(167772160), IPTo: database.IPv4Number(167772415), Country: "US", Region: "California", City: "Los Angeles"},
+		{IPFrom: database.IPv4Number(167776768), IPTo: database.IPv4Number(167777023), Country: "GB", Region: "England", City: "London"},
+		{IPFrom: mustParseIPNumber(t, "42540766411282592856903984951653826560"), IPTo: mustParseIPNumber(t, "42540766490510755371168322545197776895"), Country: "DE", Region: "Hesse", City: "Frankfurt"},
+	}
+}
+
+// runConformanceSuite checks the behaviour every IPDatabase backend must share
+func runConformanceSuite(t *testing.T, db database.IPDatabase) {
+	found := []struct {
+		ip   string
+		want models.Location
+	}{
+		{"10.0.0.1", models.Location{Country: "US", Region: "California", City: "Los Angeles"}},
+		{"10.0.0.0", models.Location{Country: "US", Region: "California", City: "Los Angeles"}},
+		{"10.0.0.255", models.Location{Country: "US", Region: "California", City: "Los Angeles"}},
+		{"::ffff:10.0.0.1", models.Location{Country: "US", Region: "California", City: "Los Angeles"}},
+		{"10.0.18.7", models.Location{Country: "GB", Region: "England", City: "London"}},
+		{"2001:db8::1", models.Location{Country: "DE", Region: "Hesse", City: "Frankfurt"}},
+		{"2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", models.Location{Country: "DE", Region: "Hesse", City: "Frankfurt"}},
+	}
+	for _, tt := range found {
+		t.Run("found/"+tt.ip, func(t *testing.T) {
+			got, err := db.Find(context.Background(), tt.ip)
+			if err != nil {
+				t.Fatalf("Find() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("Find() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Every backend reports the dataset range it matched, so callers can cache it
+	locations := conformanceLocations(t)
+	ranges := []struct {
+		ip   string
+		want database.Range
+	}{
+		{"10.0.0.1", database.Range{From: locations[0].IPFrom, To: locations[0].IPTo}},
+		{"::ffff:10.0.0.255", database.Range{From: locations[0].IPFrom, To: locations[0].IPTo}},
+		{"10.0.18.7", database.Range{From: locations[1].IPFrom, To: locations[1].IPTo}},
+		{"2001:db8::1", database.Range{From: locations[2].IPFrom, To: locations[2].IPTo}},
+	}
+	for _, tt := range ranges {
+		t.Run("range/"+tt.ip, func(t *testing.T) {
+			_, got, err := database.FindRange(context.Background(), db, tt.ip)
+			if err != nil {
+				t.Fatalf("FindRange() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("FindRange() range = %s - %s, want %s - %s", got.From, got.To, tt.want.From, tt.want.To)
+			}
+		})
+	}
+
+	failures := []struct {
+		ip       string
+		kind     database.ErrorKind
+		sentinel error
+	}{
+		{"10.0.1.0", database.KindNotFound, utils.ErrIpNotFound},
+		{"9.255.255.255", database.KindNotFound, utils.ErrIpNotFound},
+		{"255.255.255.255", database.KindNotFound, utils.ErrIpNotFound},
+		{"2001:db9::1", database.KindNotFound, utils.ErrIpNotFound},
+		{"not-an-ip", database.KindInvalidInput, utils.ErrInvalidIP},
+		{"", database.KindInvalidInput, utils.ErrInvalidIP},
+	}
+	for _, tt := range failures {
+		t.Run(fmt.Sprintf("%s/%q", tt.kind, tt.ip), func(t *testing.T) {
+			got, err := db.Find(context.Background(), tt.ip)
+			if err == nil {
+				t.Fatalf("Find() = %+v, want %s error", got, tt.kind)
+			}
+			if kind := database.KindOf(err); kind != tt.kind {
+				t.Errorf("KindOf(%v) = %s, want %s", err, kind, tt.kind)
+			}
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.sentinel)
+			}
+			var lookupErr *database.LookupError
+			if !errors.As(err, &lookupErr) {
+				t.Errorf("Find() error %T is not a *database.LookupError", err)
+			}
+		})
+	}
+}
+
+func TestConformance_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"ip_from", "ip_to", "country", "region", "city"})
+	for _, loc := range conformanceLocations(t) {
+		writer.Write([]string{loc.IPFrom.String(), loc.IPTo.String(), loc.Country, loc.Region, loc.City})
+	}
+	writer.Flush()
+	file.Close()
+
+	db, err := database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	runConformanceSuite(t, db)
+}
+
+func TestConformance_JSON(t *testing.T) {
+	path := writeJSONFixture(t, conformanceLocations(t))
+	db, err := database.NewJSONDatabase(path)
+	if err != nil {
+		t.Fatalf("NewJSONDatabase() error = %v", err)
+	}
+	runConformanceSuite(t, db)
+}
+
+func TestConformance_Reloadable(t *testing.T) {
+	db, err := database.NewReloadableDatabase(writeJSONFixture(t, conformanceLocations(t)), loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	runConformanceSuite(t, db)
+}
+
+func TestConformance_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.snap")
+	if err := database.WriteSnapshot(path, conformanceLocations(t)); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	db, err := database.NewSnapshotDatabase(path)
+	if err != nil {
+		t.Fatalf("NewSnapshotDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	runConformanceSuite(t, db)
+}
+
+func TestConformance_Trie(t *testing.T) {
+	runConformanceSuite(t, database.NewTrieDatabase(database.DatabaseLocal{Locations: conformanceLocations(t)}))
+}
+
+func TestConformance_MMDB(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoIP2-City", RecordSize: 24, IncludeReservedNetworks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, loc := range conformanceLocations(t) {
+		record := mmdbtype.Map{
+			"country":      mmdbtype.Map{"iso_code": mmdbtype.String(loc.Country)},
+			"subdivisions": mmdbtype.Slice{mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(loc.Region)}}},
+			"city":         mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(loc.City)}},
+		}
+		if err := tree.InsertRange(loc.IPFrom.IP(), loc.IPTo.IP(), record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "conformance.mmdb")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db, err := database.NewMMDBDatabase(path)
+	if err != nil {
+		t.Fatalf("NewMMDBDatabase() error = %v", err)
+	}
+	defer db.Close()
+	runConformanceSuite(t, db)
+}
+
+// TestConformance_MongoDB runs against a live server when MONGODB_TEST_URI is set
+func TestConformance_MongoDB(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(ctx)
+
+	dbName := fmt.Sprintf("ip2country_conformance_%d", time.Now().UnixNano())
+	defer client.Database(dbName).Drop(ctx)
+	var documents []interface{}
+	for _, loc := range conformanceLocations(t) {
+		documents = append(documents, loc)
+	}
+	if _, err := client.Database(dbName).Collection("ip_locations").InsertMany(ctx, documents); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.NewMongoDatabase(uri, dbName)
+	if err != nil {
+		t.Fatalf("NewMongoDatabase() error = %v", err)
+	}
+	runConformanceSuite(t, db)
+}
+
+func writeJSONFixture(t *testing.T, locations []database.IPLocation) string {
+	t.Helper()
+	data, err := json.Marshal(locations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, string(data))
+	return path
+}
+
+// TestConformance_NetworksFromZero checks that IPv6 networks starting in
+// ::/96, such as ::/64 or ::/0, load from datasets and overrides and answer
+// the IPv6 addresses they cover, while the addresses of ::/96 themselves
+// are still refused as lookup input
+func TestConformance_NetworksFromZero(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "ip_database.csv")
+	writeDataset(t, csvPath, "network,country\n::/64,AU\n2001:db8::/32,DE\n")
+	csvDB, err := database.NewCSVDatabase(csvPath)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	trieDB := database.NewTrieDatabase(database.DatabaseLocal{Locations: csvDB.Locations})
+
+	// An override catching everything the base dataset does not answer
+	basePath := writeJSONFixture(t, conformanceLocations(t))
+	base, err := database.NewReloadableDatabase(basePath, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	overridesPath := filepath.Join(dir, "overrides.csv")
+	writeDataset(t, overridesPath, "network,country\n::/0,AU\n2001:db8::/32,DE\n")
+	overridden, err := database.NewOverrideDatabase(base, overridesPath)
+	if err != nil {
+		t.Fatalf("NewOverrideDatabase() error = %v", err)
+	}
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoIP2-Country", RecordSize: 24, IncludeReservedNetworks: true, DisableIPv4Aliasing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for cidr, country := range map[string]string{"::/64": "AU", "2001:db8::/32": "DE"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		if err := tree.Insert(network, mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String(country)}}); err != nil {
+			t.Fatalf("Insert(%s) error = %v", cidr, err)
+		}
+	}
+	mmdbPath := filepath.Join(dir, "fixture.mmdb")
+	file, err := os.Create(mmdbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	mmdbDB, err := database.NewMMDBDatabase(mmdbPath)
+	if err != nil {
+		t.Fatalf("NewMMDBDatabase() error = %v", err)
+	}
+	defer mmdbDB.Close()
+
+	for name, tt := range map[string]struct {
+		db       database.IPDatabase
+		from, to string // the range answering ::1:0:0:1
+	}{
+		"csv": {csvDB, "0", "18446744073709551615"}, // ::/64
+		// ::/0 up to the more specific 2001:db8::/32
+		"override": {overridden, "0", "42540766411282592856903984951653826559"},
+		"trie":     {trieDB, "0", "18446744073709551615"},
+		"mmdb":     {mmdbDB, "0", "18446744073709551615"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			loc, matched, err := database.FindRange(context.Background(), tt.db, "::1:0:0:1")
+			if err != nil || loc.Country != "AU" {
+				t.Fatalf("FindRange(::1:0:0:1) = %+v, %v; want AU", loc, err)
+			}
+			if matched.From.String() != tt.from || matched.To.String() != tt.to {
+				t.Errorf("FindRange(::1:0:0:1) range = %s - %s, want %s - %s", matched.From, matched.To, tt.from, tt.to)
+			}
+			if loc, err := tt.db.Find(context.Background(), "2001:db8::1"); err != nil || loc.Country != "DE" {
+				t.Errorf("Find(2001:db8::1) = %+v, %v; want DE", loc, err)
+			}
+		})
+	}
+	// MMDB files are searched by address rather than number, so only the
+	// numeric backends need to refuse ::/96
+	for name, db := range map[string]database.IPDatabase{"csv": csvDB, "trie": trieDB, "override": overridden} {
+		if _, err := db.Find(context.Background(), "::1"); database.KindOf(err) != database.KindInvalidInput {
+			t.Errorf("%s: Find(::1) error = %v, want invalid input", name, err)
+		}
+	}
+}
@@ -1,16 +1,24 @@
 package database_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"ip2country-service/config"
 	"ip2country-service/internal/database"
 	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"path/filepath"
 	"testing"
 )
 
 func TestCSVDatabase_Find(t *testing.T) {
 	// Mock data for testing
 	mockData := []database.IPLocation{
-		{IPFrom: 167772160, IPTo: 167772175, Country: "US", Region: "California", City: "Los Angeles"},
-		{IPFrom: 167772176, IPTo: 167772191, Country: "US", Region: "New York", City: "New York"},
+		{IPFrom: database.IPv4Number(167772160), IPTo: database.IPv4Number(167772175), Country: "US", Region: "California", City: "Los Angeles"},
+		{IPFrom: database.IPv4Number(167772176), IPTo: database.IPv4Number(167772191), Country: "US", Region: "New York", City: "New York"},
+		{IPFrom: mustParseIPNumber(t, "42540766411282592856903984951653826560"), IPTo: mustParseIPNumber(t, "42540766490510755371168322545197776895"), Country: "DE", Region: "Hesse", City: "Frankfurt"},
 	}
 
 	db := &database.CSVDatabase{
@@ -25,11 +33,14 @@ func TestCSVDatabase_Find(t *testing.T) {
 		{"10.0.0.1", &models.Location{Country: "US", Region: "California", City: "Los Angeles"}, false},
 		{"10.0.0.16", &models.Location{Country: "US", Region: "New York", City: "New York"}, false},
 		{"10.0.0.32", nil, true},
+		{"::ffff:10.0.0.1", &models.Location{Country: "US", Region: "California", City: "Los Angeles"}, false},
+		{"2001:db8::1", &models.Location{Country: "DE", Region: "Hesse", City: "Frankfurt"}, false},
+		{"2001:db9::1", nil, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.ip, func(t *testing.T) {
-			got, err := db.Find(tt.ip)
+			got, err := db.Find(context.Background(), tt.ip)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Find() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -40,3 +51,190 @@ func TestCSVDatabase_Find(t *testing.T) {
 		})
 	}
 }
+
+func TestNewCSVDatabase_OptionalColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	writeDataset(t, path, `ip_from,ip_to,country,region,city,latitude,longitude,accuracy_radius,postal_code,timezone,asn,organization
+167772160,167772415,GB,England,London,51.5142,-0.0931,20,EC2V,Europe/London,AS64500,Example Networks
+167772416,167772671,US,California,Los Angeles
+167772672,167772927,GH,Greater Accra,Accra,0,0,,,,,
+167772928,167773183,US,Texas,Austin,123.4,0
+`)
+
+	db, err := database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	if len(db.Locations) != 3 || db.Report.Skipped["invalid latitude"] != 1 {
+		t.Errorf("loaded %d ranges (%s), want 3 with the out-of-range latitude skipped", len(db.Locations), db.Report)
+	}
+
+	london, err := db.Find(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if london.Latitude == nil || *london.Latitude != 51.5142 || london.Longitude == nil || *london.Longitude != -0.0931 {
+		t.Errorf("Find() coordinates = %v, %v; want 51.5142, -0.0931", london.Latitude, london.Longitude)
+	}
+	if london.AccuracyRadius != 20 || london.PostalCode != "EC2V" || london.TimeZone != "Europe/London" || london.ASN != 64500 || london.Organization != "Example Networks" {
+		t.Errorf("Find() = %+v, want every optional column loaded", london)
+	}
+
+	// Rows without the optional columns leave them empty
+	if la, err := db.Find(context.Background(), "10.0.1.1"); err != nil || la.Latitude != nil || la.ASN != 0 || la.TimeZone != "" {
+		t.Errorf("Find() = %+v, %v; want no optional details", la, err)
+	}
+
+	// Zero is a valid coordinate and is kept
+	if accra, err := db.Find(context.Background(), "10.0.2.1"); err != nil || accra.Latitude == nil || *accra.Latitude != 0 {
+		t.Errorf("Find() = %+v, %v; want a latitude of 0", accra, err)
+	}
+}
+
+func TestNewCSVDatabase_HeaderMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	// Columns in any order, found by their header names, with a BOM and semicolons
+	writeDataset(t, path, "\ufeff\"City Name\";Country Code;IP Start;IP End;Time Zone\n"+
+		"\"Los Angeles\";US;167772160;167772415;America/Los_Angeles\n"+
+		"\"London; City of\";GB;167772416;167772671;Europe/London\n")
+
+	db, err := database.NewCSVDatabaseWithOptions(path, database.CSVOptions{Comma: ';'})
+	if err != nil {
+		t.Fatalf("NewCSVDatabaseWithOptions() error = %v", err)
+	}
+	if db.Report.Rows != 2 || db.Report.Loaded != 2 {
+		t.Errorf("report = %s, want 2 of 2 rows loaded", db.Report)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" || loc.City != "Los Angeles" || loc.TimeZone != "America/Los_Angeles" {
+		t.Errorf("Find() = %+v, %v; want US, Los Angeles, America/Los_Angeles", loc, err)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.1.1"); err != nil || loc.City != "London; City of" {
+		t.Errorf("Find() = %+v, %v; want the quoted city", loc, err)
+	}
+}
+
+func TestNewCSVDatabase_TextRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	// Dotted-quad pairs, a CIDR in ip_from, integers and IPv6 text side by side
+	writeDataset(t, path, "10.0.0.0,10.0.0.255,US\n"+
+		"10.0.1.0/24,,GB\n"+
+		"167772672,167772927,FR\n"+
+		"2001:db8::,2001:db8::ffff,DE\n")
+
+	db, err := database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	for ip, want := range map[string]string{"10.0.0.1": "US", "10.0.1.255": "GB", "10.0.2.1": "FR", "2001:db8::1": "DE"} {
+		if loc, err := db.Find(context.Background(), ip); err != nil || loc.Country != want {
+			t.Errorf("Find(%s) = %+v, %v; want %s", ip, loc, err, want)
+		}
+	}
+
+	// A network column stands in for ip_from and ip_to
+	writeDataset(t, path, "network,country\n10.1.0.0/16,CA\n::ffff:10.2.0.0/112,MX\n")
+	db, err = database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	for ip, want := range map[string]string{"10.1.255.1": "CA", "10.2.0.1": "MX"} {
+		if loc, err := db.Find(context.Background(), ip); err != nil || loc.Country != want {
+			t.Errorf("Find(%s) = %+v, %v; want %s", ip, loc, err, want)
+		}
+	}
+}
+
+func TestNewCSVDatabase_Layout(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	// IP2Location DB11 rows: no header, quoted fields and the country name in column 3
+	gz.Write([]byte(`"167772160","167772415","US","United States of America","California","Los Angeles","34.05223","-118.24368","90001","-07:00"
+"167772416","167772671","GB","United Kingdom","England","London","51.50853","-0.12574","EC1A","+01:00"
+`))
+	gz.Close()
+	path := filepath.Join(t.TempDir(), "IP2LOCATION-LITE-DB11.CSV")
+	writeDataset(t, path, buf.String())
+
+	opts, err := database.CSVOptionsFromConfig(&config.Config{CSVLayout: "ip2location", CSVColumns: []string{"timezone=-"}})
+	if err != nil {
+		t.Fatalf("CSVOptionsFromConfig() error = %v", err)
+	}
+	db, err := database.NewCSVDatabaseWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewCSVDatabaseWithOptions() error = %v", err)
+	}
+
+	loc, err := db.Find(context.Background(), "10.0.1.1")
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if loc.Country != "GB" || loc.Region != "England" || loc.City != "London" || loc.PostalCode != "EC1A" || loc.Latitude == nil || *loc.Latitude != 51.50853 {
+		t.Errorf("Find() = %+v, want the IP2Location columns mapped", loc)
+	}
+	// The layout's UTC offsets are not IANA names, so the mapping drops them
+	if loc.TimeZone != "" {
+		t.Errorf("Find() time zone = %q, want it unmapped", loc.TimeZone)
+	}
+}
+
+func TestNewCSVDatabase_LoadReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	writeDataset(t, path, `ip_from,ip_to,country,region,city
+167772160,167772415,US,California,Los Angeles
+not-a-number,167772671,GB,England,London
+167772672,oops,FR,Ile-de-France,Paris
+167772928
+167773184,167773439,DE,"Hesse,Frankfurt
+`)
+
+	db, err := database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	report := db.Report
+	if report.Rows != 5 || report.Loaded != 1 || report.SkippedRows() != 4 {
+		t.Errorf("report = %s, want 1 of 5 rows loaded", report)
+	}
+	want := map[string]int{"invalid ip_from": 3, "invalid ip_to": 4, "incomplete row": 5, "malformed row": 6}
+	for reason, line := range want {
+		if report.Skipped[reason] != 1 || report.FirstLine[reason] != line {
+			t.Errorf("%s: skipped %d rows first at line %d, want 1 at line %d", reason, report.Skipped[reason], report.FirstLine[reason], line)
+		}
+	}
+}
+
+func TestCSVOptionsFromConfig(t *testing.T) {
+	opts, err := database.CSVOptionsFromConfig(&config.Config{CSVDelimiter: "tab", CSVHeader: "false", CSVColumns: []string{"ip_from=2", "ip_to = 3", "country=0"}})
+	if err != nil {
+		t.Fatalf("CSVOptionsFromConfig() error = %v", err)
+	}
+	if opts.Comma != '\t' || opts.Header != database.HeaderAbsent || opts.Columns["ip_to"] != "3" {
+		t.Errorf("CSVOptionsFromConfig() = %+v", opts)
+	}
+
+	for name, cfg := range map[string]*config.Config{
+		"Long delimiter": {CSVDelimiter: ";;"},
+		"Quote":          {CSVDelimiter: `"`},
+		"Header":         {CSVHeader: "maybe"},
+		"Layout":         {CSVLayout: "maxmind"},
+		"Entry":          {CSVColumns: []string{"country"}},
+	} {
+		if _, err := database.CSVOptionsFromConfig(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Mappings that cannot be resolved against the file fail the load
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	writeDataset(t, path, "167772160,167772415,US\n")
+	for name, columns := range map[string]map[string]string{
+		"Unknown field":    {"ip_from": "0", "ip_to": "1", "country": "2", "continent": "3"},
+		"Named, no header": {"ip_from": "start", "ip_to": "1", "country": "2"},
+		"Missing country":  {"ip_from": "0", "ip_to": "1"},
+	} {
+		opts := database.CSVOptions{Header: database.HeaderAbsent, Columns: columns}
+		if _, err := database.NewCSVDatabaseWithOptions(path, opts); !errors.Is(err, utils.ErrInvalidDataset) {
+			t.Errorf("%s: error = %v, want ErrInvalidDataset", name, err)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package database_test
 
 import (
+	"context"
+	"io"
 	"ip2country-service/config"
 	"ip2country-service/internal/database"
+	"path/filepath"
 	"testing"
 )
 
@@ -31,4 +34,54 @@ func TestNewIPDatabase(t *testing.T) {
 			t.Errorf("NewIPDatabase() error = %v, expectedError false", err)
 		}
 	})
+
+	t.Run("MMDB_Database", func(t *testing.T) {
+		config := &config.Config{
+			DatabaseType: "mmdb",
+			DatabasePath: writeMMDBFixture(t),
+		}
+		db, err := database.NewIPDatabase(config)
+		if err != nil {
+			t.Fatalf("NewIPDatabase() error = %v, expectedError false", err)
+		}
+		db.(io.Closer).Close()
+	})
+
+	t.Run("Snapshot_Database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ip_database.snap")
+		if err := database.WriteSnapshot(path, conformanceLocations(t)); err != nil {
+			t.Fatalf("WriteSnapshot() error = %v", err)
+		}
+		config := &config.Config{
+			DatabaseType: "snapshot",
+			DatabasePath: path,
+		}
+		db, err := database.NewIPDatabase(config)
+		if err != nil {
+			t.Fatalf("NewIPDatabase() error = %v, expectedError false", err)
+		}
+		db.(io.Closer).Close()
+	})
+
+	t.Run("Trie_Index", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ip_database.json")
+		writeDataset(t, path, `[{"network": "10.0.0.0/8", "country": "US"}, {"network": "10.1.0.0/16", "country": "GB"}]`)
+		config := &config.Config{
+			DatabaseType: "json",
+			DatabasePath: path,
+			LookupIndex:  "trie",
+		}
+		db, err := database.NewIPDatabase(config)
+		if err != nil {
+			t.Fatalf("NewIPDatabase() error = %v, expectedError false", err)
+		}
+		if loc, err := db.Find(context.Background(), "10.1.0.1"); err != nil || loc.Country != "GB" {
+			t.Errorf("Find() = %+v, %v, want the nested GB range", loc, err)
+		}
+
+		config.LookupIndex = "hash"
+		if _, err := database.NewIPDatabase(config); err == nil {
+			t.Error("NewIPDatabase() error = nil for an unknown lookup index")
+		}
+	})
 }
@@ -0,0 +1,64 @@
+package database_test
+
+import (
+	"context"
+	"ip2country-service/internal/database"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribe_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.snap")
+	if err := database.WriteSnapshot(path, conformanceLocations(t)); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	db, err := database.NewReloadableDatabase(path, database.LoadSnapshot)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	meta, ok := database.Describe(context.Background(), db)
+	if !ok {
+		t.Fatal("Describe() = false for a reloadable snapshot")
+	}
+	if meta.Type != "snapshot" || meta.Records != 3 || meta.Version != db.Version() || len(meta.Checksum) != 64 {
+		t.Errorf("Describe() = %+v, want 3 records at version %s", meta, db.Version())
+	}
+	// The compile time is recorded in the snapshot, to the second
+	if meta.BuildDate == nil || time.Since(*meta.BuildDate) > time.Minute {
+		t.Errorf("BuildDate = %v, want about now", meta.BuildDate)
+	}
+}
+
+func TestDescribe_Overrides(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "ip_database.json")
+	writeDataset(t, basePath, usDataset)
+	// A broken sidecar is ignored rather than refusing the dataset
+	writeDataset(t, basePath+".meta.json", `{"build_date": "last tuesday"}`)
+	base, err := database.NewReloadableDatabase(basePath, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+
+	overridesPath := filepath.Join(dir, "overrides.csv")
+	writeDataset(t, overridesPath, "network,country\n10.0.0.0/28,GB\n")
+	db, err := database.NewOverrideDatabase(base, overridesPath)
+	if err != nil {
+		t.Fatalf("NewOverrideDatabase() error = %v", err)
+	}
+
+	meta, _ := database.Describe(context.Background(), db)
+	if meta.Type != "json" || meta.Records != 1 || meta.Version != db.Version() || meta.BuildDate != nil {
+		t.Errorf("Describe() = %+v, want the JSON dataset at version %s", meta, db.Version())
+	}
+	if !strings.HasPrefix(meta.Version, base.Version()+"+") {
+		t.Errorf("Version = %s, want the base version %s first", meta.Version, base.Version())
+	}
+	if meta.Overrides == nil || meta.Overrides.Type != "csv" || meta.Overrides.Path != overridesPath || meta.Overrides.Records != 1 {
+		t.Errorf("Overrides = %+v, want the CSV overrides file with 1 range", meta.Overrides)
+	}
+}
@@ -0,0 +1,48 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want database.ErrorKind
+	}{
+		{"LookupError", &database.LookupError{Kind: database.KindTimeout}, database.KindTimeout},
+		{"Wrapped LookupError", fmt.Errorf("lookup: %w", &database.LookupError{Kind: database.KindNotFound}), database.KindNotFound},
+		{"Legacy not found", fmt.Errorf("%w: 10.0.0.1", utils.ErrIpNotFound), database.KindNotFound},
+		{"Legacy invalid IP", utils.ErrInvalidIP, database.KindInvalidInput},
+		{"Deadline", context.DeadlineExceeded, database.KindTimeout},
+		{"Unknown", errors.New("connection refused"), database.KindBackend},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := database.KindOf(tt.err); got != tt.want {
+				t.Errorf("KindOf() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupError(t *testing.T) {
+	cause := errors.New("socket closed")
+	err := &database.LookupError{Kind: database.KindBackend, Op: "MongoDatabase.Find", IP: "10.0.0.1", Err: cause}
+
+	if !errors.Is(err, utils.ErrDatabaseQuery) || !errors.Is(err, cause) {
+		t.Errorf("errors.Is() failed to match sentinel or cause for %v", err)
+	}
+	if errors.Is(err, utils.ErrIpNotFound) {
+		t.Errorf("errors.Is(%v, ErrIpNotFound) = true", err)
+	}
+	if want := "error querying database: 10.0.0.1: socket closed"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,111 @@
+package database_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"net"
+	"testing"
+)
+
+func mustParseIPNumber(t *testing.T, s string) database.IPNumber {
+	t.Helper()
+	n, err := database.ParseIPNumber(s)
+	if err != nil {
+		t.Fatalf("ParseIPNumber(%q) error = %v", s, err)
+	}
+	return n
+}
+
+func TestIPToNumber(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.0.0.1", "167772161"},
+		{"::ffff:10.0.0.1", "167772161"},
+		{"2001:db8::1", "42540766411282592856903984951653826561"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "340282366920938463463374607431768211455"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := database.IPToNumber(net.ParseIP(tt.ip))
+			if err != nil {
+				t.Fatalf("IPToNumber() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("IPToNumber() = %s, want %s", got, tt.want)
+			}
+			if !got.IP().Equal(net.ParseIP(tt.ip)) {
+				t.Errorf("IP() = %v, want %v", got.IP(), tt.ip)
+			}
+		})
+	}
+}
+
+func TestIPToNumber_IPv4Space(t *testing.T) {
+	// The IPv6 addresses of ::/96 would share the numbers of IPv4 addresses
+	for _, ip := range []string{"::1", "::", "::10.0.0.1", "::ffff:ffff"} {
+		if n, err := database.IPToNumber(net.ParseIP(ip)); !errors.Is(err, utils.ErrInvalidIP) {
+			t.Errorf("IPToNumber(%s) = %s, %v; want ErrInvalidIP", ip, n, err)
+		}
+		if _, err := database.ParseRange(ip); err == nil {
+			t.Errorf("ParseRange(%s) succeeded, want an error", ip)
+		}
+	}
+	if _, err := database.ParseRange("::/112"); err == nil {
+		t.Error("ParseRange(::/112) succeeded, want an error")
+	}
+	// Networks starting in ::/96 but extending beyond it are IPv6 ranges
+	for network, to := range map[string]database.IPNumber{"::/64": {Lo: 1<<64 - 1}, "::/0": {Hi: 1<<64 - 1, Lo: 1<<64 - 1}} {
+		if r, err := database.ParseRange(network); err != nil || r.From != (database.IPNumber{}) || r.To != to {
+			t.Errorf("ParseRange(%s) = %s - %s, %v; want 0 - %s", network, r.From, r.To, err, to)
+		}
+	}
+
+	db := database.DatabaseLocal{Locations: []database.IPLocation{{IPFrom: database.IPv4Number(0), IPTo: database.IPv4Number(255), Country: "US"}}}
+	if loc, err := db.Find(context.Background(), "::1"); database.KindOf(err) != database.KindInvalidInput {
+		t.Errorf("Find(::1) = %+v, %v; want invalid input rather than the range of 0.0.0.1", loc, err)
+	}
+}
+
+func TestParseIPNumber_Invalid(t *testing.T) {
+	for _, s := range []string{"", "-1", "abc", "340282366920938463463374607431768211456"} {
+		if _, err := database.ParseIPNumber(s); err == nil {
+			t.Errorf("ParseIPNumber(%q) expected error", s)
+		}
+	}
+}
+
+func TestIPNumber_Compare(t *testing.T) {
+	v4 := database.IPv4Number(4294967295)
+	v6 := mustParseIPNumber(t, "42540766411282592856903984951653826561")
+
+	if v4.Compare(v6) != -1 || v6.Compare(v4) != 1 || v6.Compare(v6) != 0 {
+		t.Errorf("Compare() gave unexpected ordering for %s and %s", v4, v6)
+	}
+}
+
+func TestIPLocationJSON_IPv6(t *testing.T) {
+	data := `{"ip_from": 42540766411282592856903984951653826560, "ip_to": "42540766490510755371168322545197776895", "country": "DE"}`
+
+	var loc database.IPLocation
+	if err := json.Unmarshal([]byte(data), &loc); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if loc.IPFrom.String() != "42540766411282592856903984951653826560" || loc.IPTo.String() != "42540766490510755371168322545197776895" {
+		t.Errorf("Unmarshal() = %s - %s", loc.IPFrom, loc.IPTo)
+	}
+
+	out, err := json.Marshal(loc)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var roundTrip database.IPLocation
+	if err := json.Unmarshal(out, &roundTrip); err != nil || roundTrip != loc {
+		t.Errorf("round trip = %+v, %v; want %+v", roundTrip, err, loc)
+	}
+}
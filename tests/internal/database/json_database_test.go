@@ -1,16 +1,21 @@
 package database_test
 
 import (
+	"context"
+	"errors"
 	"ip2country-service/internal/database"
 	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"path/filepath"
 	"testing"
 )
 
 func TestJSONDatabase_Find(t *testing.T) {
 	// Mock data for testing
 	mockData := []database.IPLocation{
-		{IPFrom: 167772160, IPTo: 167772175, Country: "US", Region: "California", City: "Los Angeles"},
-		{IPFrom: 167772176, IPTo: 167772191, Country: "US", Region: "New York", City: "New York"},
+		{IPFrom: database.IPv4Number(167772160), IPTo: database.IPv4Number(167772175), Country: "US", Region: "California", City: "Los Angeles"},
+		{IPFrom: database.IPv4Number(167772176), IPTo: database.IPv4Number(167772191), Country: "US", Region: "New York", City: "New York"},
+		{IPFrom: mustParseIPNumber(t, "42540766411282592856903984951653826560"), IPTo: mustParseIPNumber(t, "42540766490510755371168322545197776895"), Country: "DE", Region: "Hesse", City: "Frankfurt"},
 	}
 
 	db := &database.JSONDatabase{
@@ -25,11 +30,14 @@ func TestJSONDatabase_Find(t *testing.T) {
 		{"10.0.0.1", &models.Location{Country: "US", Region: "California", City: "Los Angeles"}, false},
 		{"10.0.0.16", &models.Location{Country: "US", Region: "New York", City: "New York"}, false},
 		{"10.0.0.32", nil, true},
+		{"::ffff:10.0.0.1", &models.Location{Country: "US", Region: "California", City: "Los Angeles"}, false},
+		{"2001:db8::1", &models.Location{Country: "DE", Region: "Hesse", City: "Frankfurt"}, false},
+		{"2001:db9::1", nil, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.ip, func(t *testing.T) {
-			got, err := db.Find(tt.ip)
+			got, err := db.Find(context.Background(), tt.ip)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Find() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -40,3 +48,64 @@ func TestJSONDatabase_Find(t *testing.T) {
 		})
 	}
 }
+
+func TestNewJSONDatabase_OptionalFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, `[
+		{"ip_from": 167772160, "ip_to": 167772415, "country": "GB", "city": "London", "latitude": 51.5142, "longitude": -0.0931,
+		 "accuracy_radius": 20, "postal_code": "EC2V", "timezone": "Europe/London", "asn": 64500, "organization": "Example Networks"},
+		{"ip_from": 167772416, "ip_to": 167772671, "country": "US", "city": "Los Angeles"}
+	]`)
+
+	db, err := database.NewJSONDatabase(path)
+	if err != nil {
+		t.Fatalf("NewJSONDatabase() error = %v", err)
+	}
+
+	london, err := db.Find(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if london.Latitude == nil || *london.Latitude != 51.5142 || london.AccuracyRadius != 20 || london.PostalCode != "EC2V" ||
+		london.TimeZone != "Europe/London" || london.ASN != 64500 || london.Organization != "Example Networks" {
+		t.Errorf("Find() = %+v, want every optional field loaded", london)
+	}
+	if la, err := db.Find(context.Background(), "10.0.1.1"); err != nil || la.Latitude != nil || la.Longitude != nil || la.ASN != 0 {
+		t.Errorf("Find() = %+v, %v; want no optional details", la, err)
+	}
+
+	// Coordinates off the globe fail validation
+	writeDataset(t, path, `[{"ip_from": 167772160, "ip_to": 167772415, "country": "GB", "longitude": 200}]`)
+	db, err = database.NewJSONDatabase(path)
+	if err != nil {
+		t.Fatalf("NewJSONDatabase() error = %v", err)
+	}
+	if err := db.Validate(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Validate() error = %v, want ErrInvalidDataset", err)
+	}
+}
+
+func TestNewJSONDatabase_TextRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, `[
+		{"ip_from": "10.0.0.0", "ip_to": "10.0.0.255", "country": "US"},
+		{"ip_from": "10.0.1.0/24", "country": "GB"},
+		{"ip_from": "167772672", "ip_to": 167772927, "country": "FR"},
+		{"network": "2001:db8::/32", "country": "DE"}
+	]`)
+
+	db, err := database.NewJSONDatabase(path)
+	if err != nil {
+		t.Fatalf("NewJSONDatabase() error = %v", err)
+	}
+	for ip, want := range map[string]string{"10.0.0.1": "US", "10.0.1.255": "GB", "10.0.2.1": "FR", "2001:db8:ffff::1": "DE"} {
+		if loc, err := db.Find(context.Background(), ip); err != nil || loc.Country != want {
+			t.Errorf("Find(%s) = %+v, %v; want %s", ip, loc, err, want)
+		}
+	}
+
+	writeDataset(t, path, `[{"country": "US"}]`)
+	if _, err := database.NewJSONDatabase(path); err == nil {
+		t.Error("NewJSONDatabase() succeeded for an entry without a range")
+	}
+}
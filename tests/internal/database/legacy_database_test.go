@@ -0,0 +1,51 @@
+package database_test
+
+import (
+	"context"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"testing"
+	"time"
+)
+
+type legacyDatabase struct {
+	delay time.Duration
+}
+
+func (m *legacyDatabase) Find(ip string) (*models.Location, error) {
+	time.Sleep(m.delay)
+	return &models.Location{Country: "US"}, nil
+}
+
+func TestFromLegacy(t *testing.T) {
+	db := database.FromLegacy(&legacyDatabase{})
+	loc, err := db.Find(context.Background(), "10.0.0.1")
+	if err != nil || loc.Country != "US" {
+		t.Errorf("Find() = %v, %v; want US", loc, err)
+	}
+}
+
+func TestFromLegacy_Timeout(t *testing.T) {
+	db := database.FromLegacy(&legacyDatabase{delay: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := db.Find(ctx, "10.0.0.1")
+	if database.KindOf(err) != database.KindTimeout {
+		t.Errorf("Find() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Find() returned after %s, want prompt return on deadline", elapsed)
+	}
+}
+
+func TestFind_CancelledContext(t *testing.T) {
+	db := &database.JSONDatabase{DatabaseLocal: database.DatabaseLocal{Locations: conformanceLocations(t)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.Find(ctx, "10.0.0.1"); database.KindOf(err) != database.KindTimeout {
+		t.Errorf("Find() error = %v, want timeout", err)
+	}
+}
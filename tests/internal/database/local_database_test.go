@@ -0,0 +1,138 @@
+package database_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewLocalDatabase_NDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.ndjson")
+	writeDataset(t, path, `{"network": "10.0.1.0/24", "country": "GB", "city": "London"}
+
+{"ip_from": 167772160, "ip_to": 167772415, "country": "US", "city": "Los Angeles"}
+`)
+	db, err := database.NewLocalDatabase("ndjson", path, database.LoadOptions{})
+	if err != nil {
+		t.Fatalf("NewLocalDatabase() error = %v", err)
+	}
+	if len(db.Locations) != 2 || db.Locations[0].Country != "US" {
+		t.Fatalf("Locations = %+v, want 2 ranges sorted by ip_from", db.Locations)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.1.1"); err != nil || loc.City != "London" {
+		t.Errorf("Find() = %+v, %v, want London", loc, err)
+	}
+
+	writeDataset(t, path, "{\"network\": \"10.0.0.0/24\", \"country\": \"US\"}\n{\"network\": \n")
+	_, err = database.NewLocalDatabase("ndjson", path, database.LoadOptions{})
+	if !errors.Is(err, utils.ErrJSONUnmarshal) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("NewLocalDatabase() error = %v, want ErrJSONUnmarshal on line 2", err)
+	}
+}
+
+func TestNewLocalDatabase_Gzip(t *testing.T) {
+	// Decompression is shared by every format, including JSON
+	path := filepath.Join(t.TempDir(), "ip_database.json.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(file)
+	io.WriteString(gz, usDataset)
+	gz.Close()
+	file.Close()
+
+	db, err := database.NewLocalDatabase("json", path, database.LoadOptions{})
+	if err != nil {
+		t.Fatalf("NewLocalDatabase() error = %v", err)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" {
+		t.Errorf("Find() = %+v, %v, want US", loc, err)
+	}
+}
+
+func TestDatabaseLocal_MetadataStatsAndIteration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	writeDataset(t, path, "network,country\n10.0.1.0/24,GB\n10.0.0.0/24,US\n2001:db8::/32,DE\n10.0.2.0/24,US\n")
+	db, err := database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+
+	meta := db.Metadata()
+	if meta.Format != "csv" || meta.Path != path || meta.Size == 0 || meta.LoadedAt.IsZero() {
+		t.Errorf("Metadata() = %+v, want the CSV file it was loaded from", meta)
+	}
+
+	stats := db.Stats()
+	if stats.Ranges != 4 || stats.IPv4Ranges != 3 || stats.IPv6Ranges != 1 || stats.Countries != 3 {
+		t.Errorf("Stats() = %+v, want 4 ranges, 3 IPv4, 1 IPv6 and 3 countries", stats)
+	}
+	if stats.IPv4Coverage <= 0 || stats.IPv6Coverage <= 0 {
+		t.Errorf("Stats() coverage = %v and %v, want both positive", stats.IPv4Coverage, stats.IPv6Coverage)
+	}
+
+	var countries []string
+	for loc := range db.All() {
+		countries = append(countries, loc.Country)
+		if len(countries) == 3 {
+			break
+		}
+	}
+	if want := []string{"US", "GB", "US"}; !slices.Equal(countries, want) {
+		t.Errorf("All() yielded %v, want %v", countries, want)
+	}
+
+	// Every local backend shares the engine, the trie index included
+	if trie := database.NewTrieDatabase(db.DatabaseLocal); trie.Stats() != stats {
+		t.Errorf("TrieDatabase.Stats() = %+v, want %+v", trie.Stats(), stats)
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	// A format of "network country" lines is a loader and nothing more
+	database.RegisterFormat("test-lines", func(r io.Reader, _ database.LoadOptions) ([]database.IPLocation, error) {
+		var locations []database.IPLocation
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			network, country, _ := strings.Cut(scanner.Text(), " ")
+			rng, err := database.ParseRange(network)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", utils.ErrInvalidDataset, err)
+			}
+			locations = append(locations, database.IPLocation{IPFrom: rng.From, IPTo: rng.To, Country: country})
+		}
+		return locations, scanner.Err()
+	})
+	if !slices.Contains(database.Formats(), "test-lines") {
+		t.Errorf("Formats() = %v, want test-lines listed", database.Formats())
+	}
+
+	path := filepath.Join(t.TempDir(), "ip_database.txt")
+	writeDataset(t, path, "10.0.0.0/24 US\n10.0.1.0/24 GB\n")
+	db, err := database.NewIPDatabase(&config.Config{DatabaseType: "test-lines", DatabasePath: path})
+	if err != nil {
+		t.Fatalf("NewIPDatabase() error = %v", err)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.1.1"); err != nil || loc.Country != "GB" {
+		t.Errorf("Find() = %+v, %v, want GB", loc, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterFormat() did not panic for a name registered twice")
+		}
+	}()
+	database.RegisterFormat("csv", nil)
+}
@@ -0,0 +1,227 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeMMDBFixture generates a small GeoIP2-City style database in a temp directory
+func writeMMDBFixture(t *testing.T) string {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType:            "GeoIP2-City",
+		RecordSize:              24,
+		IncludeReservedNetworks: true,
+	})
+	if err != nil {
+		t.Fatalf("mmdbwriter.New() error = %v", err)
+	}
+
+	records := map[string]mmdbtype.Map{
+		"10.0.0.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+			"subdivisions": mmdbtype.Slice{mmdbtype.Map{
+				"iso_code": mmdbtype.String("CA"),
+				"names":    mmdbtype.Map{"en": mmdbtype.String("California")},
+			}},
+			"city": mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Los Angeles")}},
+		},
+		"2001:db8::/32": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+			"subdivisions": mmdbtype.Slice{mmdbtype.Map{
+				"iso_code": mmdbtype.String("HE"),
+			}},
+			"city": mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Frankfurt")}},
+		},
+		"10.0.1.0/24": {
+			"registered_country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+		},
+		"10.0.3.0/24": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+			"location": mmdbtype.Map{
+				"latitude":        mmdbtype.Float64(51.5142),
+				"longitude":       mmdbtype.Float64(-0.0931),
+				"accuracy_radius": mmdbtype.Uint16(20),
+				"time_zone":       mmdbtype.String("Europe/London"),
+			},
+			"postal": mmdbtype.Map{"code": mmdbtype.String("EC2V")},
+			"traits": mmdbtype.Map{
+				"autonomous_system_number":       mmdbtype.Uint32(64500),
+				"autonomous_system_organization": mmdbtype.String("Example Networks"),
+			},
+		},
+	}
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(network, record); err != nil {
+			t.Fatalf("Insert(%s) error = %v", cidr, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.mmdb")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	return path
+}
+
+func TestMMDBDatabase_Find(t *testing.T) {
+	db, err := database.NewMMDBDatabase(writeMMDBFixture(t))
+	if err != nil {
+		t.Fatalf("NewMMDBDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		ip      string
+		want    *models.Location
+		wantErr bool
+	}{
+		{"10.0.0.1", &models.Location{Country: "US", Region: "California", City: "Los Angeles"}, false},
+		{"::ffff:10.0.0.1", &models.Location{Country: "US", Region: "California", City: "Los Angeles"}, false},
+		{"2001:db8::1", &models.Location{Country: "DE", Region: "HE", City: "Frankfurt"}, false},
+		{"10.0.1.1", &models.Location{Country: "GB"}, false},
+		{"10.0.2.1", nil, true},
+		{"invalid_ip", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := db.Find(context.Background(), tt.ip)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Find() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && *got != *tt.want {
+				t.Errorf("Find() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMMDBDatabase_FindASN(t *testing.T) {
+	// A GeoLite2-ASN style database, whose records carry no country
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-ASN", RecordSize: 24, IncludeReservedNetworks: true})
+	if err != nil {
+		t.Fatalf("mmdbwriter.New() error = %v", err)
+	}
+	records := map[string]mmdbtype.Map{
+		"10.0.0.0/24": {
+			"autonomous_system_number":       mmdbtype.Uint32(64500),
+			"autonomous_system_organization": mmdbtype.String("Example Networks"),
+		},
+		"10.0.1.0/24": {},
+	}
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Insert(network, record); err != nil {
+			t.Fatalf("Insert(%s) error = %v", cidr, err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	file.Close()
+
+	db, err := database.NewMMDBDatabase(path)
+	if err != nil {
+		t.Fatalf("NewMMDBDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	got, err := db.Find(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("Find() error = %v, want the ASN-only record", err)
+	}
+	if want := (models.Location{ASN: 64500, Organization: "Example Networks"}); *got != want {
+		t.Errorf("Find() = %+v, want %+v", got, want)
+	}
+	// A record with neither a country nor an autonomous system is not an answer
+	if _, err := db.Find(context.Background(), "10.0.1.1"); !errors.Is(err, utils.ErrIpNotFound) {
+		t.Errorf("Find() error = %v, want ErrIpNotFound for an empty record", err)
+	}
+}
+
+func TestMMDBDatabase_FindIPv4Space(t *testing.T) {
+	// A record covering ::/8 leaves no IPv4 subtree, and the reader answers
+	// IPv4 lookups with the ::/8 network
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoIP2-Country", RecordSize: 24, IncludeReservedNetworks: true, DisableIPv4Aliasing: true})
+	if err != nil {
+		t.Fatalf("mmdbwriter.New() error = %v", err)
+	}
+	_, network, _ := net.ParseCIDR("::/8")
+	if err := tree.Insert(network, mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")}}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "fixture.mmdb")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.WriteTo(file); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	file.Close()
+
+	db, err := database.NewMMDBDatabase(path)
+	if err != nil {
+		t.Fatalf("NewMMDBDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	loc, matched, err := db.FindRange(context.Background(), "10.0.0.1")
+	if err != nil || loc.Country != "US" {
+		t.Fatalf("FindRange() = %+v, %v; want US", loc, err)
+	}
+	if matched.From.IP().String() != "0.0.0.0" || matched.To.IP().String() != "255.255.255.255" {
+		t.Errorf("FindRange() range = %s - %s, want the IPv4 space", matched.From.IP(), matched.To.IP())
+	}
+}
+
+func TestMMDBDatabase_FindDetails(t *testing.T) {
+	db, err := database.NewMMDBDatabase(writeMMDBFixture(t))
+	if err != nil {
+		t.Fatalf("NewMMDBDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	got, err := db.Find(context.Background(), "10.0.3.1")
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if got.Latitude == nil || *got.Latitude != 51.5142 || got.Longitude == nil || *got.Longitude != -0.0931 {
+		t.Errorf("Find() coordinates = %v, %v; want 51.5142, -0.0931", got.Latitude, got.Longitude)
+	}
+	if got.AccuracyRadius != 20 || got.PostalCode != "EC2V" || got.TimeZone != "Europe/London" {
+		t.Errorf("Find() = %+v, want accuracy radius 20, postal code EC2V and time zone Europe/London", got)
+	}
+	if got.ASN != 64500 || got.Organization != "Example Networks" {
+		t.Errorf("Find() ASN = %d %q, want 64500 Example Networks", got.ASN, got.Organization)
+	}
+}
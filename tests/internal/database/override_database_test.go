@@ -0,0 +1,138 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverrideDatabase_MostSpecificWins(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "ip_database.json")
+	writeDataset(t, basePath, `[{"network": "10.0.0.0/16", "country": "US", "city": "Los Angeles"}]`)
+	base, err := database.NewReloadableDatabase(basePath, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+
+	// An office /24 with a nested /25 correcting part of it
+	overridesPath := filepath.Join(dir, "overrides.csv")
+	writeDataset(t, overridesPath, "network,country,city\n10.0.1.0/24,GB,London\n10.0.1.128/25,FR,Paris\n")
+	db, err := database.NewOverrideDatabase(base, overridesPath)
+	if err != nil {
+		t.Fatalf("NewOverrideDatabase() error = %v", err)
+	}
+
+	tests := []struct {
+		ip       string
+		country  string
+		source   string
+		from, to string
+	}{
+		{"10.0.1.1", "GB", models.SourceOverride, "10.0.1.0", "10.0.1.127"},
+		{"10.0.1.200", "FR", models.SourceOverride, "10.0.1.128", "10.0.1.255"},
+		// Base answers are narrowed so they never cover an override
+		{"10.0.0.1", "US", "", "10.0.0.0", "10.0.0.255"},
+		{"10.0.2.1", "US", "", "10.0.2.0", "10.0.255.255"},
+	}
+	for _, tt := range tests {
+		loc, matched, err := db.FindRange(context.Background(), tt.ip)
+		if err != nil {
+			t.Errorf("FindRange(%s) error = %v", tt.ip, err)
+			continue
+		}
+		if loc.Country != tt.country || loc.Source != tt.source {
+			t.Errorf("FindRange(%s) = %+v, want %s from %q", tt.ip, loc, tt.country, tt.source)
+		}
+		if matched.From.IP().String() != tt.from || matched.To.IP().String() != tt.to {
+			t.Errorf("FindRange(%s) range = %s - %s, want %s - %s", tt.ip, matched.From.IP(), matched.To.IP(), tt.from, tt.to)
+		}
+	}
+
+	if _, err := db.Find(context.Background(), "192.168.0.1"); !errors.Is(err, utils.ErrIpNotFound) {
+		t.Errorf("Find() error = %v, want ErrIpNotFound from the base database", err)
+	}
+	if found, ok := database.As[*database.ReloadableDatabase](db); !ok || found != base {
+		t.Error("As() did not find the base database behind the overrides")
+	}
+}
+
+func TestOverrideDatabase_LastEntryWins(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "ip_database.json")
+	writeDataset(t, basePath, `[{"network": "10.0.0.0/16", "country": "US"}]`)
+	base, err := database.NewReloadableDatabase(basePath, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+
+	// Enough entries for the sort not to fall back to insertion sort
+	content := "network,country,city\n"
+	for i := 0; i < 50; i++ {
+		content += fmt.Sprintf("10.0.%d.0/24,GB,Town %d\n", i%5, i)
+	}
+	overridesPath := filepath.Join(dir, "overrides.csv")
+	writeDataset(t, overridesPath, content)
+	db, err := database.NewOverrideDatabase(base, overridesPath)
+	if err != nil {
+		t.Fatalf("NewOverrideDatabase() error = %v", err)
+	}
+
+	for block := 0; block < 5; block++ {
+		ip := fmt.Sprintf("10.0.%d.1", block)
+		want := fmt.Sprintf("Town %d", 45+block)
+		if loc, err := db.Find(context.Background(), ip); err != nil || loc.City != want {
+			t.Errorf("Find(%s) = %+v, %v; want %s, the last entry for the range", ip, loc, err, want)
+		}
+	}
+}
+
+func TestOverrideDatabase_Reload(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "ip_database.json")
+	writeDataset(t, basePath, `[{"network": "10.0.0.0/16", "country": "US"}]`)
+	base, err := database.NewReloadableDatabase(basePath, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+
+	// An empty overrides file is valid
+	overridesPath := filepath.Join(dir, "overrides.json")
+	writeDataset(t, overridesPath, `[]`)
+	db, err := database.NewOverrideDatabase(base, overridesPath)
+	if err != nil {
+		t.Fatalf("NewOverrideDatabase() error = %v", err)
+	}
+	reloads := 0
+	db.OnReload(func() { reloads++ })
+	version := db.Version()
+
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" || loc.Source != "" {
+		t.Fatalf("Find() = %+v, %v; want US from the base database", loc, err)
+	}
+
+	writeDataset(t, overridesPath, `[{"ip_from": "10.0.0.0", "ip_to": "10.0.0.255", "country": "CA"}]`)
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "CA" || loc.Source != models.SourceOverride {
+		t.Errorf("Find() after reload = %+v, %v; want CA from the overrides", loc, err)
+	}
+	if reloads == 0 || db.Version() == version {
+		t.Errorf("after reload: %d listener calls, version %s (was %s); want listeners called and a new version", reloads, db.Version(), version)
+	}
+
+	// A broken overrides file keeps the previous overrides serving
+	writeDataset(t, overridesPath, `[{"ip_from": "10.0.0.255", "ip_to": "10.0.0.0", "country": "CA"}]`)
+	if err := db.Reload(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Reload() error = %v, want ErrInvalidDataset for an inverted override", err)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "CA" {
+		t.Errorf("Find() after failed reload = %+v, %v; want the previous overrides", loc, err)
+	}
+}
@@ -0,0 +1,185 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"ip2country-service/config"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"ip2country-service/pkg/utils"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// rangeStrings formats the ranges of a page as "from-to[ source]"
+func rangeStrings(page database.RangePage) []string {
+	var out []string
+	for _, r := range page.Ranges {
+		s := fmt.Sprintf("%s-%s", r.From.IP(), r.To.IP())
+		if r.Location.Source != "" {
+			s += " " + r.Location.Source
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestListRanges_Local(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ip_database.csv")
+	writeDataset(t, path, "network,country,region,city\n"+
+		"10.0.2.0/24,US,California,Los Angeles\n"+
+		"10.0.0.0/24,US,California,San Francisco\n"+
+		"10.0.1.0/24,GB,England,London\n"+
+		"10.0.3.0/24,US,Texas,Austin\n"+
+		"2001:db8::/32,US,Texas,Austin\n")
+	local, err := database.NewCSVDatabase(path)
+	if err != nil {
+		t.Fatalf("NewCSVDatabase() error = %v", err)
+	}
+	snapshotPath := filepath.Join(dir, "ip_database.snap")
+	if err := database.WriteSnapshot(snapshotPath, local.Locations); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	snapshot, err := database.NewSnapshotDatabase(snapshotPath)
+	if err != nil {
+		t.Fatalf("NewSnapshotDatabase() error = %v", err)
+	}
+	defer snapshot.Close()
+
+	tests := []struct {
+		name  string
+		query database.RangeQuery
+		want  []string
+		total int
+	}{
+		{"country", database.RangeQuery{Country: "US"}, []string{"10.0.0.0-10.0.0.255", "10.0.2.0-10.0.2.255", "10.0.3.0-10.0.3.255", "2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"}, 4},
+		{"region ignoring case", database.RangeQuery{Country: "us", Region: "CALIFORNIA"}, []string{"10.0.0.0-10.0.0.255", "10.0.2.0-10.0.2.255"}, 2},
+		{"city", database.RangeQuery{Country: "US", City: "austin"}, []string{"10.0.3.0-10.0.3.255", "2001:db8::-2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"}, 2},
+		{"page", database.RangeQuery{Country: "US", Offset: 1, Limit: 2}, []string{"10.0.2.0-10.0.2.255", "10.0.3.0-10.0.3.255"}, 4},
+		{"past the end", database.RangeQuery{Country: "US", Offset: 10, Limit: 2}, nil, 4},
+		{"no match", database.RangeQuery{Country: "FR"}, nil, 0},
+	}
+	for _, backend := range []struct {
+		name string
+		db   database.IPDatabase
+	}{{"csv", local}, {"snapshot", snapshot}} {
+		for _, tt := range tests {
+			page, err := database.ListRanges(context.Background(), backend.db, tt.query)
+			if err != nil {
+				t.Fatalf("%s %s: ListRanges() error = %v", backend.name, tt.name, err)
+			}
+			if got := rangeStrings(page); !slices.Equal(got, tt.want) || page.Total != tt.total {
+				t.Errorf("%s %s: ListRanges() = %v of %d, want %v of %d", backend.name, tt.name, got, page.Total, tt.want, tt.total)
+			}
+		}
+	}
+
+	page, _ := database.ListRanges(context.Background(), local, database.RangeQuery{Country: "GB"})
+	if loc := page.Ranges[0].Location; loc.Region != "England" || loc.City != "London" {
+		t.Errorf("ListRanges() location = %+v, want London, England", loc)
+	}
+}
+
+func TestListRanges_Trie(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, `[
+		{"network": "10.0.0.0/16", "country": "US", "city": "Los Angeles"},
+		{"network": "10.0.1.0/24", "country": "GB", "city": "London"},
+		{"network": "10.0.1.128/25", "country": "US", "city": "Austin"}
+	]`)
+	db, err := database.NewIPDatabase(&config.Config{DatabaseType: "json", DatabasePath: path, LookupIndex: "trie"})
+	if err != nil {
+		t.Fatalf("NewIPDatabase() error = %v", err)
+	}
+
+	// Nested ranges are listed as the trie answers them, cut out of the
+	// ranges they nest in
+	tests := []struct {
+		query database.RangeQuery
+		want  []string
+		total int
+	}{
+		{database.RangeQuery{Country: "US"}, []string{"10.0.0.0-10.0.0.255", "10.0.1.128-10.0.1.255", "10.0.2.0-10.0.255.255"}, 3},
+		{database.RangeQuery{Country: "GB"}, []string{"10.0.1.0-10.0.1.127"}, 1},
+		{database.RangeQuery{Country: "US", City: "Los Angeles"}, []string{"10.0.0.0-10.0.0.255", "10.0.2.0-10.0.255.255"}, 2},
+		{database.RangeQuery{Country: "US", Offset: 1, Limit: 1}, []string{"10.0.1.128-10.0.1.255"}, 3},
+	}
+	for _, tt := range tests {
+		page, err := database.ListRanges(context.Background(), db, tt.query)
+		if err != nil {
+			t.Fatalf("ListRanges(%+v) error = %v", tt.query, err)
+		}
+		if got := rangeStrings(page); !slices.Equal(got, tt.want) || page.Total != tt.total {
+			t.Errorf("ListRanges(%+v) = %v of %d, want %v of %d", tt.query, got, page.Total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestListRanges_Overrides(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "ip_database.json")
+	writeDataset(t, basePath, `[{"network": "10.0.0.0/16", "country": "US", "city": "Los Angeles"}]`)
+	base, err := database.NewReloadableDatabase(basePath, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	overridesPath := filepath.Join(dir, "overrides.csv")
+	writeDataset(t, overridesPath, "network,country,city\n10.0.1.0/24,GB,London\n10.0.1.128/25,FR,Paris\n10.0.3.0/24,US,Austin\n")
+	db, err := database.NewOverrideDatabase(base, overridesPath)
+	if err != nil {
+		t.Fatalf("NewOverrideDatabase() error = %v", err)
+	}
+
+	// Ranges are listed as lookups answer them: overrides cut into the
+	// base ranges and into the overrides they nest in
+	tests := []struct {
+		query database.RangeQuery
+		want  []string
+	}{
+		{database.RangeQuery{Country: "US"}, []string{
+			"10.0.0.0-10.0.0.255", "10.0.2.0-10.0.2.255", "10.0.3.0-10.0.3.255 " + models.SourceOverride, "10.0.4.0-10.0.255.255",
+		}},
+		{database.RangeQuery{Country: "GB"}, []string{"10.0.1.0-10.0.1.127 " + models.SourceOverride}},
+		{database.RangeQuery{Country: "US", City: "Los Angeles", Offset: 1, Limit: 1}, []string{"10.0.2.0-10.0.2.255"}},
+	}
+	for _, tt := range tests {
+		page, err := database.ListRanges(context.Background(), db, tt.query)
+		if err != nil {
+			t.Fatalf("ListRanges(%+v) error = %v", tt.query, err)
+		}
+		if got := rangeStrings(page); !slices.Equal(got, tt.want) {
+			t.Errorf("ListRanges(%+v) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestListRanges_Unsupported(t *testing.T) {
+	db := database.FromLegacy(&legacyDatabase{})
+	if _, err := database.ListRanges(context.Background(), db, database.RangeQuery{Country: "US"}); !errors.Is(err, utils.ErrUnsupportedQuery) {
+		t.Errorf("ListRanges() error = %v, want ErrUnsupportedQuery", err)
+	}
+}
+
+func TestRange_CIDRs(t *testing.T) {
+	tests := []struct {
+		rng  string
+		want []string
+	}{
+		{"10.0.0.0/24", []string{"10.0.0.0/24"}},
+		{"10.0.0.0-10.0.2.255", []string{"10.0.0.0/23", "10.0.2.0/24"}},
+		{"10.0.0.1-10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{"2001:db8::-2001:db8::1:ffff", []string{"2001:db8::/111"}},
+	}
+	for _, tt := range tests {
+		r, err := database.ParseRange(tt.rng)
+		if err != nil {
+			t.Fatalf("ParseRange(%s) error = %v", tt.rng, err)
+		}
+		if got := r.CIDRs(); !slices.Equal(got, tt.want) {
+			t.Errorf("CIDRs(%s) = %v, want %v", tt.rng, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package database_test
+
+import (
+	"context"
+	"ip2country-service/internal/database"
+	"testing"
+)
+
+func TestRange_ContainsOverlaps(t *testing.T) {
+	r := database.Range{From: database.IPv4Number(10), To: database.IPv4Number(20)}
+
+	for n, want := range map[uint32]bool{9: false, 10: true, 15: true, 20: true, 21: false} {
+		if got := r.Contains(database.IPv4Number(n)); got != want {
+			t.Errorf("Contains(%d) = %v, want %v", n, got, want)
+		}
+	}
+
+	overlaps := []struct {
+		from, to uint32
+		want     bool
+	}{
+		{0, 9, false},
+		{0, 10, true},
+		{12, 14, true},
+		{20, 30, true},
+		{21, 30, false},
+	}
+	for _, tt := range overlaps {
+		other := database.Range{From: database.IPv4Number(tt.from), To: database.IPv4Number(tt.to)}
+		if got := r.Overlaps(other); got != tt.want {
+			t.Errorf("Overlaps(%d-%d) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestFindRange_SingleAddressFallback(t *testing.T) {
+	// Backends that cannot report ranges answer for exactly the address looked up
+	db := database.FromLegacy(&legacyDatabase{})
+	loc, got, err := database.FindRange(context.Background(), db, "10.0.0.1")
+	if err != nil {
+		t.Fatalf("FindRange() error = %v", err)
+	}
+	want := database.IPv4Number(167772161)
+	if loc == nil || got.From != want || got.To != want {
+		t.Errorf("FindRange() = %+v, %s - %s; want a location for %s only", loc, got.From, got.To, want)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to string
+	}{
+		{"10.1.0.0/16", "167837696", "167903231"},
+		{"10.1.0.0-10.1.255.255", "167837696", "167903231"},
+		{"167837696-167903231", "167837696", "167903231"},
+		{"10.1.2.3", "167838211", "167838211"},
+		{"::ffff:10.1.0.0/112", "167837696", "167903231"},
+		{"2001:db8::/32", "42540766411282592856903984951653826560", "42540766490510755371168322545197776895"},
+		{"2001:db8:: - 2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "42540766411282592856903984951653826560", "42540766490510755371168322545197776895"},
+	}
+	for _, tt := range tests {
+		got, err := database.ParseRange(tt.in)
+		if err != nil {
+			t.Errorf("ParseRange(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got.From.String() != tt.from || got.To.String() != tt.to {
+			t.Errorf("ParseRange(%q) = %s - %s, want %s - %s", tt.in, got.From, got.To, tt.from, tt.to)
+		}
+	}
+
+	for _, in := range []string{"", "10.1.0.0/33", "10.1.0.0-", "10.1.0.300", "example.com"} {
+		if _, err := database.ParseRange(in); err == nil {
+			t.Errorf("ParseRange(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package database_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"ip2country-service/internal/database"
+	"ip2country-service/internal/models"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var loadJSON = database.LocalLoader("json", database.LoadOptions{})
+
+func writeDataset(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	usDataset = `[{"ip_from": 167772160, "ip_to": 167772415, "country": "US", "region": "California", "city": "Los Angeles"}]`
+	caDataset = `[{"ip_from": 167772160, "ip_to": 167772415, "country": "CA", "region": "Ontario", "city": "Toronto"}]`
+)
+
+func TestReloadableDatabase_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, usDataset)
+
+	db, err := database.NewReloadableDatabase(path, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	var reloads atomic.Int32
+	db.OnReload(func() { reloads.Add(1) })
+
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" {
+		t.Fatalf("Find() = %v, %v; want US", loc, err)
+	}
+	usVersion := db.Version()
+
+	writeDataset(t, path, caDataset)
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	caVersion := db.Version()
+	if caVersion == "" || caVersion == usVersion {
+		t.Errorf("Version() after reload = %q, want a new version (was %q)", caVersion, usVersion)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "CA" {
+		t.Errorf("Find() after reload = %v, %v; want CA", loc, err)
+	}
+	if reloads.Load() != 1 {
+		t.Errorf("OnReload listeners called %d times, want 1", reloads.Load())
+	}
+
+	// A broken file must not replace the serving dataset
+	for _, broken := range []string{`[{"ip_from": `, `[]`, `[{"ip_from": 20, "ip_to": 10, "country": "US"}]`} {
+		writeDataset(t, path, broken)
+		if err := db.Reload(); err == nil {
+			t.Errorf("Reload() with %q expected error", broken)
+		}
+		if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "CA" {
+			t.Errorf("Find() after failed reload = %v, %v; want CA", loc, err)
+		}
+	}
+	if reloads.Load() != 1 {
+		t.Errorf("OnReload listeners called %d times after failed reloads, want 1", reloads.Load())
+	}
+	if db.Version() != caVersion {
+		t.Errorf("Version() after failed reloads = %q, want %q", db.Version(), caVersion)
+	}
+}
+
+func TestReloadableDatabase_ReplacedWhileLoading(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ip_database.json")
+	writeDataset(t, path, usDataset)
+
+	// A writer replaces the file after the reload opened it: the snapshot
+	// and its version both come from the file that was opened
+	replace := true
+	db, err := database.NewReloadableDatabase(path, func(file *database.DatasetFile) (database.IPDatabase, error) {
+		if replace {
+			replace = false
+			writeDataset(t, filepath.Join(dir, "next.json"), caDataset)
+			if err := os.Rename(filepath.Join(dir, "next.json"), path); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return loadJSON(file)
+	})
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "US" {
+		t.Errorf("Find() = %v, %v; want US from the file opened", loc, err)
+	}
+	sum := sha256.Sum256([]byte(usDataset))
+	if want := hex.EncodeToString(sum[:])[:16]; db.Version() != want {
+		t.Errorf("Version() = %q, want %q, the digest of the data served", db.Version(), want)
+	}
+}
+
+func TestReloadableDatabase_Unchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, usDataset)
+	db, err := database.NewReloadableDatabase(path, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	var reloads atomic.Int32
+	db.OnReload(func() { reloads.Add(1) })
+	version := db.Version()
+
+	// Rewriting the same contents keeps the snapshot, and the caches built on it
+	future := time.Now().Add(time.Minute).Truncate(time.Second)
+	writeDataset(t, path, usDataset)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if reloads.Load() != 0 || db.Version() != version {
+		t.Errorf("Reload() of unchanged contents called listeners %d times and moved to version %q from %q", reloads.Load(), db.Version(), version)
+	}
+	if !db.ModTime().Equal(future) {
+		t.Errorf("ModTime() = %v, want %v", db.ModTime(), future)
+	}
+}
+
+// closingDatabase holds lookups until release is closed and records being closed
+type closingDatabase struct {
+	database.IPDatabase
+	lookups atomic.Int32
+	release chan struct{}
+	closed  atomic.Bool
+}
+
+func (m *closingDatabase) Find(ctx context.Context, ip string) (*models.Location, error) {
+	m.lookups.Add(1)
+	<-m.release
+	return m.IPDatabase.Find(ctx, ip)
+}
+
+func (m *closingDatabase) Close() error {
+	m.closed.Store(true)
+	return nil
+}
+
+func TestReloadableDatabase_ClosedAfterLastLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, usDataset)
+	var first *closingDatabase
+	db, err := database.NewReloadableDatabase(path, func(file *database.DatasetFile) (database.IPDatabase, error) {
+		loaded, err := loadJSON(file)
+		if err != nil || first != nil {
+			return loaded, err
+		}
+		first = &closingDatabase{IPDatabase: loaded, release: make(chan struct{})}
+		return first, nil
+	})
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+
+	found := make(chan string)
+	go func() {
+		loc, err := db.Find(context.Background(), "10.0.0.1")
+		if err != nil {
+			found <- err.Error()
+			return
+		}
+		found <- loc.Country
+	}()
+	for first.lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The replaced snapshot stays open for as long as the lookup runs against it
+	writeDataset(t, path, caDataset)
+	if err := db.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if first.closed.Load() {
+		t.Fatal("replaced snapshot closed while a lookup was still using it")
+	}
+	close(first.release)
+	if country := <-found; country != "US" {
+		t.Errorf("Find() = %s, want US from the snapshot it started on", country)
+	}
+	if !first.closed.Load() {
+		t.Error("replaced snapshot not closed after its last lookup")
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := db.Find(context.Background(), "10.0.0.1"); err == nil {
+		t.Error("Find() after Close() expected an error")
+	}
+}
+
+func TestReloadableDatabase_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, usDataset)
+
+	db, err := database.NewReloadableDatabase(path, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	reloaded := make(chan struct{}, 1)
+	db.OnReload(func() { reloaded <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go db.Watch(ctx, 10*time.Millisecond)
+
+	writeDataset(t, path, caDataset)
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch() did not reload the changed file")
+	}
+	if loc, err := db.Find(context.Background(), "10.0.0.1"); err != nil || loc.Country != "CA" {
+		t.Errorf("Find() after watch reload = %v, %v; want CA", loc, err)
+	}
+}
+
+func TestNewReloadableDatabase_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+	if _, err := database.NewReloadableDatabase(path, loadJSON); err == nil {
+		t.Error("NewReloadableDatabase() expected error for missing file")
+	}
+}
+
+func TestReloadableDatabase_Health(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.json")
+	writeDataset(t, path, usDataset)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := database.NewReloadableDatabase(path, loadJSON)
+	if err != nil {
+		t.Fatalf("NewReloadableDatabase() error = %v", err)
+	}
+	if err := db.CheckHealth(context.Background()); err != nil {
+		t.Errorf("CheckHealth() error = %v", err)
+	}
+	if !db.ModTime().Equal(modTime) {
+		t.Errorf("ModTime() = %v, want %v", db.ModTime(), modTime)
+	}
+}
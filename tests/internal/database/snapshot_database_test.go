@@ -0,0 +1,128 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	latitude, longitude := 0.0, -0.0931
+	locations := []database.IPLocation{
+		// Out of order; the writer sorts them
+		{IPFrom: database.IPv4Number(167772416), IPTo: database.IPv4Number(167772671), Country: "US", City: "Los Angeles"},
+		{IPFrom: database.IPv4Number(167772160), IPTo: database.IPv4Number(167772415), Country: "GB", Region: "England", City: "London",
+			Latitude: &latitude, Longitude: &longitude, AccuracyRadius: 20, PostalCode: "EC2V", TimeZone: "Europe/London", ASN: 64500, Organization: "Example Networks"},
+	}
+	path := filepath.Join(t.TempDir(), "ip_database.snap")
+	if err := database.WriteSnapshot(path, locations); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+
+	db, err := database.NewSnapshotDatabase(path)
+	if err != nil {
+		t.Fatalf("NewSnapshotDatabase() error = %v", err)
+	}
+	defer db.Close()
+
+	london, matched, err := db.FindRange(context.Background(), "10.0.0.1")
+	if err != nil {
+		t.Fatalf("FindRange() error = %v", err)
+	}
+	if london.Country != "GB" || london.Region != "England" || london.PostalCode != "EC2V" || london.TimeZone != "Europe/London" ||
+		london.ASN != 64500 || london.Organization != "Example Networks" || london.AccuracyRadius != 20 {
+		t.Errorf("FindRange() = %+v, want every field of the London range", london)
+	}
+	if london.Latitude == nil || *london.Latitude != 0 || london.Longitude == nil || *london.Longitude != -0.0931 {
+		t.Errorf("FindRange() coordinates = %v, %v; want 0 and -0.0931", london.Latitude, london.Longitude)
+	}
+	if matched.From != database.IPv4Number(167772160) || matched.To != database.IPv4Number(167772415) {
+		t.Errorf("FindRange() range = %s - %s, want the London range", matched.From, matched.To)
+	}
+
+	la, err := db.Find(context.Background(), "10.0.1.1")
+	if err != nil || la.City != "Los Angeles" || la.Latitude != nil || la.Region != "" {
+		t.Errorf("Find() = %+v, %v; want Los Angeles without details", la, err)
+	}
+}
+
+func TestNewSnapshotDatabase_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.snap")
+	if err := database.WriteSnapshot(path, conformanceLocations(t)); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)-1] ^= 0xff
+	tests := map[string][]byte{
+		"truncated":     data[:len(data)-10],
+		"flipped byte":  flipped,
+		"not snapshot":  []byte(`[{"ip_from": 1, "ip_to": 2, "country": "US"}] padding to the header size........`),
+		"short":         data[:10],
+		"newer version": append(append(append([]byte(nil), data[:8]...), 2, 0, 0, 0), data[12:]...),
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			writeDataset(t, path, string(content))
+			if _, err := database.NewSnapshotDatabase(path); !errors.Is(err, utils.ErrInvalidDataset) {
+				t.Errorf("NewSnapshotDatabase() error = %v, want ErrInvalidDataset", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotDatabase_ValidateOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_database.snap")
+	inverted := []database.IPLocation{{IPFrom: database.IPv4Number(20), IPTo: database.IPv4Number(10), Country: "US"}}
+	if err := database.WriteSnapshot(path, inverted); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	db, err := database.NewSnapshotDatabase(path)
+	if err != nil {
+		t.Fatalf("NewSnapshotDatabase() error = %v", err)
+	}
+	if err := db.Validate(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Validate() error = %v, want ErrInvalidDataset for an inverted range", err)
+	}
+	db.Close()
+
+	// Swap the first two records and fix the checksum up, as a writer that
+	// did not sort its input would leave them
+	if err := database.WriteSnapshot(path, conformanceLocations(t)); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const headerSize = 64
+	recordSize := int(binary.LittleEndian.Uint32(data[12:]))
+	first := bytes.Clone(data[headerSize : headerSize+recordSize])
+	copy(data[headerSize:], data[headerSize+recordSize:headerSize+2*recordSize])
+	copy(data[headerSize+recordSize:], first)
+	binary.LittleEndian.PutUint32(data[48:], crc32.Checksum(data[headerSize:], crc32.MakeTable(crc32.Castagnoli)))
+	writeDataset(t, path, string(data))
+
+	db, err = database.NewSnapshotDatabase(path)
+	if err != nil {
+		t.Fatalf("NewSnapshotDatabase() error = %v", err)
+	}
+	defer db.Close()
+	if err := db.Validate(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Validate() error = %v, want ErrInvalidDataset for unsorted records", err)
+	}
+	if err := db.CheckHealth(context.Background()); err != nil {
+		t.Errorf("CheckHealth() error = %v, want nil as it only checks for ranges", err)
+	}
+}
@@ -0,0 +1,131 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestTrieDatabase_LongestPrefixMatch(t *testing.T) {
+	db := database.NewTrieDatabase(database.DatabaseLocal{Locations: []database.IPLocation{
+		ipv4Location(0x0a000000, 0x0affffff, "US"), // 10.0.0.0/8
+		ipv4Location(0x0a010000, 0x0a01ffff, "GB"), // 10.1.0.0/16
+		ipv4Location(0x0a010200, 0x0a0102ff, "FR"), // 10.1.2.0/24
+		ipv4Location(0x0a020005, 0x0a020014, "DE"), // 10.2.0.5 - 10.2.0.20, five prefixes
+		{IPFrom: mustParseIPNumber(t, "42540766411282592856903984951653826560"), IPTo: mustParseIPNumber(t, "42540766490510755371168322545197776895"), Country: "NL"}, // 2001:db8::/32
+		{IPFrom: mustParseIPNumber(t, "42540766411283801782723599580828532736"), IPTo: mustParseIPNumber(t, "42540766411285010708543214210003238911"), Country: "BE"}, // 2001:db8:1::/48
+	}})
+
+	// Each answer comes with the largest prefix around the address that no more specific range cuts into
+	tests := []struct {
+		ip       string
+		country  string
+		from, to string
+	}{
+		{"10.1.2.3", "FR", "10.1.2.0", "10.1.2.255"},
+		{"::ffff:10.1.2.3", "FR", "10.1.2.0", "10.1.2.255"},
+		{"10.1.3.3", "GB", "10.1.3.0", "10.1.3.255"},
+		{"10.1.128.1", "GB", "10.1.128.0", "10.1.255.255"},
+		{"10.200.0.1", "US", "10.128.0.0", "10.255.255.255"},
+		{"10.2.0.10", "DE", "10.2.0.8", "10.2.0.15"},
+		{"10.2.0.20", "DE", "10.2.0.20", "10.2.0.20"},
+		{"10.2.0.4", "US", "10.2.0.4", "10.2.0.4"},
+		{"2001:db8:1::1", "BE", "2001:db8:1::", "2001:db8:1:ffff:ffff:ffff:ffff:ffff"},
+		{"2001:db8:8000::1", "NL", "2001:db8:8000::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, tt := range tests {
+		loc, matched, err := db.FindRange(context.Background(), tt.ip)
+		if err != nil {
+			t.Errorf("FindRange(%s) error = %v", tt.ip, err)
+			continue
+		}
+		if loc.Country != tt.country {
+			t.Errorf("FindRange(%s) country = %s, want %s", tt.ip, loc.Country, tt.country)
+		}
+		if matched.From.IP().String() != tt.from || matched.To.IP().String() != tt.to {
+			t.Errorf("FindRange(%s) range = %s - %s, want %s - %s", tt.ip, matched.From.IP(), matched.To.IP(), tt.from, tt.to)
+		}
+	}
+
+	for _, ip := range []string{"11.0.0.1", "9.255.255.255", "2001:db9::1"} {
+		if _, err := db.Find(context.Background(), ip); !errors.Is(err, utils.ErrIpNotFound) {
+			t.Errorf("Find(%s) error = %v, want ErrIpNotFound", ip, err)
+		}
+	}
+}
+
+func TestTrieDatabase_SharedPrefix(t *testing.T) {
+	// Both ranges cover 10.0.0.0/24: the narrower one answers for it, wherever it is listed
+	db := database.NewTrieDatabase(database.DatabaseLocal{Locations: []database.IPLocation{
+		ipv4Location(0x0a000000, 0x0a0000ff, "GB"),
+		ipv4Location(0x0a000000, 0x0a00017f, "US"),
+		ipv4Location(0x0b000000, 0x0b0000ff, "FR"),
+		ipv4Location(0x0b000000, 0x0b0000ff, "DE"), // an exact duplicate, which replaces the first
+	}})
+
+	tests := map[string]string{"10.0.0.1": "GB", "10.0.1.1": "US", "11.0.0.1": "DE"}
+	for ip, want := range tests {
+		loc, err := db.Find(context.Background(), ip)
+		if err != nil || loc.Country != want {
+			t.Errorf("Find(%s) = %+v, %v, want %s", ip, loc, err, want)
+		}
+	}
+}
+
+// benchmarkLocations returns n consecutive /24 ranges starting at 1.0.0.0
+func benchmarkLocations(n int) []database.IPLocation {
+	countries := []string{"US", "GB", "DE", "FR", "JP", "BR", "IN", "AU"}
+	locations := make([]database.IPLocation, n)
+	for i := range locations {
+		from := uint32(1<<24 + i<<8)
+		locations[i] = database.IPLocation{IPFrom: database.IPv4Number(from), IPTo: database.IPv4Number(from + 255), Country: countries[i%len(countries)]}
+	}
+	return locations
+}
+
+// BenchmarkLookup compares binary search over the sorted ranges with the
+// trie. The 10M case needs a few GB of memory and is skipped with -short.
+func BenchmarkLookup(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for _, n := range []int{1_000_000, 10_000_000} {
+		b.Run(fmt.Sprintf("ranges=%d", n), func(b *testing.B) {
+			if testing.Short() && n > 1_000_000 {
+				b.Skip("skipping the largest dataset in short mode")
+			}
+			local := database.DatabaseLocal{Locations: benchmarkLocations(n)}
+
+			// Look up addresses spread over the dataset, in the same order for each index
+			rng := rand.New(rand.NewSource(1))
+			ips := make([]string, 4096)
+			for i := range ips {
+				ips[i] = net.IPv4(byte(1+rng.Intn(n>>16)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))).String()
+			}
+
+			indexes := []struct {
+				name string
+				db   database.IPDatabase
+			}{
+				{"binary", &database.JSONDatabase{DatabaseLocal: local}},
+				{"trie", database.NewTrieDatabase(local)},
+			}
+			for _, index := range indexes {
+				b.Run(index.name, func(b *testing.B) {
+					ctx := context.Background()
+					for i := 0; i < b.N; i++ {
+						index.db.Find(ctx, ips[i%len(ips)])
+					}
+				})
+			}
+		})
+	}
+}
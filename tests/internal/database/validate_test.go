@@ -0,0 +1,187 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"ip2country-service/internal/database"
+	"ip2country-service/pkg/utils"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func ipv4Location(from, to uint32, country string) database.IPLocation {
+	return database.IPLocation{IPFrom: database.IPv4Number(from), IPTo: database.IPv4Number(to), Country: country}
+}
+
+func TestValidateLocations(t *testing.T) {
+	locations := []database.IPLocation{
+		ipv4Location(0, 1<<30-1, "US"), // a quarter of IPv4
+		ipv4Location(1<<30, 1<<30+99, "GB"),
+		ipv4Location(1<<30, 1<<30+99, "FR"),    // duplicate
+		ipv4Location(1<<30+50, 1<<30+50, "DE"), // overlaps the two above
+		ipv4Location(1<<31, 1<<31+9, "XX"),     // unknown country
+		ipv4Location(1<<31+20, 1<<31+10, "CA"), // inverted
+		{IPFrom: mustParseIPNumber(t, "42540766411282592856903984951653826560"), IPTo: mustParseIPNumber(t, "42540766490510755371168322545197776895"), Country: "DE"},
+	}
+
+	report := database.ValidateLocations(locations)
+	if report.Ranges != 7 || report.Duplicates != 1 || report.Overlapping != 1 || report.Inverted != 1 || report.UnknownCountries != 1 {
+		t.Errorf("ValidateLocations() = %s, want 1 duplicate, 1 overlapping, 1 inverted and 1 unknown country", report)
+	}
+	if report.Issues() != 4 || len(report.Examples) != 4 {
+		t.Errorf("Issues() = %d with examples %q, want 4", report.Issues(), report.Examples)
+	}
+	// The gap before XX; the IPv6 range starts a new address family rather than a gap
+	if report.Gaps != 1 || len(report.GapExamples) != 1 || report.GapExamples[0] != "64.0.0.100 - 127.255.255.255" {
+		t.Errorf("Gaps = %d with examples %q, want 64.0.0.100 - 127.255.255.255", report.Gaps, report.GapExamples)
+	}
+
+	wantIPv4 := float64(1<<30+100+10) / math.Exp2(32) * 100
+	if math.Abs(report.IPv4Coverage-wantIPv4) > 1e-9 {
+		t.Errorf("IPv4Coverage = %v, want %v", report.IPv4Coverage, wantIPv4)
+	}
+	// 2001:db8::/32 is 2^-32 of the IPv6 space
+	if wantIPv6 := math.Exp2(-32) * 100; math.Abs(report.IPv6Coverage-wantIPv6) > 1e-15 {
+		t.Errorf("IPv6Coverage = %v, want %v", report.IPv6Coverage, wantIPv6)
+	}
+}
+
+func TestValidateLocations_DuplicatesApart(t *testing.T) {
+	// Duplicates are found even with a range sharing their start between
+	// them, as the loaders sort identical ranges next to each other
+	path := filepath.Join(t.TempDir(), "ip_database.csv")
+	writeDataset(t, path, "network,country\n"+
+		"10.0.0.0/24,US\n"+
+		"10.0.0.0/25,GB\n"+
+		"10.0.0.0/24,FR\n"+
+		"10.0.0.0-10.0.0.9,DE\n")
+	locations, err := database.ReadLocations("csv", path, database.CSVOptions{})
+	if err != nil {
+		t.Fatalf("ReadLocations() error = %v", err)
+	}
+	if report := database.ValidateLocations(locations); report.Duplicates != 1 || report.Overlapping != 2 {
+		t.Errorf("ValidateLocations() = %s, want 1 duplicate and 2 overlapping", report)
+	}
+
+	// Of identical ranges, the first in the file answers
+	db := database.DatabaseLocal{Locations: locations}
+	if loc, err := db.Find(context.Background(), "10.0.0.200"); err != nil || loc.Country != "US" {
+		t.Errorf("Find() = %+v, %v; want US, the first of the duplicates", loc, err)
+	}
+}
+
+func TestValidateLocations_Clean(t *testing.T) {
+	report := database.ValidateLocations([]database.IPLocation{ipv4Location(0, 9, "US"), ipv4Location(10, 19, "GB")})
+	if report.Issues() != 0 || len(report.Examples) != 0 || report.Gaps != 0 {
+		t.Errorf("ValidateLocations() = %s %q, want no issues or gaps", report, report.Examples)
+	}
+}
+
+func TestValidateLocations_Gaps(t *testing.T) {
+	// Gaps are counted past the furthest range so far, not the last one
+	var locations []database.IPLocation
+	locations = append(locations, ipv4Location(0, 99, "US"), ipv4Location(10, 19, "GB"))
+	for i := uint32(1); i <= 12; i++ {
+		locations = append(locations, ipv4Location(i*100+50, i*100+99, "FR"))
+	}
+
+	report := database.ValidateLocations(locations)
+	if report.Gaps != 12 || report.Issues() != 1 {
+		t.Errorf("ValidateLocations() = %s, want 12 gaps and the overlap as the only issue", report)
+	}
+	if len(report.GapExamples) != 10 || report.GapExamples[0] != "0.0.0.100 - 0.0.0.149" {
+		t.Errorf("GapExamples = %q, want the first 10 gaps starting with 0.0.0.100 - 0.0.0.149", report.GapExamples)
+	}
+}
+
+func TestDatabaseLocal_ValidateModes(t *testing.T) {
+	overlapping := []database.IPLocation{ipv4Location(0, 99, "US"), ipv4Location(50, 149, "GB")}
+
+	lenient := database.DatabaseLocal{Locations: overlapping}
+	if err := lenient.Validate(); err != nil {
+		t.Errorf("Validate() in lenient mode error = %v, want the dataset accepted", err)
+	}
+
+	strict := database.DatabaseLocal{Locations: overlapping, Validation: database.ValidationStrict}
+	if err := strict.Validate(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Validate() in strict mode error = %v, want ErrInvalidDataset", err)
+	}
+
+	// A dataset left without ranges is refused in either mode
+	inverted := database.DatabaseLocal{Locations: []database.IPLocation{ipv4Location(99, 0, "US")}}
+	if err := inverted.Validate(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Validate() error = %v, want ErrInvalidDataset for a dataset of inverted ranges", err)
+	}
+}
+
+func TestDatabaseLocal_ValidateInverted(t *testing.T) {
+	locations := func() []database.IPLocation {
+		return []database.IPLocation{ipv4Location(0, 9, "US"), ipv4Location(30, 20, "GB"), ipv4Location(40, 49, "FR")}
+	}
+
+	// Inverted ranges cannot be searched, so lenient mode drops and counts them
+	lenient := database.DatabaseLocal{Locations: locations()}
+	if err := lenient.Validate(); err != nil {
+		t.Fatalf("Validate() in lenient mode error = %v, want the dataset accepted", err)
+	}
+	if len(lenient.Locations) != 2 || lenient.Locations[1].Country != "FR" {
+		t.Errorf("Validate() left %+v, want the inverted range dropped", lenient.Locations)
+	}
+	if got := validationIssues(t, "inverted"); got != 1 {
+		t.Errorf("dataset_validation_issues{issue=\"inverted\"} = %v, want 1", got)
+	}
+	if _, err := lenient.Find(context.Background(), "0.0.0.45"); err != nil {
+		t.Errorf("Find() error = %v, want the range after the inverted one found", err)
+	}
+
+	// The trie drops them before indexing, so its indexes survive Validate
+	trie := database.NewTrieDatabase(database.DatabaseLocal{Locations: locations()})
+	if err := trie.Validate(); err != nil {
+		t.Fatalf("Validate() of the trie error = %v", err)
+	}
+	if loc, err := trie.Find(context.Background(), "0.0.0.45"); err != nil || loc.Country != "FR" {
+		t.Errorf("Find() = %+v, %v; want FR", loc, err)
+	}
+
+	strict := database.DatabaseLocal{Locations: locations(), Validation: database.ValidationStrict}
+	if err := strict.Validate(); !errors.Is(err, utils.ErrInvalidDataset) {
+		t.Errorf("Validate() in strict mode error = %v, want ErrInvalidDataset for an inverted range", err)
+	}
+}
+
+// validationIssues returns the value of dataset_validation_issues for issue
+func validationIssues(t *testing.T, issue string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "dataset_validation_issues" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "issue" && label.GetValue() == issue {
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("dataset_validation_issues{issue=%q} is not set", issue)
+	return 0
+}
+
+func TestParseValidationMode(t *testing.T) {
+	for in, want := range map[string]database.ValidationMode{"": database.ValidationLenient, "lenient": database.ValidationLenient, "STRICT": database.ValidationStrict} {
+		if got, err := database.ParseValidationMode(in); err != nil || got != want {
+			t.Errorf("ParseValidationMode(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := database.ParseValidationMode("off"); err == nil {
+		t.Error("ParseValidationMode(\"off\") succeeded, want an error")
+	}
+}
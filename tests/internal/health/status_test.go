@@ -0,0 +1,60 @@
+package health_test
+
+import (
+	"context"
+	"ip2country-service/internal/health"
+	"testing"
+	"time"
+)
+
+func TestStatus_Ready(t *testing.T) {
+	status := health.NewStatus(20 * time.Millisecond)
+	status.Register("fast", func(ctx context.Context) error { return nil })
+	status.Register("hanging", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	report := status.Ready(context.Background())
+	if report.Status != health.StatusFail {
+		t.Errorf("expected the report to fail, got %s", report.Status)
+	}
+
+	expected := map[string]string{"draining": health.StatusOK, "fast": health.StatusOK, "hanging": health.StatusFail}
+	if len(report.Checks) != len(expected) {
+		t.Fatalf("expected %d checks, got %+v", len(expected), report.Checks)
+	}
+	for _, check := range report.Checks {
+		if check.Status != expected[check.Name] {
+			t.Errorf("check %s: expected %s, got %s (%s)", check.Name, expected[check.Name], check.Status, check.Error)
+		}
+	}
+	if hanging := report.Checks[2]; hanging.LatencyMs < 20 || hanging.Error == "" {
+		t.Errorf("expected the hanging check to time out after 20ms with an error, got %+v", hanging)
+	}
+}
+
+func TestStatus_Draining(t *testing.T) {
+	status := health.NewStatus(time.Second)
+	if report := status.Ready(context.Background()); report.Status != health.StatusOK {
+		t.Fatalf("expected a fresh status to be ready, got %+v", report)
+	}
+
+	status.StartDraining()
+	report := status.Ready(context.Background())
+	if report.Status != health.StatusFail || report.Checks[0].Name != "draining" || report.Checks[0].Status != health.StatusFail {
+		t.Errorf("expected the draining check to fail, got %+v", report)
+	}
+}
+
+func TestDatasetAge(t *testing.T) {
+	check := health.DatasetAge(time.Hour, func() time.Time { return time.Now().Add(-30 * time.Minute) })
+	if err := check(context.Background()); err != nil {
+		t.Errorf("expected a 30 minute old dataset to pass, got %v", err)
+	}
+
+	check = health.DatasetAge(time.Hour, func() time.Time { return time.Now().Add(-2 * time.Hour) })
+	if err := check(context.Background()); err == nil {
+		t.Error("expected a 2 hour old dataset to fail")
+	}
+}
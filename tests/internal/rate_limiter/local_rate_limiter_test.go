@@ -1,6 +1,8 @@
 package rate_limiter_test
 
 import (
+	"ip2country-service/internal/auth"
+	"ip2country-service/internal/client_ip"
 	"ip2country-service/internal/rate_limiter"
 	"net/http"
 	"net/http/httptest"
@@ -41,3 +43,148 @@ func TestLocalRateLimiter(t *testing.T) {
 		t.Errorf("Expected status OK, got %v", w.Result().StatusCode)
 	}
 }
+
+func TestLocalRateLimiter_BehindTrustedProxy(t *testing.T) {
+	limiter := rate_limiter.NewLocalRateLimiter(0.001, 1, time.Millisecond)
+	resolver, err := client_ip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := resolver.Middleware(limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// Each client behind the load balancer gets its own bucket
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.RemoteAddr = "10.0.0.2:4000"
+		req.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("request from %s: expected status OK, got %v", client, w.Code)
+		}
+	}
+}
+
+func TestLocalRateLimiter_Headers(t *testing.T) {
+	// 0.5 tokens per second: a drained bucket of 2 takes 4s to refill, 2s per token
+	limiter := rate_limiter.NewLocalRateLimiter(0.5, 2, time.Millisecond)
+	handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	expected := []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{http.StatusOK, "1", "2", ""},
+		{http.StatusOK, "0", "4", ""},
+		{http.StatusTooManyRequests, "0", "4", "2"},
+	}
+	for i, exp := range expected {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil))
+
+		if w.Code != exp.status {
+			t.Errorf("request %d: expected status %d, got %d", i, exp.status, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: expected RateLimit-Limit 2, got %q", i, got)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != exp.remaining {
+			t.Errorf("request %d: expected RateLimit-Remaining %s, got %q", i, exp.remaining, got)
+		}
+		if got := w.Header().Get("RateLimit-Reset"); got != exp.reset {
+			t.Errorf("request %d: expected RateLimit-Reset %s, got %q", i, exp.reset, got)
+		}
+		if got := w.Header().Get("Retry-After"); got != exp.retryAfter {
+			t.Errorf("request %d: expected Retry-After %q, got %q", i, exp.retryAfter, got)
+		}
+	}
+}
+
+func TestLocalRateLimiter_PerKeyQuota(t *testing.T) {
+	limiter := rate_limiter.NewLocalRateLimiter(0.001, 1, time.Millisecond)
+	handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	gold := &auth.Principal{Name: "acme", RateLimit: 0.001, RateCapacity: 3}
+
+	// The key's capacity replaces the default, and its bucket follows the key across addresses
+	for i, remoteAddr := range []string{"198.51.100.1:1", "198.51.100.2:1", "198.51.100.3:1", "198.51.100.4:1"} {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.RemoteAddr = remoteAddr
+		req = req.WithContext(auth.WithPrincipal(req.Context(), gold))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		expected := http.StatusOK
+		if i == 3 {
+			expected = http.StatusTooManyRequests
+		}
+		if w.Code != expected {
+			t.Errorf("request %d: expected status %d, got %d", i, expected, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "3" {
+			t.Errorf("request %d: expected RateLimit-Limit 3, got %q", i, got)
+		}
+	}
+
+	// Anonymous callers keep a bucket per address
+	anonymous := &auth.Principal{Name: "anonymous", Anonymous: true}
+	req := httptest.NewRequest("GET", "http://example.com", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), anonymous))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected anonymous request to use its own bucket, got %d", w.Code)
+	}
+}
+
+func TestLocalRateLimiter_LimitFailedAuth(t *testing.T) {
+	limiter := rate_limiter.NewLocalRateLimiter(0.001, 2, time.Millisecond)
+	// Stands in for the authenticator: only the key "good" is accepted
+	handler := limiter.LimitFailedAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.APIKeyHeader) != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(addr, key string) int {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.RemoteAddr = addr
+		req.Header.Set(auth.APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Authenticated requests are not charged
+	for i := 0; i < 3; i++ {
+		if code := send("192.0.2.1:1234", "good"); code != http.StatusOK {
+			t.Fatalf("authenticated request %d: expected 200, got %d", i, code)
+		}
+	}
+
+	// Each failure takes a token, and an empty bucket refuses even a valid key
+	for i := 0; i < 2; i++ {
+		if code := send("192.0.2.1:1234", "guess"); code != http.StatusUnauthorized {
+			t.Fatalf("failed attempt %d: expected 401, got %d", i, code)
+		}
+	}
+	if code := send("192.0.2.1:1234", "guess"); code != http.StatusTooManyRequests {
+		t.Errorf("attempt after the bucket emptied: expected 429, got %d", code)
+	}
+	if code := send("192.0.2.1:1234", "good"); code != http.StatusTooManyRequests {
+		t.Errorf("valid key from a throttled address: expected 429, got %d", code)
+	}
+
+	// Other addresses keep their own bucket
+	if code := send("192.0.2.2:1234", "guess"); code != http.StatusUnauthorized {
+		t.Errorf("failed attempt from another address: expected 401, got %d", code)
+	}
+}
@@ -1,8 +1,11 @@
 package rate_limiter_test
 
 import (
+	"context"
 	"ip2country-service/config"
 	"ip2country-service/internal/rate_limiter"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -36,3 +39,37 @@ func TestNewRateLimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestCharge(t *testing.T) {
+	// Requests that did not pass through a limiter are never charged
+	if allowed, err := rate_limiter.Charge(context.Background(), 10); !allowed || err != nil {
+		t.Errorf("Charge() without limiter = %v, %v; want true, nil", allowed, err)
+	}
+	if _, ok := rate_limiter.Capacity(context.Background()); ok {
+		t.Error("Capacity() without limiter reported a bucket")
+	}
+
+	limiter := rate_limiter.NewLocalRateLimiter(0.001, 5, time.Millisecond)
+	var results []bool
+	handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if capacity, ok := rate_limiter.Capacity(r.Context()); !ok || capacity != 5 {
+			t.Errorf("Capacity() = %v, %v; want 5, true", capacity, ok)
+		}
+		for _, cost := range []float64{3, 2, 1} {
+			allowed, err := rate_limiter.Charge(r.Context(), cost)
+			if err != nil {
+				t.Fatalf("Charge() error = %v", err)
+			}
+			results = append(results, allowed)
+		}
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com", nil))
+
+	// Limit takes 1 of 5 tokens, then 3 fits, 2 does not and 1 does
+	want := []bool{true, false, true}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("Charge() call %d = %v, want %v", i, results[i], want[i])
+		}
+	}
+}